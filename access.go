@@ -0,0 +1,69 @@
+package sftpfs
+
+import (
+	"os"
+
+	"github.com/pkg/sftp"
+)
+
+// AccessMode is a set of permissions checked by Access, with the bit
+// values of access(2).
+type AccessMode uint32
+
+const (
+	AccessExecute AccessMode = 1 << iota // X_OK
+	AccessWrite                          // W_OK
+	AccessRead                           // R_OK
+)
+
+// Access reports whether the connected user may access the named file as
+// mode, returning an *os.PathError wrapping os.ErrPermission if not. A mode
+// of 0 only checks that the file exists.
+//
+// SFTP does not reveal the user's ids, so they are taken from the owner of
+// UserHomeDir, and the owner, group or other permission bits of the file
+// are checked as for a local file. Supplementary groups and ACLs are not
+// known, so the server may still decide differently. Without ownership
+// information only the other bits are used.
+func (fs *FileSystem) Access(name string, mode AccessMode) error {
+	info, err := fs.Stat(name)
+	if err != nil {
+		return err
+	}
+	if mode == 0 {
+		return nil
+	}
+	home, err := fs.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	homeInfo, err := fs.Stat(home)
+	if err != nil {
+		return err
+	}
+
+	perm := AccessMode(info.Mode().Perm())
+	file, fileOK := info.Sys().(*sftp.FileStat)
+	user, userOK := homeInfo.Sys().(*sftp.FileStat)
+	var granted AccessMode
+	switch {
+	case !fileOK || !userOK:
+		granted = perm & 7
+	case user.UID == 0:
+		// root may do anything, but execute only what is executable by someone
+		granted = AccessRead | AccessWrite
+		if info.IsDir() || perm&0111 != 0 {
+			granted |= AccessExecute
+		}
+	case file.UID == user.UID:
+		granted = perm >> 6 & 7
+	case file.GID == user.GID:
+		granted = perm >> 3 & 7
+	default:
+		granted = perm & 7
+	}
+	if granted&mode != mode {
+		return &os.PathError{Op: "access", Path: fs.abs(name), Err: os.ErrPermission}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package sftpfs
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+	"github.com/pkg/sftp"
+)
+
+func TestAccess(t *testing.T) {
+	client := newMockSFTPClient()
+	owned := func(mode os.FileMode, uid, gid uint32) *mocks.MockFileInfo {
+		return &mocks.MockFileInfo{FileMode: mode, FileIsDir: mode.IsDir(), FileSys: &sftp.FileStat{UID: uid, GID: gid}}
+	}
+	client.fileInfos["/home/alice"] = owned(os.ModeDir|0755, 1000, 100)
+	client.fileInfos["/mine"] = owned(0600, 1000, 100)
+	client.fileInfos["/shared"] = owned(0640, 2000, 100)
+	client.fileInfos["/other"] = owned(0604, 2000, 200)
+	client.fileInfos["/script"] = owned(0755, 2000, 200)
+	client.fileInfos["/nosys"] = &mocks.MockFileInfo{FileMode: 0664}
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+	home := "/home/alice"
+	fs.home.Store(&home)
+
+	tests := []struct {
+		name string
+		mode AccessMode
+		ok   bool
+	}{
+		{"/mine", AccessRead | AccessWrite, true},
+		{"/mine", AccessExecute, false},
+		{"/shared", AccessRead, true},
+		{"/shared", AccessWrite, false},
+		{"/other", AccessRead, true},
+		{"/other", AccessWrite, false},
+		{"/script", AccessRead | AccessExecute, true},
+		{"/script", AccessWrite, false},
+		{"/nosys", AccessRead, true},
+		{"/nosys", AccessWrite, false},
+		{"/home/alice", AccessRead | AccessWrite | AccessExecute, true},
+		{"/script", 0, true},
+	}
+	for _, tc := range tests {
+		err := fs.Access(tc.name, tc.mode)
+		if tc.ok && err != nil {
+			t.Errorf("Access(%s, %v) = %v, want nil", tc.name, tc.mode, err)
+		}
+		if !tc.ok && !errors.Is(err, os.ErrPermission) {
+			t.Errorf("Access(%s, %v) = %v, want os.ErrPermission", tc.name, tc.mode, err)
+		}
+	}
+
+	if err := fs.Access("/missing", AccessRead); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Access on a missing file = %v, want os.ErrNotExist", err)
+	}
+
+	client.fileInfos["/home/alice"] = owned(os.ModeDir|0755, 0, 0)
+	if err := fs.Access("/other", AccessRead|AccessWrite); err != nil {
+		t.Errorf("Access as root = %v, want nil", err)
+	}
+	if err := fs.Access("/mine", AccessExecute); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("root execute of a non-executable file = %v, want os.ErrPermission", err)
+	}
+}
@@ -0,0 +1,53 @@
+package sftpfs
+
+import (
+	"os"
+
+	"github.com/pkg/sftp"
+)
+
+// AttrMask selects the attributes copied by CopyAttrs.
+type AttrMask uint
+
+const (
+	AttrMode  AttrMask = 1 << iota // permission, setuid, setgid and sticky bits
+	AttrTimes                      // access and modification times
+	AttrOwner                      // owner and group ids
+
+	AttrAll = AttrMode | AttrTimes | AttrOwner
+)
+
+// CopyAttrs stats src and applies the attributes selected by which to dst,
+// leaving the others untouched, as when restoring a file's metadata after
+// copying its contents. Owner ids need raw attributes from the server; if
+// src's are missing, AttrOwner fails with an error wrapping ErrUnsupported
+// instead of giving dst to root.
+func (fs *FileSystem) CopyAttrs(src, dst string, which AttrMask) error {
+	src, dst = fs.abs(src), fs.abs(dst)
+	info, err := fs.Stat(src)
+	if err != nil {
+		return err
+	}
+	st := statExtended(info)
+
+	if which&AttrMode != 0 {
+		mode := st.Mode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		if err := fs.Chmod(dst, mode); err != nil {
+			return err
+		}
+	}
+	if which&AttrTimes != 0 {
+		if err := fs.Chtimes(dst, st.Atime, st.Mtime); err != nil {
+			return err
+		}
+	}
+	if which&AttrOwner != 0 {
+		if _, ok := info.Sys().(*sftp.FileStat); !ok {
+			return &os.PathError{Op: "chown", Path: src, Err: ErrUnsupported}
+		}
+		if err := fs.Chown(dst, int(st.UID), int(st.GID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
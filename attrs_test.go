@@ -0,0 +1,89 @@
+package sftpfs
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+	"github.com/pkg/sftp"
+)
+
+// attrClient records the attribute changes made to each path.
+type attrClient struct {
+	*mockSFTPClient
+	changes map[string][]string
+	mode    os.FileMode
+	mtime   time.Time
+	uid     int
+}
+
+func (c *attrClient) Chmod(path string, mode os.FileMode) error {
+	c.changes[path] = append(c.changes[path], "mode")
+	c.mode = mode
+	return nil
+}
+
+func (c *attrClient) Chtimes(path string, atime, mtime time.Time) error {
+	c.changes[path] = append(c.changes[path], "times")
+	c.mtime = mtime
+	return nil
+}
+
+func (c *attrClient) Chown(path string, uid, gid int) error {
+	c.changes[path] = append(c.changes[path], "owner")
+	c.uid = uid
+	return nil
+}
+
+func TestCopyAttrs(t *testing.T) {
+	mtime := time.Unix(1500000000, 0)
+	client := &attrClient{mockSFTPClient: newMockSFTPClient(), changes: make(map[string][]string)}
+	client.fileInfos["/src"] = &mocks.MockFileInfo{
+		FileMode:    0750 | os.ModeSetgid,
+		FileModTime: mtime,
+		FileSys:     &sftp.FileStat{UID: 1001, GID: 50, Atime: uint32(mtime.Unix()), Mtime: uint32(mtime.Unix())},
+	}
+	client.fileInfos["/bare"] = &mocks.MockFileInfo{FileMode: 0644}
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+
+	for _, tc := range []struct {
+		which AttrMask
+		want  []string
+	}{
+		{AttrMode, []string{"mode"}},
+		{AttrTimes, []string{"times"}},
+		{AttrOwner, []string{"owner"}},
+		{AttrMode | AttrOwner, []string{"mode", "owner"}},
+		{AttrAll, []string{"mode", "times", "owner"}},
+	} {
+		client.changes["/dst"] = nil
+		if err := fs.CopyAttrs("/src", "/dst", tc.which); err != nil {
+			t.Fatalf("CopyAttrs(%b) failed: %v", tc.which, err)
+		}
+		got := client.changes["/dst"]
+		if len(got) != len(tc.want) {
+			t.Errorf("CopyAttrs(%b) changed %v, want %v", tc.which, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("CopyAttrs(%b) changed %v, want %v", tc.which, got, tc.want)
+			}
+		}
+	}
+	if client.mode != 0750|os.ModeSetgid || !client.mtime.Equal(mtime) || client.uid != 1001 {
+		t.Errorf("Copied mode %v, mtime %v, uid %d", client.mode, client.mtime, client.uid)
+	}
+	if len(client.changes["/src"]) != 0 {
+		t.Errorf("CopyAttrs changed the source: %v", client.changes["/src"])
+	}
+
+	if err := fs.CopyAttrs("/bare", "/dst", AttrOwner); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("CopyAttrs of owner without raw attributes = %v, want ErrUnsupported", err)
+	}
+	if err := fs.CopyAttrs("/missing", "/dst", AttrAll); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("CopyAttrs from a missing file = %v, want os.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,118 @@
+package sftpfs
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultAuthBanDuration is used when ServerConfig.MaxAuthAttemptsPerIP is
+// set and AuthBanDuration is 0.
+const DefaultAuthBanDuration = 10 * time.Minute
+
+// ErrAddressBanned is returned by the server's connection handler, and
+// by authentication attempts in progress, for a remote address banned
+// after too many failed authentications.
+var ErrAddressBanned = errors.New("too many failed authentication attempts from this address")
+
+// authLimiter counts failed authentications per remote IP and bans an IP
+// that reaches max failures within one ban duration.
+type authLimiter struct {
+	max int
+	ban time.Duration
+	now func() time.Time
+
+	mu    sync.Mutex
+	hosts map[string]*authRecord
+}
+
+// authRecord tracks the failures of one IP in the current window.
+type authRecord struct {
+	failures    int
+	windowStart time.Time
+	bannedUntil time.Time
+}
+
+func newAuthLimiter(max int, ban time.Duration) *authLimiter {
+	if ban <= 0 {
+		ban = DefaultAuthBanDuration
+	}
+	return &authLimiter{max: max, ban: ban, now: time.Now, hosts: make(map[string]*authRecord)}
+}
+
+// hostOf returns the IP of addr without the port.
+func hostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// banned reports whether ip is currently banned. A nil limiter bans
+// nothing.
+func (l *authLimiter) banned(ip string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r, ok := l.hosts[ip]
+	return ok && l.now().Before(r.bannedUntil)
+}
+
+// failed records a failed authentication from ip, banning it on reaching
+// the limit.
+func (l *authLimiter) failed(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for host, r := range l.hosts {
+		if now.Sub(r.windowStart) >= l.ban && !now.Before(r.bannedUntil) {
+			delete(l.hosts, host)
+		}
+	}
+	r, ok := l.hosts[ip]
+	if !ok {
+		r = &authRecord{windowStart: now}
+		l.hosts[ip] = r
+	}
+	if r.failures++; r.failures >= l.max {
+		r.bannedUntil = now.Add(l.ban)
+		r.failures = 0
+		r.windowStart = r.bannedUntil
+	}
+}
+
+// succeeded forgets the failures of ip after it authenticates.
+func (l *authLimiter) succeeded(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r, ok := l.hosts[ip]; ok && !l.now().Before(r.bannedUntil) {
+		delete(l.hosts, ip)
+	}
+}
+
+// limitAuth wraps an authentication callback to record its outcome and to
+// reject every attempt from a banned address.
+func limitAuth[T any](l *authLimiter, cb func(ssh.ConnMetadata, T) (*ssh.Permissions, error)) func(ssh.ConnMetadata, T) (*ssh.Permissions, error) {
+	if cb == nil {
+		return nil
+	}
+	return func(conn ssh.ConnMetadata, cred T) (*ssh.Permissions, error) {
+		ip := hostOf(conn.RemoteAddr())
+		if l.banned(ip) {
+			return nil, ErrAddressBanned
+		}
+		perms, err := cb(conn, cred)
+		if err != nil {
+			l.failed(ip)
+		} else {
+			l.succeeded(ip)
+		}
+		return perms, err
+	}
+}
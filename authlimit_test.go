@@ -0,0 +1,95 @@
+package sftpfs
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuthLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newAuthLimiter(3, time.Minute)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		l.failed("10.0.0.1")
+	}
+	if l.banned("10.0.0.1") {
+		t.Fatal("Banned before reaching the limit")
+	}
+	l.failed("10.0.0.1")
+	if !l.banned("10.0.0.1") {
+		t.Fatal("Not banned after reaching the limit")
+	}
+	if l.banned("10.0.0.2") {
+		t.Error("Another address was banned")
+	}
+	l.succeeded("10.0.0.1")
+	if !l.banned("10.0.0.1") {
+		t.Error("A success during the ban lifted it")
+	}
+
+	now = now.Add(time.Minute)
+	if l.banned("10.0.0.1") {
+		t.Error("Still banned after AuthBanDuration")
+	}
+
+	// Failures spread over more than one window never add up to a ban
+	for i := 0; i < 5; i++ {
+		l.failed("10.0.0.3")
+		l.failed("10.0.0.3")
+		now = now.Add(time.Minute)
+	}
+	if l.banned("10.0.0.3") {
+		t.Error("Banned for failures outside the window")
+	}
+}
+
+// dialFrom connects to addr from the local IP localIP with password auth.
+func dialFrom(localIP, addr, password string) (*ssh.Client, error) {
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(localIP)}, Timeout: 5 * time.Second}
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            "testuser",
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+func TestServer_MaxAuthAttemptsPerIP(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{MaxAuthAttemptsPerIP: 3, AuthBanDuration: time.Minute})
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	for i := 0; i < 3; i++ {
+		if _, err := dialFrom("127.0.0.1", addr, "wrong"); err == nil {
+			t.Fatal("Login with a wrong password succeeded")
+		}
+	}
+	if c, err := dialFrom("127.0.0.1", addr, "testpass"); err == nil {
+		c.Close()
+		t.Error("Banned address could still log in")
+	}
+
+	c, err := dialFrom("127.0.0.2", addr, "testpass")
+	if err != nil {
+		t.Fatalf("Login from another address failed: %v", err)
+	}
+	c.Close()
+}
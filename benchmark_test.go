@@ -1,8 +1,12 @@
 package sftpfs
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/absfs/sftpfs/internal/mocks"
 )
@@ -143,3 +147,105 @@ func BenchmarkChmod(b *testing.B) {
 		fs.Chmod("/test.txt", 0755)
 	}
 }
+
+// latencyClient adds a fixed delay to every read and write, standing in
+// for the round trip of a remote server.
+type latencyClient struct {
+	*mockSFTPClient
+	delay time.Duration
+}
+
+func (c *latencyClient) OpenFile(path string, f int) (sftpFileInterface, error) {
+	file, err := c.mockSFTPClient.OpenFile(path, f)
+	if err != nil {
+		return nil, err
+	}
+	return &latencyFile{sftpFileInterface: file, delay: c.delay}, nil
+}
+
+type latencyFile struct {
+	sftpFileInterface
+	delay time.Duration
+}
+
+func (f *latencyFile) Write(b []byte) (int, error) {
+	time.Sleep(f.delay)
+	return f.sftpFileInterface.Write(b)
+}
+
+func (f *latencyFile) Read(b []byte) (int, error) {
+	time.Sleep(f.delay)
+	return f.sftpFileInterface.Read(b)
+}
+
+// BenchmarkWriteFileFromChunkSize shows how TransferChunkSize affects
+// throughput when each write costs a round trip.
+func BenchmarkWriteFileFromChunkSize(b *testing.B) {
+	data := make([]byte, 1024*1024)
+	for _, size := range []int{8 * 1024, 32 * 1024, 128 * 1024, 512 * 1024} {
+		b.Run(fmt.Sprintf("%dKB", size/1024), func(b *testing.B) {
+			client := &latencyClient{mockSFTPClient: newMockSFTPClient(), delay: 100 * time.Microsecond}
+			fs := &FileSystem{client: client, config: Config{TransferChunkSize: size}}
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				fs.WriteFileFrom("/bench.bin", bytes.NewReader(data), 0644)
+			}
+		})
+	}
+}
+
+// BenchmarkOpenSequentialCopy compares io.Copy from files opened with
+// OpenSequential, whose read-ahead makes fewer round trips, and OpenRandom.
+func BenchmarkOpenSequentialCopy(b *testing.B) {
+	client := &latencyClient{mockSFTPClient: newMockSFTPClient(), delay: 100 * time.Microsecond}
+	client.files["/bench.bin"] = &mocks.MockSFTPFile{Data: make([]byte, 1024*1024)}
+	fs := &FileSystem{client: client}
+	for _, tc := range []struct {
+		name string
+		open func(string) (*File, error)
+	}{{"Sequential", fs.OpenSequential}, {"Random", fs.OpenRandom}} {
+		b.Run(tc.name, func(b *testing.B) {
+			b.SetBytes(1024 * 1024)
+			for i := 0; i < b.N; i++ {
+				f, err := tc.open("/bench.bin")
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(io.Discard, f)
+				f.Close()
+			}
+		})
+	}
+}
+
+// BenchmarkOpenRandomReads reads 4KB at scattered offsets and reports how
+// many bytes each strategy fetched from the server per read. OpenRandom
+// fetches only what is asked for; OpenSequential refills its whole
+// read-ahead buffer after every seek.
+func BenchmarkOpenRandomReads(b *testing.B) {
+	client := &latencyClient{mockSFTPClient: newMockSFTPClient(), delay: 100 * time.Microsecond}
+	client.files["/bench.bin"] = &mocks.MockSFTPFile{Data: make([]byte, 4*1024*1024)}
+	for _, name := range []string{"Sequential", "Random"} {
+		b.Run(name, func(b *testing.B) {
+			fs := &FileSystem{client: client}
+			open := fs.OpenRandom
+			if name == "Sequential" {
+				open = fs.OpenSequential
+			}
+			f, err := open("/bench.bin")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer f.Close()
+			buf := make([]byte, 4*1024)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f.Seek(int64(i*7919*4096)%(4*1024*1024-4096), io.SeekStart)
+				f.Read(buf)
+			}
+			b.ReportMetric(float64(fs.Stats().BytesRead)/float64(b.N), "fetched-B/op")
+		})
+	}
+}
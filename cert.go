@@ -0,0 +1,56 @@
+package sftpfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// ErrCertificateKeyMismatch is returned by New when Config.Certificate
+	// does not certify the public half of Config.Key.
+	ErrCertificateKeyMismatch = errors.New("certificate does not match private key")
+
+	// ErrCertificateExpired is returned by New when Config.Certificate is
+	// outside its validity period.
+	ErrCertificateExpired = errors.New("certificate is expired or not yet valid")
+)
+
+// certSigner parses certData, an SSH user certificate in authorized_keys
+// format as written by ssh-keygen -s, and returns a signer that presents it
+// with signer's key. The certificate must certify that key and be valid now.
+func certSigner(certData []byte, signer ssh.Signer) (ssh.Signer, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certData)
+	if err != nil {
+		// Also accept the bare wire encoding
+		if pub, err = ssh.ParsePublicKey(certData); err != nil {
+			return nil, fmt.Errorf("sftpfs: parsing certificate: %w", err)
+		}
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("sftpfs: Certificate holds a %s public key, not a certificate", pub.Type())
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, errors.New("sftpfs: Certificate is a host certificate, not a user certificate")
+	}
+	if !bytes.Equal(cert.Key.Marshal(), signer.PublicKey().Marshal()) {
+		return nil, ErrCertificateKeyMismatch
+	}
+	now := uint64(time.Now().Unix())
+	if now < cert.ValidAfter || (cert.ValidBefore != ssh.CertTimeInfinity && now >= cert.ValidBefore) {
+		return nil, fmt.Errorf("%w (valid %s to %s)", ErrCertificateExpired, certTime(cert.ValidAfter), certTime(cert.ValidBefore))
+	}
+	return ssh.NewCertSigner(cert, signer)
+}
+
+// certTime formats a certificate validity bound.
+func certTime(t uint64) string {
+	if t == ssh.CertTimeInfinity {
+		return "forever"
+	}
+	return time.Unix(int64(t), 0).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,149 @@
+package sftpfs
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+	"golang.org/x/crypto/ssh"
+)
+
+// testUserKey generates an ed25519 key, returning it PEM encoded for
+// Config.Key and as a signer.
+func testUserKey(t *testing.T) ([]byte, ssh.Signer) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(block), signer
+}
+
+// testUserCert signs a certificate for pub and the principal "testuser"
+// with ca, valid from after to before, and returns it in authorized_keys
+// format.
+func testUserCert(t *testing.T, ca ssh.Signer, pub ssh.PublicKey, after, before time.Time) []byte {
+	t.Helper()
+	return testUserCertFor(t, ca, pub, "testuser", after, before)
+}
+
+func testUserCertFor(t *testing.T, ca ssh.Signer, pub ssh.PublicKey, principal string, after, before time.Time) []byte {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key:             pub,
+		CertType:        ssh.UserCert,
+		KeyId:           principal + "-cert",
+		ValidPrincipals: []string{principal},
+		ValidAfter:      uint64(after.Unix()),
+		ValidBefore:     uint64(before.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return ssh.MarshalAuthorizedKey(cert)
+}
+
+func TestCertificateAuth(t *testing.T) {
+	ca := testHostKey(t)
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{TrustedCA: []ssh.PublicKey{ca.PublicKey()}})
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	key, signer := testUserKey(t)
+	now := time.Now()
+	cert := testUserCert(t, ca, signer.PublicKey(), now.Add(-time.Hour), now.Add(time.Hour))
+
+	fs, err := New(&Config{Host: addr, User: "testuser", Key: key, Certificate: cert})
+	if err != nil {
+		t.Fatalf("Certificate login failed: %v", err)
+	}
+	if err := fs.Ping(); err != nil {
+		t.Errorf("Ping failed: %v", err)
+	}
+	fs.Close()
+
+	// The CA-only server refuses the bare key
+	if _, err := New(&Config{Host: addr, User: "testuser", Key: key}); err == nil {
+		t.Error("Expected the bare key to be rejected")
+	}
+
+	_, other := testUserKey(t)
+	mismatched := testUserCert(t, ca, other.PublicKey(), now.Add(-time.Hour), now.Add(time.Hour))
+	if _, err := New(&Config{Host: addr, User: "testuser", Key: key, Certificate: mismatched}); !errors.Is(err, ErrCertificateKeyMismatch) {
+		t.Errorf("Expected ErrCertificateKeyMismatch, got %v", err)
+	}
+
+	expired := testUserCert(t, ca, signer.PublicKey(), now.Add(-2*time.Hour), now.Add(-time.Hour))
+	if _, err := New(&Config{Host: addr, User: "testuser", Key: key, Certificate: expired}); !errors.Is(err, ErrCertificateExpired) {
+		t.Errorf("Expected ErrCertificateExpired, got %v", err)
+	}
+
+	if _, err := New(&Config{Host: addr, User: "testuser", Key: key, Certificate: ssh.MarshalAuthorizedKey(signer.PublicKey())}); err == nil {
+		t.Error("Expected a plain public key to be rejected as a certificate")
+	}
+	if _, err := New(&Config{Host: addr, User: "testuser", Password: "testpass", Certificate: cert}); err == nil {
+		t.Error("Expected Certificate without Key to be rejected")
+	}
+}
+
+func TestServer_TrustedCA(t *testing.T) {
+	ca, untrusted := testHostKey(t), testHostKey(t)
+	key, signer := testUserKey(t)
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{
+		TrustedCA:         []ssh.PublicKey{ca.PublicKey()},
+		PasswordCallback:  SimplePasswordAuth("testuser", "testpass"),
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) { return nil, ErrAuthFailed },
+	})
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	now := time.Now()
+	for _, tc := range []struct {
+		name string
+		cert []byte
+		ok   bool
+	}{
+		{"trusted", testUserCertFor(t, ca, signer.PublicKey(), "testuser", now.Add(-time.Hour), now.Add(time.Hour)), true},
+		{"untrusted CA", testUserCertFor(t, untrusted, signer.PublicKey(), "testuser", now.Add(-time.Hour), now.Add(time.Hour)), false},
+		{"other principal", testUserCertFor(t, ca, signer.PublicKey(), "admin", now.Add(-time.Hour), now.Add(time.Hour)), false},
+	} {
+		fs, err := New(&Config{Host: addr, User: "testuser", Key: key, Certificate: tc.cert})
+		if tc.ok {
+			if err != nil {
+				t.Errorf("%s: login failed: %v", tc.name, err)
+				continue
+			}
+			fs.Close()
+		} else if err == nil {
+			fs.Close()
+			t.Errorf("%s: expected login to be rejected", tc.name)
+		}
+	}
+
+	// Other auth methods still work alongside the CA
+	fs, err := Dial(addr, "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Password login failed: %v", err)
+	}
+	fs.Close()
+}
@@ -0,0 +1,95 @@
+package sftpfs
+
+import (
+	"os"
+	"time"
+)
+
+// redacted replaces secrets in a ConfigSummary.
+const redacted = "[REDACTED]"
+
+// knownExtensions are the SFTP extensions ConfigSummary looks for.
+// github.com/pkg/sftp can only be asked about an extension by name.
+var knownExtensions = []string{
+	extPosixRename,
+	extStatVFS,
+	"fstatvfs@openssh.com",
+	extHardlink,
+	extFsync,
+	"lsetstat@openssh.com",
+	"limits@openssh.com",
+	"expand-path@openssh.com",
+	"copy-data",
+	"home-directory",
+	"users-groups-by-id@openssh.com",
+}
+
+// ConfigSummary is the effective configuration of a FileSystem, with
+// secrets redacted, as returned by FileSystem.ConfigSummary.
+type ConfigSummary struct {
+	Host string
+	User string
+
+	// Password and Key are "[REDACTED]" if set and empty otherwise.
+	Password string
+	Key      string
+
+	Certificate         bool // a user certificate is offered with Key
+	HostKeyVerification bool // the host key is checked against HostKeyFingerprint
+	HostKeyFingerprint  string
+
+	Timeout           time.Duration
+	Retries           int
+	RetryDelay        time.Duration
+	TransferChunkSize int
+	WriteBufferSize   int
+	FsyncInterval     time.Duration
+	Umask             os.FileMode
+	ValidateNames     bool
+	VerifyUploadSize  bool
+	LegacyAlgorithms  bool
+
+	// Extensions lists the known extensions the server offered when the
+	// session started. It is nil if the client cannot tell.
+	Extensions []string
+}
+
+// ConfigSummary returns the configuration fs is using, with defaults
+// filled in and passwords and keys redacted, for diagnosing how a
+// connection behaves. For a FileSystem from NewFromSSHClient, the
+// connection settings are those of the borrowed client and show as zero.
+func (fs *FileSystem) ConfigSummary() ConfigSummary {
+	c := fs.config
+	s := ConfigSummary{
+		Host:                c.Host,
+		User:                c.User,
+		Certificate:         len(c.Certificate) > 0,
+		HostKeyVerification: c.HostKeyFingerprint != "",
+		HostKeyFingerprint:  c.HostKeyFingerprint,
+		Timeout:             c.Timeout,
+		Retries:             c.Retries,
+		RetryDelay:          c.RetryDelay,
+		TransferChunkSize:   fs.transferChunkSize(),
+		WriteBufferSize:     c.WriteBufferSize,
+		FsyncInterval:       c.FsyncInterval,
+		Umask:               c.Umask,
+		ValidateNames:       c.ValidateNames,
+		VerifyUploadSize:    c.VerifyUploadSize,
+		LegacyAlgorithms:    c.LegacyAlgorithms,
+	}
+	if c.Password != "" {
+		s.Password = redacted
+	}
+	if len(c.Key) > 0 {
+		s.Key = redacted
+	}
+	if e, ok := fs.client.(sftpExtensioner); ok {
+		s.Extensions = []string{}
+		for _, ext := range knownExtensions {
+			if _, ok := e.HasExtension(ext); ok {
+				s.Extensions = append(s.Extensions, ext)
+			}
+		}
+	}
+	return s
+}
@@ -0,0 +1,98 @@
+package sftpfs
+
+import (
+	"os"
+	"path"
+	"syscall"
+)
+
+// abs resolves a relative name against the directory set with Chdir. Names
+// are returned unchanged if they are absolute or no directory is set, so
+// the server resolves them against its own working directory as before.
+func (fs *FileSystem) abs(name string) string {
+	if fs.cwd == "" || path.IsAbs(name) {
+		return name
+	}
+	return path.Join(fs.cwd, name)
+}
+
+// Chdir sets the directory that relative paths passed to fs are resolved
+// against. It must not be called concurrently with other methods of fs;
+// give each goroutine its own Clone instead.
+func (fs *FileSystem) Chdir(dir string) error {
+	dir = fs.abs(dir)
+	if err := fs.checkName("chdir", dir); err != nil {
+		return err
+	}
+	info, err := fs.client.Stat(dir)
+	if err != nil {
+		return &os.PathError{Op: "chdir", Path: dir, Err: err}
+	}
+	if !info.IsDir() {
+		return &os.PathError{Op: "chdir", Path: dir, Err: syscall.ENOTDIR}
+	}
+	if !path.IsAbs(dir) {
+		// Store an absolute path so abs stays idempotent
+		wd, err := fs.serverWd()
+		if err != nil {
+			return &os.PathError{Op: "chdir", Path: dir, Err: err}
+		}
+		dir = path.Join(wd, dir)
+	}
+	fs.cwd = path.Clean(dir)
+	return nil
+}
+
+// Getwd returns the directory relative paths are resolved against: the one
+// set with Chdir, or else the server's working directory for the session.
+func (fs *FileSystem) Getwd() (string, error) {
+	if fs.cwd != "" {
+		return fs.cwd, nil
+	}
+	return fs.serverWd()
+}
+
+// serverWd asks the server for the session's working directory.
+func (fs *FileSystem) serverWd() (string, error) {
+	g, ok := fs.client.(sftpGetwder)
+	if !ok {
+		return "", ErrUnsupported
+	}
+	return g.Getwd()
+}
+
+// UserHomeDir returns the home directory of the connected user: the
+// server's working directory for the session, which Chdir does not change.
+// The first successful call asks the server and later calls, on fs or its
+// clones, return the cached result, so ~-relative paths can be resolved
+// without a round trip each.
+func (fs *FileSystem) UserHomeDir() (string, error) {
+	if home := fs.home.Load(); home != nil {
+		return *home, nil
+	}
+	home, err := fs.serverWd()
+	if err != nil {
+		return "", err
+	}
+	fs.home.Store(&home)
+	return home, nil
+}
+
+// Clone returns a FileSystem that shares fs's connection but has its own
+// working directory, starting at fs's, so concurrent workers can each Chdir
+// independently. Stats, InFlight and CancelAll are per clone; AppendLocked
+// serializes appends across all clones, and DownloadTo on a clone
+// reconnects as it would on fs. Closing any clone closes the shared
+// connection for all of them, and is reported as Closed, not Disconnected.
+func (fs *FileSystem) Clone() *FileSystem {
+	c := &FileSystem{
+		client:    fs.client,
+		sshClient: fs.sshClient,
+		config:    fs.config,
+		cwd:       fs.cwd,
+		redial:    fs.redial,
+	}
+	c.home.Store(fs.home.Load())
+	c.share.Store(fs.shared())
+	return c
+}
@@ -0,0 +1,162 @@
+package sftpfs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/memfs"
+	"github.com/absfs/sftpfs/internal/mocks"
+)
+
+func TestCloneIndependentCwd(t *testing.T) {
+	client := newMockSFTPClient()
+	client.dirs["/a"] = nil
+	client.dirs["/b"] = nil
+	client.files["/a/data.txt"] = &mocks.MockSFTPFile{Data: []byte("from a")}
+	client.files["/b/data.txt"] = &mocks.MockSFTPFile{Data: []byte("from b")}
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+
+	c1, c2 := fs.Clone(), fs.Clone()
+	if err := c1.Chdir("/a"); err != nil {
+		t.Fatalf("Chdir(/a) failed: %v", err)
+	}
+	if err := c2.Chdir("/b"); err != nil {
+		t.Fatalf("Chdir(/b) failed: %v", err)
+	}
+	for _, tc := range []struct {
+		fs   *FileSystem
+		dir  string
+		data string
+	}{{c1, "/a", "from a"}, {c2, "/b", "from b"}} {
+		wd, err := tc.fs.Getwd()
+		if err != nil || wd != tc.dir {
+			t.Errorf("Getwd = %q, %v; want %q", wd, err, tc.dir)
+		}
+		got, err := tc.fs.ReadFile("data.txt")
+		if err != nil || string(got) != tc.data {
+			t.Errorf("ReadFile(data.txt) in %s = %q, %v; want %q", tc.dir, got, err, tc.data)
+		}
+		if got := tc.fs.abs("sub/../x"); got != tc.dir+"/x" {
+			t.Errorf("abs(sub/../x) in %s = %q", tc.dir, got)
+		}
+	}
+	if fs.cwd != "" {
+		t.Errorf("Chdir on a clone changed the original's directory to %q", fs.cwd)
+	}
+
+	// A clone starts in its parent's directory
+	if got := c1.Clone().abs("data.txt"); got != "/a/data.txt" {
+		t.Errorf("Clone of /a resolved data.txt to %q", got)
+	}
+}
+
+func TestCloneSharesConnection(t *testing.T) {
+	fs := newWithClients(newMockSFTPClient(), &mocks.MockSSHClient{})
+	fs.redial = func() (sftpClientInterface, sshClientInterface, error) {
+		return nil, nil, errors.New("unused")
+	}
+
+	c := fs.Clone()
+	if c.redial == nil {
+		t.Error("Clone did not keep redial, so DownloadTo would never resume")
+	}
+	if c.shared() != fs.shared() {
+		t.Error("Clone has its own AppendLocked locks and closed state")
+	}
+	c.Close()
+	if !fs.shared().closed.Load() {
+		t.Error("Closing a clone did not mark the shared connection closed")
+	}
+}
+
+func TestChdirErrors(t *testing.T) {
+	client := newMockSFTPClient()
+	client.files["/file.txt"] = &mocks.MockSFTPFile{}
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+
+	if err := fs.Chdir("/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Chdir to a missing directory: got %v", err)
+	}
+	if err := fs.Chdir("/file.txt"); !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("Chdir to a file: expected ENOTDIR, got %v", err)
+	}
+	if fs.cwd != "" {
+		t.Errorf("Failed Chdir changed the directory to %q", fs.cwd)
+	}
+	if _, err := fs.Getwd(); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Getwd without Chdir or server support: got %v", err)
+	}
+}
+
+func TestChdirOverSFTP(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.MkdirAll("/home/work", 0755)
+	_, listener := testServerListen(t, mfs, &ServerConfig{HomeDir: func(string) string { return "/home" }})
+	defer listener.Close()
+
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+
+	if err := fs.Chdir("work"); err != nil {
+		t.Fatalf("Chdir(work) failed: %v", err)
+	}
+	if wd, err := fs.Getwd(); err != nil || wd != "/home/work" {
+		t.Errorf("Getwd = %q, %v; want /home/work", wd, err)
+	}
+	if err := fs.WriteFile("out.txt", []byte("x"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if _, err := mfs.Stat("/home/work/out.txt"); err != nil {
+		t.Errorf("Relative write did not land in the working directory: %v", err)
+	}
+}
+
+func TestUserHomeDir(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.MkdirAll("/home/testuser/work", 0755)
+	_, listener := testServerListen(t, mfs, &ServerConfig{HomeDir: func(user string) string { return "/home/" + user }})
+	defer listener.Close()
+
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+
+	if err := fs.Chdir("work"); err != nil {
+		t.Fatalf("Chdir(work) failed: %v", err)
+	}
+	home, err := fs.UserHomeDir()
+	if err != nil {
+		t.Fatalf("UserHomeDir failed: %v", err)
+	}
+	if home != "/home/testuser" {
+		t.Errorf("UserHomeDir = %q, want /home/testuser", home)
+	}
+	if got, err := fs.Clone().UserHomeDir(); err != nil || got != home {
+		t.Errorf("UserHomeDir on a clone = %q, %v; want %q", got, err, home)
+	}
+}
+
+func TestUserHomeDirCached(t *testing.T) {
+	fs := newWithClients(newMockSFTPClient(), &mocks.MockSSHClient{})
+	if _, err := fs.UserHomeDir(); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("UserHomeDir without server support: got %v", err)
+	}
+	home := "/home/cached"
+	fs.home.Store(&home)
+	if got, err := fs.UserHomeDir(); err != nil || got != home {
+		t.Errorf("UserHomeDir = %q, %v; want the cached %q", got, err, home)
+	}
+}
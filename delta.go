@@ -0,0 +1,82 @@
+package sftpfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// SyncFileBlocks updates the remote file to match the local file by
+// rewriting only the blocks that differ, for large files that change in
+// small regions. Each blockSize block of the local file is compared with
+// the same range of the remote file, read with ReadAt, and written with
+// WriteAt only if it differs; the remote file is then truncated to the
+// local size. If blockSize is 0 or less, Config.TransferChunkSize is used.
+// A missing remote file is created with the local file's permissions.
+//
+// It returns the number of bytes written to the server. Every remote block
+// is still read once, so this saves upload bandwidth, not download.
+func (fs *FileSystem) SyncFileBlocks(local, remote string, blockSize int) (int64, error) {
+	if blockSize <= 0 {
+		blockSize = fs.transferChunkSize()
+	}
+	src, err := os.Open(local)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := fs.OpenFile(remote, os.O_RDWR|os.O_CREATE, info.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	written, err := syncBlocks(f.(*File), src, info.Size(), blockSize)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return written, err
+}
+
+// syncBlocks writes the blocks of src that differ from dst and truncates
+// dst to size, returning the bytes written.
+func syncBlocks(dst *File, src io.Reader, size int64, blockSize int) (int64, error) {
+	var written int64
+	want := make([]byte, blockSize)
+	have := make([]byte, blockSize)
+	for off := int64(0); ; off += int64(blockSize) {
+		n, err := io.ReadFull(src, want)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return written, err
+		}
+		m, err := dst.ReadFullAt(have[:n], off)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return written, err
+		}
+		if m < n || !bytes.Equal(have[:n], want[:n]) {
+			w, err := dst.WriteAt(want[:n], off)
+			written += int64(w)
+			if err != nil {
+				return written, err
+			}
+		}
+		if n < blockSize {
+			break
+		}
+	}
+
+	info, err := dst.Stat()
+	if err != nil {
+		return written, err
+	}
+	if info.Size() > size {
+		return written, dst.Truncate(size)
+	}
+	return written, nil
+}
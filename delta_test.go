@@ -0,0 +1,75 @@
+package sftpfs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+)
+
+func TestSyncFileBlocks(t *testing.T) {
+	const block = 1024
+	original := make([]byte, 64*block)
+	for i := range original {
+		original[i] = byte(i % 253)
+	}
+	modified := bytes.Clone(original)
+	modified[3*block+10] ^= 0xff
+	modified[40*block] ^= 0xff
+	modified[40*block+block-1] ^= 0xff
+
+	local := filepath.Join(t.TempDir(), "big.bin")
+	if err := os.WriteFile(local, modified, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newMockSFTPClient()
+	client.files["/big.bin"] = &mocks.MockSFTPFile{Data: bytes.Clone(original)}
+	fs := &FileSystem{client: client}
+
+	n, err := fs.SyncFileBlocks(local, "/big.bin", block)
+	if err != nil {
+		t.Fatalf("SyncFileBlocks failed: %v", err)
+	}
+	if n != 2*block {
+		t.Errorf("Transferred %d bytes, want %d for two changed blocks", n, 2*block)
+	}
+	if w := fs.Stats().BytesWritten; w != 2*block {
+		t.Errorf("Wrote %d bytes to the server, want %d", w, 2*block)
+	}
+	if !bytes.Equal(client.files["/big.bin"].Data, modified) {
+		t.Error("Remote file does not match the local file")
+	}
+
+	// Nothing to do the second time
+	if n, err := fs.SyncFileBlocks(local, "/big.bin", block); err != nil || n != 0 {
+		t.Errorf("Second SyncFileBlocks = %d, %v; want 0, nil", n, err)
+	}
+}
+
+func TestSyncFileBlocksResize(t *testing.T) {
+	dir := t.TempDir()
+	client := newMockSFTPClient()
+	fs := &FileSystem{client: client}
+
+	local := filepath.Join(dir, "f.bin")
+	os.WriteFile(local, []byte("0123456789abcdef"), 0644)
+	if n, err := fs.SyncFileBlocks(local, "/f.bin", 4); err != nil || n != 16 {
+		t.Fatalf("SyncFileBlocks to a new file = %d, %v; want 16", n, err)
+	}
+
+	// Growing writes only the new tail, shrinking truncates
+	os.WriteFile(local, []byte("0123456789abcdefXY"), 0644)
+	if n, err := fs.SyncFileBlocks(local, "/f.bin", 4); err != nil || n != 2 {
+		t.Errorf("SyncFileBlocks after growing = %d, %v; want 2", n, err)
+	}
+	os.WriteFile(local, []byte("0123456"), 0644)
+	if n, err := fs.SyncFileBlocks(local, "/f.bin", 4); err != nil || n != 0 {
+		t.Errorf("SyncFileBlocks after shrinking = %d, %v; want 0", n, err)
+	}
+	if got := string(client.files["/f.bin"].Data); got != "0123456" {
+		t.Errorf("Remote content %q, want %q", got, "0123456")
+	}
+}
@@ -0,0 +1,41 @@
+package sftpfs
+
+import (
+	"context"
+	iofs "io/fs"
+	"os"
+)
+
+// ReadDirFunc lists the named directory and calls fn for each entry in
+// turn, stopping at the first error fn returns, which ReadDirFunc then
+// returns. It also stops, returning ctx's error, once ctx is cancelled.
+//
+// The listing is buffered: it is received in full, as by ReadDir, before
+// fn is first called, because pkg/sftp's client offers no way to read a
+// directory page by page. Entries received before a listing error are
+// still passed to fn, and the listing error is returned after them.
+func (fs *FileSystem) ReadDirFunc(ctx context.Context, name string, fn func(iofs.DirEntry) error) error {
+	name = fs.abs(name)
+	if err := fs.checkName("readdir", name); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var infos []os.FileInfo
+	listErr := fs.config.withRetry(func() error {
+		var err error
+		infos, err = fs.client.ReadDir(name)
+		return err
+	})
+	for _, info := range infos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&dirEntry{info: info}); err != nil {
+			return err
+		}
+	}
+	return listErr
+}
@@ -0,0 +1,92 @@
+package sftpfs
+
+import (
+	"context"
+	"errors"
+	iofs "io/fs"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+)
+
+// newDirClient returns a mock client with entries files in /big.
+func newDirClient(entries int) *mockSFTPClient {
+	mock := newMockSFTPClient()
+	infos := make([]os.FileInfo, entries)
+	for i := range infos {
+		infos[i] = &mocks.MockFileInfo{FileName: string(rune('a' + i))}
+	}
+	mock.dirs["/big"] = infos
+	return mock
+}
+
+func TestReadDirFunc(t *testing.T) {
+	fs := newWithClients(newDirClient(10), &mocks.MockSSHClient{})
+
+	var names []string
+	err := fs.ReadDirFunc(context.Background(), "/big", func(entry iofs.DirEntry) error {
+		names = append(names, entry.Name())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(names) != 10 {
+		t.Fatalf("Expected 10 entries, got %d: %v", len(names), names)
+	}
+	if !sort.StringsAreSorted(names) || names[0] != "a" || names[9] != "j" {
+		t.Errorf("Unexpected entries %v", names)
+	}
+}
+
+func TestReadDirFuncStop(t *testing.T) {
+	fs := newWithClients(newDirClient(10), &mocks.MockSSHClient{})
+
+	stop := errors.New("stop")
+	calls := 0
+	err := fs.ReadDirFunc(context.Background(), "/big", func(iofs.DirEntry) error {
+		if calls++; calls == 3 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("Expected the callback's error, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", calls)
+	}
+}
+
+func TestReadDirFuncCancel(t *testing.T) {
+	fs := newWithClients(newDirClient(10), &mocks.MockSSHClient{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	err := fs.ReadDirFunc(ctx, "/big", func(iofs.DirEntry) error {
+		calls++
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected no calls after cancelling, got %d", calls)
+	}
+}
+
+func TestReadDirFuncMissing(t *testing.T) {
+	fs := newWithClients(newMockSFTPClient(), &mocks.MockSSHClient{})
+
+	err := fs.ReadDirFunc(context.Background(), "/missing", func(iofs.DirEntry) error {
+		t.Error("Expected no entries for a missing directory")
+		return nil
+	})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, got %v", err)
+	}
+}
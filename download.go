@@ -0,0 +1,93 @@
+package sftpfs
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// DownloadTo streams the named file to w and returns the number of bytes
+// written. If the transfer fails with an error accepted by
+// Config.RetryableError, fs reconnects, up to Config.Retries times, and
+// resumes from the first byte not yet written to w, so w receives the file
+// exactly once. Errors returned by w are not retried.
+//
+// A reconnect opens a connection used only by this call and closed when it
+// returns; the connection of fs, which other goroutines may be using, is
+// left as it is. FileSystems created with NewFromSSHClient, which do not
+// own their connection, never reconnect.
+func (fs *FileSystem) DownloadTo(name string, w io.Writer) (int64, error) {
+	name = fs.abs(name)
+	if err := fs.checkName("open", name); err != nil {
+		return 0, err
+	}
+	cw := &countingWriter{w: w}
+	conn := &downloadConn{client: fs.client}
+	defer func() { conn.close() }()
+	for attempt := 0; ; attempt++ {
+		err := fs.downloadFrom(conn.client, name, cw)
+		if err == nil || cw.err != nil {
+			return cw.n, err
+		}
+		if attempt >= fs.config.Retries || !fs.config.retryable(err) || fs.redial == nil {
+			return cw.n, err
+		}
+		time.Sleep(fs.config.RetryDelay)
+		client, sshClient, rerr := fs.redial()
+		if rerr != nil {
+			if !fs.config.retryable(rerr) {
+				return cw.n, rerr
+			}
+			continue
+		}
+		conn.close()
+		conn = &downloadConn{client: client, sshClient: sshClient}
+	}
+}
+
+// downloadFrom copies name to cw over client, starting at the offset cw has
+// reached.
+func (fs *FileSystem) downloadFrom(client sftpClientInterface, name string, cw *countingWriter) error {
+	f, err := fs.openFileOn(client, name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if cw.n > 0 {
+		if _, err := f.Seek(cw.n, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	_, err = f.(*File).WriteTo(cw)
+	return err
+}
+
+// downloadConn is the connection a DownloadTo call reads over. sshClient is
+// set only for connections DownloadTo opened itself, which it closes.
+type downloadConn struct {
+	client    sftpClientInterface
+	sshClient sshClientInterface
+}
+
+func (c *downloadConn) close() {
+	if c.sshClient != nil {
+		c.client.Close()
+		c.sshClient.Close()
+	}
+}
+
+// countingWriter counts the bytes written to w and records w's error.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
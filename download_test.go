@@ -0,0 +1,114 @@
+package sftpfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+)
+
+// droppingFile fails reads with io.ErrUnexpectedEOF once failAt bytes have
+// been read, as when the connection drops mid-transfer.
+type droppingFile struct {
+	*mocks.MockSFTPFile
+	failAt, read int64
+}
+
+func (f *droppingFile) Read(p []byte) (int, error) {
+	if f.read >= f.failAt {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if rest := f.failAt - f.read; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := f.MockSFTPFile.Read(p)
+	f.read += int64(n)
+	return n, err
+}
+
+// droppingClient opens every file as a droppingFile.
+type droppingClient struct {
+	*mockSFTPClient
+	failAt int64
+}
+
+func (c *droppingClient) OpenFile(path string, flag int) (sftpFileInterface, error) {
+	f, err := c.mockSFTPClient.OpenFile(path, flag)
+	if err != nil {
+		return nil, err
+	}
+	return &droppingFile{MockSFTPFile: f.(*mocks.MockSFTPFile), failAt: c.failAt}, nil
+}
+
+// failingWriter accepts limit bytes, then fails.
+type failingWriter struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > w.limit {
+		return 0, errors.New("disk full")
+	}
+	return w.buf.Write(p)
+}
+
+func TestDownloadTo(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	var fresh []*mockSFTPClient
+	newFS := func(retries int) (*FileSystem, *int) {
+		broken := &droppingClient{mockSFTPClient: newMockSFTPClient(), failAt: 40000}
+		broken.files["/big.bin"] = &mocks.MockSFTPFile{Data: data}
+		fs := newWithClients(broken, &mocks.MockSSHClient{})
+		fs.config.Retries = retries
+		redials := 0
+		fs.redial = func() (sftpClientInterface, sshClientInterface, error) {
+			redials++
+			client := newMockSFTPClient()
+			client.files["/big.bin"] = &mocks.MockSFTPFile{Data: data}
+			fresh = append(fresh, client)
+			return client, &mocks.MockSSHClient{}, nil
+		}
+		return fs, &redials
+	}
+
+	fs, redials := newFS(2)
+	broken := fs.client
+	var states []ConnState
+	fs.config.OnStateChange = func(s ConnState) { states = append(states, s) }
+	var buf bytes.Buffer
+	n, err := fs.DownloadTo("/big.bin", &buf)
+	if err != nil {
+		t.Fatalf("DownloadTo failed: %v", err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("DownloadTo wrote %d bytes, content match %v", n, bytes.Equal(buf.Bytes(), data))
+	}
+	if *redials != 1 {
+		t.Errorf("Reconnected %d times, want 1", *redials)
+	}
+	// The download resumed on a connection of its own, closed on return
+	if fs.client != broken || len(states) != 0 {
+		t.Errorf("Connection of fs was replaced, states %v", states)
+	}
+	if len(fresh) != 1 || !fresh[0].closed {
+		t.Error("DownloadTo did not close its own connection")
+	}
+
+	// Without retries the partial count is returned with the error
+	fs, redials = newFS(0)
+	buf.Reset()
+	n, err = fs.DownloadTo("/big.bin", &buf)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || n != 40000 || *redials != 0 {
+		t.Errorf("DownloadTo without retries = %d, %v after %d redials", n, err, *redials)
+	}
+
+	// Errors from w are not retried
+	fs, redials = newFS(2)
+	n, err = fs.DownloadTo("/big.bin", &failingWriter{limit: 1000})
+	if err == nil || *redials != 0 {
+		t.Errorf("DownloadTo into a failing writer = %d, %v after %d redials", n, err, *redials)
+	}
+}
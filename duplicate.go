@@ -0,0 +1,96 @@
+package sftpfs
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// duplicateWindow is the number of chunks Duplicate keeps in flight.
+const duplicateWindow = 8
+
+// Duplicate copies the remote file src to dst, creating or truncating dst
+// and giving it the exact mode of src, for servers without a server-side
+// copy extension. The data passes through the client, but up to
+// duplicateWindow chunks of Config.TransferChunkSize bytes are read and
+// written concurrently at their offsets, so the copy is limited by the
+// link's bandwidth rather than its round trip time. Only that window is
+// buffered locally. It returns the number of bytes copied.
+func (fs *FileSystem) Duplicate(src, dst string) (int64, error) {
+	src, dst = fs.abs(src), fs.abs(dst)
+	in, err := fs.OpenFile(src, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return 0, err
+	}
+	out, err := fs.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	n, err := copyWindowed(out.(*File), in.(*File), info.Size(), fs.transferChunkSize(), duplicateWindow)
+	if err != nil {
+		out.Close()
+		return n, err
+	}
+	if err := out.Close(); err != nil {
+		return n, err
+	}
+	if err := fs.client.Chmod(dst, info.Mode().Perm()); err != nil {
+		return n, err
+	}
+	return n, fs.verifyUpload(dst, n)
+}
+
+// copyWindowed copies the first size bytes of src to the same offsets in
+// dst with up to window chunks in flight. If src shrank, only the bytes
+// still there are copied. It returns the bytes written and the first error.
+func copyWindowed(dst io.WriterAt, src *File, size int64, chunkSize, window int) (int64, error) {
+	bufs := make(chan []byte, window)
+	for i := 0; i < window; i++ {
+		bufs <- make([]byte, chunkSize)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		written  int64
+		firstErr error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+	for off := int64(0); off < size && !failed(); off += int64(chunkSize) {
+		buf := <-bufs
+		if rest := size - off; rest < int64(chunkSize) {
+			buf = buf[:rest]
+		}
+		wg.Add(1)
+		go func(buf []byte, off int64) {
+			defer wg.Done()
+			defer func() { bufs <- buf[:cap(buf)] }()
+			// The server may return less than asked for; only the end
+			// of src ends a chunk early
+			n, err := src.ReadFullAt(buf, off)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = nil
+			}
+			if err == nil && n > 0 {
+				n, err = dst.WriteAt(buf[:n], off)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			written += int64(n)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(buf, off)
+	}
+	wg.Wait()
+	return written, firstErr
+}
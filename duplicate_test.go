@@ -0,0 +1,79 @@
+package sftpfs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/absfs/memfs"
+	"github.com/absfs/sftpfs/internal/mocks"
+)
+
+func TestDuplicate(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+	fs.config.TransferChunkSize = MinTransferChunkSize
+
+	// Several windows' worth of chunks and a partial last chunk
+	data := make([]byte, 20*duplicateWindow*MinTransferChunkSize+1234)
+	for i := range data {
+		data[i] = byte(i * 7 / 3)
+	}
+	if err := fs.WriteFile("/src.bin", data, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := fs.Chmod("/src.bin", 0640); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	if err := fs.WriteFile("/dst.bin", []byte("longer stale content that must be truncated"), 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	for _, dst := range []string{"/copy.bin", "/dst.bin"} {
+		n, err := fs.Duplicate("/src.bin", dst)
+		if err != nil {
+			t.Fatalf("Duplicate to %s failed: %v", dst, err)
+		}
+		if n != int64(len(data)) {
+			t.Errorf("Duplicate to %s copied %d bytes, want %d", dst, n, len(data))
+		}
+		got, err := fs.ReadFile(dst)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s content differs from the source (%d bytes, %v)", dst, len(got), err)
+		}
+		info, err := mfs.Stat(dst)
+		if err != nil || info.Mode().Perm() != 0640 {
+			t.Errorf("%s mode = %v, %v; want 0640", dst, info.Mode(), err)
+		}
+	}
+
+	empty := "/empty.bin"
+	fs.WriteFile(empty, nil, 0644)
+	if n, err := fs.Duplicate(empty, "/empty-copy.bin"); err != nil || n != 0 {
+		t.Errorf("Duplicate of an empty file = %d, %v", n, err)
+	}
+}
+
+func TestCopyWindowedShortReads(t *testing.T) {
+	data := []byte("a chunk read in several short pieces")
+	src := &File{file: &partialReadFile{MockSFTPFile: &mocks.MockSFTPFile{Data: data}, chunk: 3}, name: "/src"}
+	out := &mocks.MockSFTPFile{}
+	dst := &File{file: out, name: "/dst"}
+
+	n, err := copyWindowed(dst, src, int64(len(data)), 8, 1)
+	if err != nil {
+		t.Fatalf("copyWindowed failed: %v", err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(out.Data, data) {
+		t.Errorf("copyWindowed copied %d bytes %q, want %q", n, out.Data, data)
+	}
+}
@@ -0,0 +1,49 @@
+//go:build go1.23
+
+package sftpfs
+
+import (
+	"errors"
+	"iter"
+	"os"
+)
+
+// errStopEntries ends the walk behind an Entries iterator when the loop
+// body breaks.
+var errStopEntries = errors.New("entries iteration stopped")
+
+// Entries returns an iterator over root and every entry beneath it, in the
+// order of walk, for use as
+//
+//	for name, info := range fs.Entries("/data") { ... }
+//
+// Directories are listed lazily as the loop proceeds, and breaking out of
+// the loop stops the walk. An error ends the iteration silently; use
+// EntriesErr to learn whether the walk completed.
+func (fs *FileSystem) Entries(root string) iter.Seq2[string, os.FileInfo] {
+	seq, _ := fs.EntriesErr(root)
+	return seq
+}
+
+// EntriesErr is like Entries but also returns a function reporting the
+// error, if any, that ended the most recent iteration early. A break in the
+// loop body is not an error.
+func (fs *FileSystem) EntriesErr(root string) (iter.Seq2[string, os.FileInfo], func() error) {
+	var walkErr error
+	seq := func(yield func(string, os.FileInfo) bool) {
+		walkErr = nil
+		err := fs.walk(root, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !yield(name, info) {
+				return errStopEntries
+			}
+			return nil
+		})
+		if err != errStopEntries {
+			walkErr = err
+		}
+	}
+	return seq, func() error { return walkErr }
+}
@@ -0,0 +1,68 @@
+//go:build go1.23
+
+package sftpfs
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+)
+
+// readDirCounter counts ReadDir calls.
+type readDirCounter struct {
+	*enhancedMockSFTPClient
+	calls int
+}
+
+func (c *readDirCounter) ReadDir(path string) ([]os.FileInfo, error) {
+	c.calls++
+	return c.enhancedMockSFTPClient.ReadDir(path)
+}
+
+func TestEntries(t *testing.T) {
+	tree, client := newTreeFS(t)
+
+	var names []string
+	seq, errFn := tree.EntriesErr("/root")
+	for name, info := range seq {
+		if info == nil {
+			t.Errorf("Nil info for %s", name)
+		}
+		names = append(names, name)
+	}
+	if err := errFn(); err != nil {
+		t.Errorf("EntriesErr reported %v", err)
+	}
+	want := map[string]bool{"/root": true, "/root/a.txt": true, "/root/sub": true, "/root/sub/b.txt": true, "/root/sub/deeper": true, "/root/sub/deeper/c.txt": true}
+	if len(names) != len(want) {
+		t.Errorf("Entries yielded %v", names)
+	}
+	for _, name := range names {
+		if !want[name] {
+			t.Errorf("Unexpected entry %s", name)
+		}
+	}
+
+	// Breaking after the first entry stops before any directory is listed
+	counter := &readDirCounter{enhancedMockSFTPClient: client}
+	fs := newWithClients(counter, &mocks.MockSSHClient{})
+	for name := range fs.Entries("/root") {
+		if name != "/root" {
+			t.Errorf("First entry %s, want /root", name)
+		}
+		break
+	}
+	if counter.calls != 0 {
+		t.Errorf("Walk listed %d directories after the loop broke", counter.calls)
+	}
+
+	seq, errFn = fs.EntriesErr("/missing")
+	for name := range seq {
+		t.Errorf("Entries of a missing root yielded %s", name)
+	}
+	if err := errFn(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("EntriesErr of a missing root = %v, want os.ErrNotExist", err)
+	}
+}
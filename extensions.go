@@ -0,0 +1,143 @@
+package sftpfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/pkg/sftp"
+)
+
+// ErrUnsupported is wrapped by the errors of operations that rely on an SFTP
+// extension the server does not offer: PosixRename, StatVFS, Link and
+// File.Fsync. The server's own status, if it rejected the request, is
+// wrapped as well. It also matches errors.ErrUnsupported.
+var ErrUnsupported = fmt.Errorf("operation not supported by the server: %w", errors.ErrUnsupported)
+
+// Names of the OpenSSH extensions used by the client.
+const (
+	extPosixRename = "posix-rename@openssh.com"
+	extStatVFS     = "statvfs@openssh.com"
+	extHardlink    = "hardlink@openssh.com"
+	extFsync       = "fsync@openssh.com"
+)
+
+// sftpExtensioner is implemented by clients that know which extensions the
+// server advertised when the session started.
+type sftpExtensioner interface {
+	HasExtension(name string) (string, bool)
+}
+
+// sftpPosixRenamer is implemented by clients that can rename using the
+// posix-rename@openssh.com extension.
+type sftpPosixRenamer interface {
+	PosixRename(oldname, newname string) error
+}
+
+// sftpStatVFSer is implemented by clients that can query filesystem
+// capacity using the statvfs@openssh.com extension.
+type sftpStatVFSer interface {
+	StatVFS(path string) (*sftp.StatVFS, error)
+}
+
+// sftpSyncer is implemented by file handles that can ask the server to
+// fsync them using the fsync@openssh.com extension.
+type sftpSyncer interface {
+	Sync() error
+}
+
+// checkExtension returns an error wrapping ErrUnsupported if client is known
+// not to offer ext. Clients that cannot tell are given the benefit of the
+// doubt; the server rejects the request if it lacks the extension.
+func checkExtension(client sftpClientInterface, ext string) error {
+	if e, ok := client.(sftpExtensioner); ok {
+		if _, ok := e.HasExtension(ext); !ok {
+			return fmt.Errorf("%w: %s", ErrUnsupported, ext)
+		}
+	}
+	return nil
+}
+
+// unsupportedErr wraps err with ErrUnsupported if it is the server's
+// SSH_FX_OP_UNSUPPORTED status, leaving other errors unchanged.
+func unsupportedErr(err error) error {
+	var status *sftp.StatusError
+	if errors.As(err, &status) && status.FxCode() == sftp.ErrSSHFxOpUnsupported {
+		return fmt.Errorf("%w: %w", ErrUnsupported, err)
+	}
+	return err
+}
+
+// PosixRename renames oldname to newname using the posix-rename@openssh.com
+// extension, which replaces newname if it exists, as rename(2) does, where
+// a plain SFTP rename fails.
+func (fs *FileSystem) PosixRename(oldname, newname string) error {
+	oldname, newname = fs.abs(oldname), fs.abs(newname)
+	for _, name := range []string{oldname, newname} {
+		if err := fs.checkName("rename", name); err != nil {
+			return err
+		}
+	}
+	err := checkExtension(fs.client, extPosixRename)
+	if err == nil {
+		if r, ok := fs.client.(sftpPosixRenamer); ok {
+			err = unsupportedErr(r.PosixRename(oldname, newname))
+		} else {
+			err = ErrUnsupported
+		}
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+// StatVFS returns the capacity of the filesystem containing name using the
+// statvfs@openssh.com extension.
+func (fs *FileSystem) StatVFS(name string) (*StatVFSInfo, error) {
+	name = fs.abs(name)
+	if err := fs.checkName("statvfs", name); err != nil {
+		return nil, err
+	}
+	err := checkExtension(fs.client, extStatVFS)
+	if err == nil {
+		if s, ok := fs.client.(sftpStatVFSer); ok {
+			var st *sftp.StatVFS
+			if st, err = s.StatVFS(name); err == nil {
+				return &StatVFSInfo{
+					BlockSize:     st.Frsize,
+					TotalBytes:    st.Blocks * st.Frsize,
+					FreeBytes:     st.Bavail * st.Frsize,
+					TotalFiles:    st.Files,
+					FreeFiles:     st.Favail,
+					MaxNameLength: st.Namemax,
+				}, nil
+			}
+			err = unsupportedErr(err)
+		} else {
+			err = ErrUnsupported
+		}
+	}
+	return nil, &os.PathError{Op: "statvfs", Path: name, Err: err}
+}
+
+// Fsync flushes buffered writes and asks the server to commit the file to
+// stable storage using the fsync@openssh.com extension.
+func (f *File) Fsync() error {
+	defer f.ops.begin()()
+	if err := f.Flush(); err != nil {
+		return err
+	}
+	err := checkExtension(f.client, extFsync)
+	if err == nil {
+		if s, ok := f.file.(sftpSyncer); ok {
+			err = unsupportedErr(s.Sync())
+		} else {
+			err = ErrUnsupported
+		}
+	}
+	if err != nil {
+		return f.canceledErr("fsync", &os.PathError{Op: "fsync", Path: f.name, Err: err})
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package sftpfs
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/absfs/memfs"
+	"github.com/absfs/sftpfs/internal/mocks"
+	"github.com/pkg/sftp"
+)
+
+// extensionClient advertises only the listed extensions and counts the
+// extension requests that reach it, failing them with err.
+type extensionClient struct {
+	*mockSFTPClient
+	extensions map[string]bool
+	err        error
+	calls      int
+}
+
+func (c *extensionClient) HasExtension(name string) (string, bool) {
+	return "1", c.extensions[name]
+}
+
+func (c *extensionClient) Link(oldname, newname string) error {
+	c.calls++
+	return c.err
+}
+
+func (c *extensionClient) PosixRename(oldname, newname string) error {
+	c.calls++
+	return c.err
+}
+
+func (c *extensionClient) StatVFS(path string) (*sftp.StatVFS, error) {
+	c.calls++
+	return &sftp.StatVFS{Frsize: 4096, Blocks: 10, Bavail: 4, Files: 100, Favail: 50, Namemax: 255}, c.err
+}
+
+// syncingFile counts fsync requests.
+type syncingFile struct {
+	*mocks.MockSFTPFile
+	syncs int
+	err   error
+}
+
+func (f *syncingFile) Sync() error {
+	f.syncs++
+	return f.err
+}
+
+// extensionCalls runs every extension-dependent operation on fs, returning
+// their errors by name.
+func extensionCalls(fs *FileSystem, file *File) map[string]error {
+	_, statErr := fs.StatVFS("/")
+	return map[string]error{
+		"Link":        fs.Link("/a.txt", "/b.txt"),
+		"PosixRename": fs.PosixRename("/a.txt", "/b.txt"),
+		"StatVFS":     statErr,
+		"Fsync":       file.Fsync(),
+	}
+}
+
+func TestExtensionsUnsupported(t *testing.T) {
+	client := &extensionClient{mockSFTPClient: newMockSFTPClient()}
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+	handle := &syncingFile{MockSFTPFile: &mocks.MockSFTPFile{}}
+	file := &File{file: handle, name: "/a.txt", client: client}
+
+	for op, err := range extensionCalls(fs, file) {
+		if !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%s: expected ErrUnsupported, got %v", op, err)
+		}
+		if !errors.Is(err, errors.ErrUnsupported) {
+			t.Errorf("%s: error does not match errors.ErrUnsupported: %v", op, err)
+		}
+	}
+	if client.calls != 0 || handle.syncs != 0 {
+		t.Errorf("Unadvertised extensions were requested: %d client calls, %d syncs", client.calls, handle.syncs)
+	}
+	if err := file.Sync(); err != nil {
+		t.Errorf("Sync without fsync@openssh.com should only flush, got %v", err)
+	}
+}
+
+func TestExtensionsRejectedByServer(t *testing.T) {
+	status := &sftp.StatusError{Code: uint32(sftp.ErrSSHFxOpUnsupported)}
+	client := &extensionClient{
+		mockSFTPClient: newMockSFTPClient(),
+		extensions:     map[string]bool{extHardlink: true, extPosixRename: true, extStatVFS: true, extFsync: true},
+		err:            status,
+	}
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+	file := &File{file: &syncingFile{MockSFTPFile: &mocks.MockSFTPFile{}, err: status}, name: "/a.txt", client: client}
+
+	for op, err := range extensionCalls(fs, file) {
+		if !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%s: expected ErrUnsupported, got %v", op, err)
+		}
+		var got *sftp.StatusError
+		if !errors.As(err, &got) {
+			t.Errorf("%s: the server's status is not wrapped: %v", op, err)
+		}
+	}
+	if err := file.Sync(); err != nil {
+		t.Errorf("Sync should ignore a rejected fsync, got %v", err)
+	}
+
+	// Other failures are passed through unchanged
+	client.err = &sftp.StatusError{Code: uint32(sftp.ErrSSHFxPermissionDenied)}
+	if err := fs.Link("/a.txt", "/b.txt"); errors.Is(err, ErrUnsupported) {
+		t.Errorf("Permission denied reported as unsupported: %v", err)
+	}
+}
+
+func TestExtensionsSupported(t *testing.T) {
+	client := &extensionClient{
+		mockSFTPClient: newMockSFTPClient(),
+		extensions:     map[string]bool{extHardlink: true, extPosixRename: true, extStatVFS: true, extFsync: true},
+	}
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+	handle := &syncingFile{MockSFTPFile: &mocks.MockSFTPFile{}}
+	file := &File{file: handle, name: "/a.txt", client: client}
+
+	for op, err := range extensionCalls(fs, file) {
+		if err != nil {
+			t.Errorf("%s failed: %v", op, err)
+		}
+	}
+	if err := file.Sync(); err != nil || handle.syncs != 2 {
+		t.Errorf("Sync = %v after %d fsyncs, want fsync requested", err, handle.syncs)
+	}
+	info, _ := fs.StatVFS("/")
+	if info.TotalBytes != 40960 || info.FreeBytes != 16384 || info.FreeFiles != 50 {
+		t.Errorf("StatVFS = %+v", info)
+	}
+}
+
+func TestServer_StatVFSUnsupported(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+
+	if _, err := fs.StatVFS("/"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("StatVFS without a reported capacity: expected ErrUnsupported, got %v", err)
+	}
+
+	f, err := fs.OpenFile("/synced.txt", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer f.Close()
+	if err := f.(*File).Fsync(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Fsync without fsync@openssh.com: expected ErrUnsupported, got %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Errorf("Sync failed: %v", err)
+	}
+
+	capacity := &StatVFSInfo{TotalBytes: 1 << 30, FreeBytes: 1 << 20, TotalFiles: 1000, FreeFiles: 10}
+	_, listener = testServerListen(t, mfs, &ServerConfig{ReportedCapacity: capacity})
+	defer listener.Close()
+	fs2, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs2.Close()
+	info, err := fs2.StatVFS("/")
+	if err != nil {
+		t.Fatalf("StatVFS failed: %v", err)
+	}
+	if info.TotalBytes != capacity.TotalBytes || info.FreeBytes != capacity.FreeBytes || info.FreeFiles != 10 {
+		t.Errorf("StatVFS = %+v, want %+v", info, capacity)
+	}
+}
@@ -0,0 +1,65 @@
+package sftpfs
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// errCompression is returned by New when Config.Compression is set.
+var errCompression = fmt.Errorf("sftpfs: SSH compression (zlib@openssh.com): %w", ErrUnsupported)
+
+// WriteFileGzip creates or truncates the named file and fills it with the
+// gzip-compressed contents of r, for compressible data sent over slow links
+// now that the SSH transport cannot be compressed (see Config.Compression).
+// The file holds the compressed stream, so readers must decompress it, for
+// example with OpenGzip. It returns the number of uncompressed bytes read
+// from r.
+func (fs *FileSystem) WriteFileGzip(name string, r io.Reader, perm os.FileMode) (int64, error) {
+	name = fs.abs(name)
+	f, err := fs.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return 0, err
+	}
+	zw := gzip.NewWriter(f)
+	n, err := copyChunks(zw, r, fs.transferChunkSize())
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// OpenGzip opens the named gzip file, such as one written by WriteFileGzip,
+// and returns a reader of its decompressed contents. Closing the reader
+// closes the file.
+func (fs *FileSystem) OpenGzip(name string) (io.ReadCloser, error) {
+	name = fs.abs(name)
+	f, err := fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &gzipFile{Reader: zr, file: f}, nil
+}
+
+// gzipFile is the reader returned by OpenGzip.
+type gzipFile struct {
+	*gzip.Reader
+	file io.Closer
+}
+
+func (g *gzipFile) Close() error {
+	err := g.Reader.Close()
+	if cerr := g.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package sftpfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+)
+
+func TestCompressionUnsupported(t *testing.T) {
+	_, err := New(&Config{Host: "localhost:1", User: "testuser", Password: "testpass", Compression: true})
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for Compression, got %v", err)
+	}
+}
+
+func TestGzipRoundTrip(t *testing.T) {
+	client := newMockSFTPClient()
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+	data := bytes.Repeat([]byte("compressible log line\n"), 5000)
+
+	n, err := fs.WriteFileGzip("/app.log.gz", bytes.NewReader(data), 0644)
+	if err != nil {
+		t.Fatalf("WriteFileGzip failed: %v", err)
+	}
+	if n != int64(len(data)) {
+		t.Errorf("WriteFileGzip read %d bytes, want %d", n, len(data))
+	}
+	stored := client.files["/app.log.gz"].Data
+	if len(stored) >= len(data)/10 {
+		t.Errorf("Stored %d bytes for %d of repetitive input", len(stored), len(data))
+	}
+	if _, err := gzip.NewReader(bytes.NewReader(stored)); err != nil {
+		t.Errorf("Stored data is not gzip: %v", err)
+	}
+
+	r, err := fs.OpenGzip("/app.log.gz")
+	if err != nil {
+		t.Fatalf("OpenGzip failed: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("Decompressed content differs")
+	}
+
+	if err := fs.WriteFile("/plain.txt", []byte("not gzip"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if _, err := fs.OpenGzip("/plain.txt"); err == nil {
+		t.Error("Expected OpenGzip of a plain file to fail")
+	}
+}
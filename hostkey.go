@@ -0,0 +1,36 @@
+package sftpfs
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrHostKeyMismatch is returned by New when Config.HostKeyFingerprint is
+// set and the server presents a host key with a different fingerprint.
+var ErrHostKeyMismatch = errors.New("ssh: host key fingerprint mismatch")
+
+// hostKeyFingerprintCallback returns a HostKeyCallback accepting only a
+// host key whose SHA256 fingerprint equals want. want may be given as
+// printed by ssh-keygen -l ("SHA256:..."), or as the bare base64, with or
+// without padding.
+func hostKeyFingerprintCallback(want string) ssh.HostKeyCallback {
+	want = normalizeFingerprint(want)
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		got := ssh.FingerprintSHA256(key)
+		if normalizeFingerprint(got) != want {
+			return fmt.Errorf("%w: %s presented %s, want SHA256:%s", ErrHostKeyMismatch, hostname, got, want)
+		}
+		return nil
+	}
+}
+
+// normalizeFingerprint strips the "SHA256:" prefix and base64 padding.
+func normalizeFingerprint(fp string) string {
+	fp = strings.TrimSpace(fp)
+	fp = strings.TrimPrefix(fp, "SHA256:")
+	return strings.TrimRight(fp, "=")
+}
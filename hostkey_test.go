@@ -0,0 +1,48 @@
+package sftpfs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/absfs/memfs"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestHostKeyFingerprint(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	hostKey := testHostKey(t)
+	_, listener := testServerListen(t, mfs, &ServerConfig{HostKeys: []ssh.Signer{hostKey}})
+	defer listener.Close()
+
+	fingerprint := ssh.FingerprintSHA256(hostKey.PublicKey())
+	for _, fp := range []string{fingerprint, strings.TrimPrefix(fingerprint, "SHA256:") + "="} {
+		fs, err := New(&Config{
+			Host:               listener.Addr().String(),
+			User:               "testuser",
+			Password:           "testpass",
+			HostKeyFingerprint: fp,
+		})
+		if err != nil {
+			t.Fatalf("New with fingerprint %q failed: %v", fp, err)
+		}
+		fs.Close()
+	}
+
+	other := ssh.FingerprintSHA256(testHostKey(t).PublicKey())
+	_, err = New(&Config{
+		Host:               listener.Addr().String(),
+		User:               "testuser",
+		Password:           "testpass",
+		HostKeyFingerprint: other,
+	})
+	if !errors.Is(err, ErrHostKeyMismatch) {
+		t.Fatalf("Expected ErrHostKeyMismatch, got %v", err)
+	}
+	if !strings.Contains(err.Error(), fingerprint) {
+		t.Errorf("Expected error to name the presented fingerprint, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package sftpfs
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrCanceled is wrapped by the errors of file operations aborted by
+// CancelAll.
+var ErrCanceled = errors.New("operation canceled")
+
+// opTracker counts the file operations in progress on a FileSystem and
+// records its open handles so CancelAll can abort them. Methods are no-ops
+// on a nil receiver, so files created without a FileSystem are not tracked.
+type opTracker struct {
+	inFlight atomic.Int64
+
+	mu      sync.Mutex
+	handles map[*openHandle]struct{}
+}
+
+// openHandle is the part of a File that CancelAll needs. The tracker holds
+// these rather than the Files so that an unclosed File can still be
+// garbage collected and reported by Config.WarnOnLeak.
+type openHandle struct {
+	file     sftpFileInterface
+	canceled atomic.Bool // set when CancelAll closed the handle
+}
+
+// isCanceled reports whether CancelAll closed the handle. It is false for
+// a nil receiver.
+func (h *openHandle) isCanceled() bool {
+	return h != nil && h.canceled.Load()
+}
+
+// add records file as open and returns its handle.
+func (t *opTracker) add(file sftpFileInterface) *openHandle {
+	if t == nil {
+		return nil
+	}
+	h := &openHandle{file: file}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.handles == nil {
+		t.handles = make(map[*openHandle]struct{})
+	}
+	t.handles[h] = struct{}{}
+	return h
+}
+
+// remove forgets h once its file is closed.
+func (t *opTracker) remove(h *openHandle) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	delete(t.handles, h)
+	t.mu.Unlock()
+}
+
+// begin counts an operation as in flight and returns the function that
+// ends it, for use as defer t.begin()().
+func (t *opTracker) begin() func() {
+	if t == nil {
+		return func() {}
+	}
+	t.inFlight.Add(1)
+	return func() { t.inFlight.Add(-1) }
+}
+
+// InFlight returns the number of reads, writes and truncates currently
+// executing on files opened through fs.
+func (fs *FileSystem) InFlight() int {
+	return int(fs.ops.inFlight.Load())
+}
+
+// CancelAll aborts every operation in progress on files opened through fs
+// by closing their handles on the server, for shutting down without waiting
+// for transfers to finish. Aborted operations fail with an error wrapping
+// ErrCanceled, as does any later operation the closed handle rejects. Files
+// opened after CancelAll returns are unaffected. Operations on the
+// FileSystem itself, such as Stat, are not canceled.
+func (fs *FileSystem) CancelAll() {
+	fs.ops.mu.Lock()
+	handles := make([]*openHandle, 0, len(fs.ops.handles))
+	for h := range fs.ops.handles {
+		handles = append(handles, h)
+	}
+	fs.ops.mu.Unlock()
+
+	for _, h := range handles {
+		if h.canceled.CompareAndSwap(false, true) {
+			h.file.Close()
+		}
+	}
+}
+
+// canceledErr replaces err with ErrCanceled if f was aborted by CancelAll.
+func (f *File) canceledErr(op string, err error) error {
+	if err != nil && f.handle.isCanceled() {
+		return &os.PathError{Op: op, Path: f.name, Err: ErrCanceled}
+	}
+	return err
+}
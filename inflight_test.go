@@ -0,0 +1,92 @@
+package sftpfs
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+)
+
+// blockingFile blocks every Read until the handle is closed, like a
+// transfer stalled on an unresponsive server.
+type blockingFile struct {
+	*mocks.MockSFTPFile
+	started chan<- struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (f *blockingFile) Read(b []byte) (int, error) {
+	f.started <- struct{}{}
+	<-f.release
+	return 0, os.ErrClosed
+}
+
+func (f *blockingFile) Close() error {
+	f.once.Do(func() { close(f.release) })
+	return f.MockSFTPFile.Close()
+}
+
+type blockingClient struct {
+	*mockSFTPClient
+	started chan struct{}
+}
+
+func (c *blockingClient) OpenFile(path string, flag int) (sftpFileInterface, error) {
+	file, err := c.mockSFTPClient.OpenFile(path, flag)
+	if err != nil {
+		return nil, err
+	}
+	return &blockingFile{MockSFTPFile: file.(*mocks.MockSFTPFile), started: c.started, release: make(chan struct{})}, nil
+}
+
+func TestCancelAll(t *testing.T) {
+	const n = 3
+	client := &blockingClient{mockSFTPClient: newMockSFTPClient(), started: make(chan struct{})}
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+
+	var files []*File
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		f, err := fs.OpenFile("/stalled.txt", os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatalf("OpenFile failed: %v", err)
+		}
+		files = append(files, f.(*File))
+		go func() {
+			_, err := f.Read(make([]byte, 10))
+			errs <- err
+		}()
+		<-client.started
+	}
+	if got := fs.InFlight(); got != n {
+		t.Errorf("InFlight = %d, want %d", got, n)
+	}
+
+	fs.CancelAll()
+	for i := 0; i < n; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrCanceled) {
+				t.Errorf("Expected ErrCanceled, got %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("CancelAll did not unblock the reads")
+		}
+	}
+	if got := fs.InFlight(); got != 0 {
+		t.Errorf("InFlight after CancelAll = %d, want 0", got)
+	}
+
+	for _, f := range files {
+		if err := f.Close(); err != nil {
+			t.Errorf("Close after CancelAll failed: %v", err)
+		}
+	}
+	if len(fs.ops.handles) != 0 {
+		t.Errorf("Closed files are still tracked: %d", len(fs.ops.handles))
+	}
+}
@@ -4,6 +4,7 @@ package sftpfs
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -406,6 +407,92 @@ func TestIntegrationChmod(t *testing.T) {
 	fs.Remove(testFile)
 }
 
+// TestIntegrationCreateMode tests that OpenFile applies perm to new files.
+func TestIntegrationCreateMode(t *testing.T) {
+	fs := skipIfNoServer(t)
+	defer fs.Close()
+
+	testFile := filepath.Join(testBaseDir, "test_create_mode.txt")
+	fs.Remove(testFile)
+
+	file, err := fs.OpenFile(testFile, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	file.Close()
+	defer fs.Remove(testFile)
+
+	info, err := fs.Stat(testFile)
+	if err != nil {
+		t.Fatalf("Failed to stat: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+// TestIntegrationCreateModeMethod tests that CreateMode creates a file with
+// the requested mode before any data is written.
+func TestIntegrationCreateModeMethod(t *testing.T) {
+	fs := skipIfNoServer(t)
+	defer fs.Close()
+
+	testFile := filepath.Join(testBaseDir, "test_create_mode_secret.txt")
+	fs.Remove(testFile)
+
+	file, err := fs.CreateMode(testFile, 0600)
+	if err != nil {
+		t.Fatalf("CreateMode failed: %v", err)
+	}
+	defer fs.Remove(testFile)
+
+	info, err := fs.Stat(testFile)
+	if err != nil {
+		t.Fatalf("Failed to stat: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600 before writing, got %v", info.Mode().Perm())
+	}
+	if _, err := file.WriteString("secret"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+// TestIntegrationRemoveDirNotEmpty tests the typed error for non-empty directories.
+func TestIntegrationRemoveDirNotEmpty(t *testing.T) {
+	fs := skipIfNoServer(t)
+	defer fs.Close()
+
+	testDir := filepath.Join(testBaseDir, "test_remove_not_empty")
+	testFile := filepath.Join(testDir, "file.txt")
+	fs.Remove(testFile)
+	fs.Remove(testDir)
+
+	if err := fs.Mkdir(testDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer fs.Remove(testDir)
+	file, err := fs.OpenFile(testFile, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	file.Close()
+
+	if err := fs.Remove(testDir); !errors.Is(err, ErrDirNotEmpty) {
+		t.Fatalf("Expected ErrDirNotEmpty, got %v", err)
+	}
+
+	if err := fs.Remove(testFile); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if err := fs.Remove(testDir); err != nil {
+		t.Errorf("Failed to remove empty directory: %v", err)
+	}
+}
+
 // TestIntegrationChtimes tests file time changes.
 func TestIntegrationChtimes(t *testing.T) {
 	fs := skipIfNoServer(t)
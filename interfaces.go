@@ -20,6 +20,25 @@ type sftpClientInterface interface {
 	ReadDir(path string) ([]os.FileInfo, error)
 }
 
+// sftpLinker is implemented by clients that can create hard links using
+// the hardlink@openssh.com extension.
+type sftpLinker interface {
+	Link(oldname, newname string) error
+}
+
+// sftpSymlinkReader is implemented by clients that can stat a symbolic link
+// itself and read its target.
+type sftpSymlinkReader interface {
+	Lstat(path string) (os.FileInfo, error)
+	ReadLink(path string) (string, error)
+}
+
+// sftpGetwder is implemented by clients that can report the server's
+// working directory for the session.
+type sftpGetwder interface {
+	Getwd() (string, error)
+}
+
 // sftpFileInterface defines the methods we use from *sftp.File.
 type sftpFileInterface interface {
 	Read(b []byte) (int, error)
@@ -30,6 +49,8 @@ type sftpFileInterface interface {
 	Close() error
 	Stat() (os.FileInfo, error)
 	Truncate(size int64) error
+	Chmod(mode os.FileMode) error
+	Chown(uid, gid int) error
 }
 
 // sshClientInterface defines the methods we use from *ssh.Client.
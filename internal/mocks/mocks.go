@@ -35,7 +35,11 @@ type MockSFTPFile struct {
 	SeekErr     error
 	StatErr     error
 	TruncateErr error
+	ChmodErr    error
+	ChownErr    error
 	StatInfo    os.FileInfo
+	Perm        os.FileMode
+	UID, GID    int
 	Closed      bool
 }
 
@@ -127,12 +131,32 @@ func (f *MockSFTPFile) Stat() (os.FileInfo, error) {
 	if f.StatInfo != nil {
 		return f.StatInfo, nil
 	}
+	mode := f.Perm
+	if mode == 0 {
+		mode = 0644
+	}
 	return &MockFileInfo{
 		FileSize: int64(len(f.Data)),
-		FileMode: 0644,
+		FileMode: mode,
 	}, nil
 }
 
+func (f *MockSFTPFile) Chmod(mode os.FileMode) error {
+	if f.ChmodErr != nil {
+		return f.ChmodErr
+	}
+	f.Perm = mode
+	return nil
+}
+
+func (f *MockSFTPFile) Chown(uid, gid int) error {
+	if f.ChownErr != nil {
+		return f.ChownErr
+	}
+	f.UID, f.GID = uid, gid
+	return nil
+}
+
 func (f *MockSFTPFile) Truncate(size int64) error {
 	if f.TruncateErr != nil {
 		return f.TruncateErr
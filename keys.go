@@ -0,0 +1,71 @@
+package sftpfs
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LoadHostKeys reads private host keys from PEM files, such as
+// /etc/ssh/ssh_host_ed25519_key, and returns signers for
+// ServerConfig.HostKeys in the same order. RSA, ECDSA and ed25519 keys are
+// accepted in OpenSSH, PKCS#1, PKCS#8 and SEC 1 formats. Encrypted keys
+// need LoadHostKeysWithPassphrase.
+func LoadHostKeys(paths ...string) ([]ssh.Signer, error) {
+	return LoadHostKeysWithPassphrase(nil, paths...)
+}
+
+// LoadHostKeysWithPassphrase is like LoadHostKeys but decrypts encrypted
+// keys with passphrase. Unencrypted keys may be mixed in.
+func LoadHostKeysWithPassphrase(passphrase []byte, paths ...string) ([]ssh.Signer, error) {
+	signers := make([]ssh.Signer, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) && passphrase != nil {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(data, passphrase)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("sftpfs: host key %s: %w", path, err)
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+// GenerateHostKey returns a fresh ed25519 host key for test servers and
+// development setups. The key lives only in memory, so clients see a new
+// host key every time the server restarts; production servers should use
+// LoadHostKeys.
+func GenerateHostKey() (ssh.Signer, error) {
+	return GenerateHostKeyType("ed25519")
+}
+
+// GenerateHostKeyType is like GenerateHostKey but generates a key of the
+// given kind, "ed25519" or "rsa" (3072 bits).
+func GenerateHostKeyType(kind string) (ssh.Signer, error) {
+	var key crypto.Signer
+	var err error
+	switch kind {
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case "rsa":
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return nil, fmt.Errorf("sftpfs: unsupported host key type %q", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(key)
+}
@@ -0,0 +1,23 @@
+package sftpfs
+
+import (
+	"log/slog"
+	"runtime"
+)
+
+// logger returns the configured Logger or slog.Default.
+func (config *Config) logger() *slog.Logger {
+	if config.Logger != nil {
+		return config.Logger
+	}
+	return slog.Default()
+}
+
+// watchLeak arranges for a warning to be logged if f is garbage collected
+// before Close clears the finalizer.
+func (config *Config) watchLeak(f *File) {
+	logger := config.logger()
+	runtime.SetFinalizer(f, func(f *File) {
+		logger.Warn("sftp file garbage collected without Close", "path", f.name)
+	})
+}
@@ -0,0 +1,42 @@
+package sftpfs
+
+import (
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWarnOnLeak(t *testing.T) {
+	var logs syncBuffer
+	fs := &FileSystem{
+		client: newMockSFTPClient(),
+		config: Config{WarnOnLeak: true, Logger: slog.New(slog.NewTextHandler(&logs, nil))},
+	}
+
+	func() {
+		if _, err := fs.OpenFile("/leaked.txt", os.O_RDWR|os.O_CREATE, 0644); err != nil {
+			t.Fatalf("OpenFile failed: %v", err)
+		}
+		f, err := fs.OpenFile("/closed.txt", os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatalf("OpenFile failed: %v", err)
+		}
+		f.Close()
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), "/leaked.txt") && time.Now().Before(deadline) {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	out := logs.String()
+	if !strings.Contains(out, "garbage collected without Close") || !strings.Contains(out, "/leaked.txt") {
+		t.Fatalf("Expected leak warning for /leaked.txt, got %q", out)
+	}
+	if strings.Contains(out, "/closed.txt") {
+		t.Errorf("Closed file should not be reported as leaked: %q", out)
+	}
+}
@@ -0,0 +1,67 @@
+package sftpfs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrNoCommonAlgorithm is wrapped by the error New returns when the SSH
+// handshake fails because client and server share no key exchange, cipher,
+// MAC or host key algorithm.
+var ErrNoCommonAlgorithm = errors.New("no common SSH algorithm")
+
+// Algorithm lists enabled by Config.LegacyAlgorithms. Besides the modern
+// defaults they include CBC and RC4 ciphers, SHA-1 key exchanges and MACs,
+// and ssh-rsa and ssh-dss host keys, all of which are considered weak.
+var (
+	legacyCiphers = []string{
+		"aes128-gcm@openssh.com", "aes256-gcm@openssh.com",
+		"chacha20-poly1305@openssh.com",
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		"aes128-cbc", "3des-cbc",
+		"arcfour256", "arcfour128", "arcfour",
+	}
+	legacyKeyExchanges = []string{
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512",
+		"diffie-hellman-group-exchange-sha256",
+		"diffie-hellman-group14-sha1", "diffie-hellman-group-exchange-sha1",
+		"diffie-hellman-group1-sha1",
+	}
+	legacyMACs = []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+		"hmac-sha2-256", "hmac-sha2-512",
+		"hmac-sha1", "hmac-sha1-96",
+	}
+	legacyHostKeyAlgorithms = []string{
+		ssh.KeyAlgoED25519,
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+		ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+	}
+)
+
+// applyLegacyAlgorithms widens sshConfig to every algorithm
+// golang.org/x/crypto/ssh implements, including insecure ones.
+func applyLegacyAlgorithms(sshConfig *ssh.ClientConfig) {
+	sshConfig.Ciphers = legacyCiphers
+	sshConfig.KeyExchanges = legacyKeyExchanges
+	sshConfig.MACs = legacyMACs
+	sshConfig.HostKeyAlgorithms = legacyHostKeyAlgorithms
+}
+
+// algorithmError annotates a handshake error caused by failed algorithm
+// negotiation with a hint about Config.LegacyAlgorithms.
+func (config *Config) algorithmError(err error) error {
+	if err == nil || !strings.Contains(err.Error(), "no common algorithm") {
+		return err
+	}
+	if config.LegacyAlgorithms {
+		return fmt.Errorf("%w: %w", ErrNoCommonAlgorithm, err)
+	}
+	return fmt.Errorf("%w (the server may only support older algorithms; see Config.LegacyAlgorithms): %w", ErrNoCommonAlgorithm, err)
+}
@@ -0,0 +1,76 @@
+package sftpfs
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestLegacyAlgorithms(t *testing.T) {
+	orig := sshDial
+	defer func() { sshDial = orig }()
+
+	var got *ssh.ClientConfig
+	sshDial = func(network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+		got = config
+		return nil, errServerBusy
+	}
+
+	New(&Config{Host: "localhost:22", User: "testuser", Password: "testpass"})
+	if got.Ciphers != nil || got.KeyExchanges != nil || got.MACs != nil || got.HostKeyAlgorithms != nil {
+		t.Errorf("Expected default algorithms without LegacyAlgorithms, got %+v", got.Config)
+	}
+
+	New(&Config{Host: "localhost:22", User: "testuser", Password: "testpass", LegacyAlgorithms: true})
+	if !slices.Equal(got.Ciphers, legacyCiphers) {
+		t.Errorf("Ciphers = %v, want %v", got.Ciphers, legacyCiphers)
+	}
+	if !slices.Equal(got.KeyExchanges, legacyKeyExchanges) {
+		t.Errorf("KeyExchanges = %v, want %v", got.KeyExchanges, legacyKeyExchanges)
+	}
+	if !slices.Equal(got.MACs, legacyMACs) {
+		t.Errorf("MACs = %v, want %v", got.MACs, legacyMACs)
+	}
+	if !slices.Equal(got.HostKeyAlgorithms, legacyHostKeyAlgorithms) {
+		t.Errorf("HostKeyAlgorithms = %v, want %v", got.HostKeyAlgorithms, legacyHostKeyAlgorithms)
+	}
+	var all []string
+	for _, list := range [][]string{got.Ciphers, got.KeyExchanges, got.MACs, got.HostKeyAlgorithms} {
+		all = append(all, list...)
+	}
+	for _, alg := range []string{"aes128-cbc", "diffie-hellman-group1-sha1", "hmac-sha1", ssh.KeyAlgoRSA} {
+		if !slices.Contains(all, alg) {
+			t.Errorf("Expected legacy algorithm %s to be enabled", alg)
+		}
+	}
+}
+
+func TestLegacyAlgorithmsHint(t *testing.T) {
+	orig := sshDial
+	defer func() { sshDial = orig }()
+
+	negotiation := errors.New("ssh: handshake failed: ssh: no common algorithm for key exchange; client offered: [curve25519-sha256], server offered: [diffie-hellman-group1-sha1]")
+	sshDial = func(network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+		return nil, negotiation
+	}
+
+	_, err := New(&Config{Host: "localhost:22", User: "testuser", Password: "testpass"})
+	if !errors.Is(err, ErrNoCommonAlgorithm) || !errors.Is(err, negotiation) {
+		t.Fatalf("Expected ErrNoCommonAlgorithm wrapping the handshake error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "LegacyAlgorithms") {
+		t.Errorf("Expected error to mention LegacyAlgorithms, got %v", err)
+	}
+
+	// Other dial errors are returned unchanged
+	sshDial = func(network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+		return nil, errServerBusy
+	}
+	_, err = New(&Config{Host: "localhost:22", User: "testuser", Password: "testpass"})
+	if err != errServerBusy {
+		t.Errorf("Expected %v, got %v", errServerBusy, err)
+	}
+}
@@ -0,0 +1,110 @@
+package sftpfs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+// ListLong writes a listing of the named directory to w in the format of
+// ls -l: mode, owner and group IDs, size, modification time and name, one
+// entry per line in the server's order, followed by " -> target" for
+// symbolic links. The attributes come with the listing, so only links cost
+// an extra request each.
+func (fs *FileSystem) ListLong(name string, w io.Writer) error {
+	name = fs.abs(name)
+	if err := fs.checkName("readdir", name); err != nil {
+		return err
+	}
+	var infos []os.FileInfo
+	err := fs.config.withRetry(func() (err error) {
+		infos, err = fs.client.ReadDir(name)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	type row struct {
+		mode, uid, gid, size, mtime, name string
+	}
+	rows := make([]row, len(infos))
+	var uidWidth, gidWidth, sizeWidth int
+	now := time.Now()
+	links, _ := fs.client.(sftpSymlinkReader)
+	for i, info := range infos {
+		st := statExtended(info)
+		r := row{
+			mode:  lsMode(info.Mode()),
+			uid:   strconv.FormatUint(uint64(st.UID), 10),
+			gid:   strconv.FormatUint(uint64(st.GID), 10),
+			size:  strconv.FormatInt(info.Size(), 10),
+			mtime: lsTime(info.ModTime(), now),
+			name:  info.Name(),
+		}
+		if info.Mode()&os.ModeSymlink != 0 && links != nil {
+			if target, err := links.ReadLink(path.Join(name, info.Name())); err == nil {
+				r.name += " -> " + target
+			}
+		}
+		uidWidth = max(uidWidth, len(r.uid))
+		gidWidth = max(gidWidth, len(r.gid))
+		sizeWidth = max(sizeWidth, len(r.size))
+		rows[i] = r
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, r := range rows {
+		fmt.Fprintf(bw, "%s %-*s %-*s %*s %s %s\n", r.mode, uidWidth, r.uid, gidWidth, r.gid, sizeWidth, r.size, r.mtime, r.name)
+	}
+	return bw.Flush()
+}
+
+// lsMode formats mode as ls does, such as "drwxr-xr-x" or "lrwxrwxrwx".
+func lsMode(mode os.FileMode) string {
+	b := []byte(mode.Perm().String())
+	switch {
+	case mode.IsDir():
+		b[0] = 'd'
+	case mode&os.ModeSymlink != 0:
+		b[0] = 'l'
+	case mode&os.ModeNamedPipe != 0:
+		b[0] = 'p'
+	case mode&os.ModeSocket != 0:
+		b[0] = 's'
+	case mode&os.ModeCharDevice != 0:
+		b[0] = 'c'
+	case mode&os.ModeDevice != 0:
+		b[0] = 'b'
+	}
+	for i, bit := range []os.FileMode{os.ModeSetuid, os.ModeSetgid, os.ModeSticky} {
+		if mode&bit == 0 {
+			continue
+		}
+		pos := 3 + 3*i
+		lower, upper := byte('s'), byte('S')
+		if bit == os.ModeSticky {
+			lower, upper = 't', 'T'
+		}
+		if b[pos] == 'x' {
+			b[pos] = lower
+		} else {
+			b[pos] = upper
+		}
+	}
+	return string(b)
+}
+
+// lsTime formats t as ls does: with the time of day if it is within six
+// months of now, and with the year otherwise.
+func lsTime(t, now time.Time) string {
+	const halfYear = 182 * 24 * time.Hour
+	if d := now.Sub(t); d < halfYear && d > -halfYear {
+		return t.Format("Jan _2 15:04")
+	}
+	return t.Format("Jan _2  2006")
+}
@@ -0,0 +1,72 @@
+package sftpfs
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+	"github.com/pkg/sftp"
+)
+
+func TestListLong(t *testing.T) {
+	mtime := time.Date(2020, time.March, 4, 10, 30, 0, 0, time.UTC)
+	client := &symlinkClient{mockSFTPClient: newMockSFTPClient(), links: map[string]string{"/dir/current": "releases/v2"}}
+	client.dirs["/dir"] = []os.FileInfo{
+		&mocks.MockFileInfo{FileName: "app.log", FileSize: 1234, FileMode: 0640, FileModTime: mtime,
+			FileSys: &sftp.FileStat{UID: 1000, GID: 100, Mtime: uint32(mtime.Unix())}},
+		&mocks.MockFileInfo{FileName: "releases", FileSize: 4096, FileMode: os.ModeDir | 0755, FileIsDir: true, FileModTime: mtime},
+		&mocks.MockFileInfo{FileName: "current", FileSize: 11, FileMode: os.ModeSymlink | 0777, FileModTime: mtime},
+	}
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+
+	var out strings.Builder
+	if err := fs.ListLong("/dir", &out); err != nil {
+		t.Fatalf("ListLong failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %q", out.String())
+	}
+	for i, want := range []string{
+		"-rw-r----- 1000 100 1234 Mar  4  2020 app.log",
+		"drwxr-xr-x 0    0   4096 Mar  4  2020 releases",
+		"lrwxrwxrwx 0    0     11 Mar  4  2020 current -> releases/v2",
+	} {
+		if lines[i] != want {
+			t.Errorf("Line %d = %q, want %q", i, lines[i], want)
+		}
+	}
+
+	if err := fs.ListLong("/missing", &out); err == nil {
+		t.Error("Expected an error listing a missing directory")
+	}
+}
+
+func TestLsMode(t *testing.T) {
+	for mode, want := range map[os.FileMode]string{
+		0644:                              "-rw-r--r--",
+		os.ModeDir | os.ModeSticky | 0777: "drwxrwxrwt",
+		os.ModeSetuid | 0755:              "-rwsr-xr-x",
+		os.ModeSetgid | 0640:              "-rw-r-S---",
+		os.ModeNamedPipe | 0600:           "prw-------",
+		os.ModeDevice | os.ModeCharDevice: "c---------",
+		os.ModeDevice | 0660:              "brw-rw----",
+		os.ModeSocket | 0755:              "srwxr-xr-x",
+	} {
+		if got := lsMode(mode); got != want {
+			t.Errorf("lsMode(%v) = %q, want %q", mode, got, want)
+		}
+	}
+}
+
+func TestLsTime(t *testing.T) {
+	now := time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC)
+	if got := lsTime(now.Add(-48*time.Hour), now); got != "Jun 13 12:00" {
+		t.Errorf("Recent time = %q", got)
+	}
+	if got := lsTime(now.AddDate(-1, 0, 0), now); got != "Jun 15  2023" {
+		t.Errorf("Old time = %q", got)
+	}
+}
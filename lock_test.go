@@ -0,0 +1,190 @@
+package sftpfs
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+)
+
+// exclClient is a mockSFTPClient whose OpenFile honors O_EXCL.
+type exclClient struct {
+	*mockSFTPClient
+}
+
+func (c *exclClient) OpenFile(path string, f int) (sftpFileInterface, error) {
+	if _, ok := c.files[path]; ok && f&os.O_CREATE != 0 && f&os.O_EXCL != 0 {
+		return nil, os.ErrExist
+	}
+	return c.mockSFTPClient.OpenFile(path, f)
+}
+
+func TestFileLock(t *testing.T) {
+	client := &exclClient{mockSFTPClient: newMockSFTPClient()}
+	fs := &FileSystem{client: client}
+
+	first, err := fs.OpenFile("/data.txt", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	second, err := fs.OpenFile("/data.txt", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	f1, f2 := first.(*File), second.(*File)
+
+	if err := f1.Lock(); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if _, ok := client.files["/data.txt.lock"]; !ok {
+		t.Fatal("Expected lock file to be created")
+	}
+	if err := f1.Lock(); err != nil {
+		t.Errorf("Lock on a held lock should be a no-op, got %v", err)
+	}
+	if err := f2.Lock(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Expected ErrLocked, got %v", err)
+	}
+
+	start := time.Now()
+	if err := f2.LockTimeout(120 * time.Millisecond); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Expected ErrLocked after timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("LockTimeout returned after %v, expected it to wait", elapsed)
+	}
+
+	if err := f1.Unlock(); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	if _, ok := client.files["/data.txt.lock"]; ok {
+		t.Error("Expected lock file to be removed")
+	}
+	if err := f2.Lock(); err != nil {
+		t.Fatalf("Lock after Unlock failed: %v", err)
+	}
+	if err := f2.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, ok := client.files["/data.txt.lock"]; ok {
+		t.Error("Expected Close to remove the lock file")
+	}
+	if err := f1.Unlock(); err != nil {
+		t.Errorf("Unlock without the lock should be a no-op, got %v", err)
+	}
+}
+
+// denyLockClient is a mockSFTPClient that refuses to create lock files.
+type denyLockClient struct {
+	*mockSFTPClient
+}
+
+func (c *denyLockClient) OpenFile(path string, f int) (sftpFileInterface, error) {
+	if strings.HasSuffix(path, lockSuffix) {
+		return nil, os.ErrPermission
+	}
+	return c.mockSFTPClient.OpenFile(path, f)
+}
+
+func TestFileLockTimeoutOtherError(t *testing.T) {
+	fs := &FileSystem{client: &denyLockClient{mockSFTPClient: newMockSFTPClient()}}
+	f, err := fs.OpenFile("/data.txt", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+
+	// A lock file that cannot be created is reported as such, not as held
+	if err := f.(*File).LockTimeout(120 * time.Millisecond); !errors.Is(err, os.ErrPermission) || errors.Is(err, ErrLocked) {
+		t.Fatalf("Expected ErrPermission, got %v", err)
+	}
+}
+
+// releasingClient is an exclClient whose lock holder releases the lock
+// between the next failed open and the Stat that follows it.
+type releasingClient struct {
+	*exclClient
+	release bool
+}
+
+func (c *releasingClient) OpenFile(path string, f int) (sftpFileInterface, error) {
+	file, err := c.exclClient.OpenFile(path, f)
+	if err != nil && c.release && strings.HasSuffix(path, lockSuffix) {
+		c.release = false
+		delete(c.files, path)
+	}
+	return file, err
+}
+
+func TestFileLockReleasedDuringCheck(t *testing.T) {
+	client := &releasingClient{exclClient: &exclClient{mockSFTPClient: newMockSFTPClient()}}
+	fs := &FileSystem{client: client}
+	first, err := fs.OpenFile("/data.txt", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	second, err := fs.OpenFile("/data.txt", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	if err := first.(*File).Lock(); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+
+	// Even without waiting, a lock released after the failed open is taken
+	client.release = true
+	if err := second.(*File).Lock(); err != nil {
+		t.Fatalf("Lock after release failed: %v", err)
+	}
+}
+
+func TestFileLockTimeoutWaits(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+
+	dial := func() *FileSystem {
+		fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		return fs
+	}
+	fs1, fs2 := dial(), dial()
+	defer fs1.Close()
+	defer fs2.Close()
+
+	open := func(fs *FileSystem) *File {
+		f, err := fs.OpenFile("/shared.txt", os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatalf("OpenFile failed: %v", err)
+		}
+		return f.(*File)
+	}
+	holder, waiter := open(fs1), open(fs2)
+	defer waiter.Close()
+
+	if err := holder.Lock(); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if err := waiter.Lock(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Expected ErrLocked from a second client, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		holder.Close()
+	}()
+	start := time.Now()
+	if err := waiter.LockTimeout(5 * time.Second); err != nil {
+		t.Fatalf("LockTimeout failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("LockTimeout returned after %v, expected it to block until release", elapsed)
+	}
+}
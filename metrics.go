@@ -0,0 +1,75 @@
+package sftpfs
+
+import "sync/atomic"
+
+// FSStats counts the operations performed through a FileSystem and the
+// files it opened.
+type FSStats struct {
+	Opens        int64 // Successful OpenFile calls
+	Stats        int64 // Stat calls
+	Removes      int64 // Remove calls
+	Reads        int64 // Read and ReadAt calls on files
+	Writes       int64 // Write and WriteAt calls on files
+	BytesRead    int64 // Bytes read from the server, including read-ahead
+	BytesWritten int64 // Bytes accepted by writes
+}
+
+// fsStats holds the live counters behind FSStats.
+type fsStats struct {
+	opens, stats, removes   atomic.Int64
+	reads, writes           atomic.Int64
+	bytesRead, bytesWritten atomic.Int64
+}
+
+// read records a read call that returned n bytes. It is a no-op on a nil
+// receiver, so files created without a FileSystem need no counters.
+func (s *fsStats) read(n int) {
+	if s == nil {
+		return
+	}
+	s.reads.Add(1)
+	s.bytesRead.Add(int64(n))
+}
+
+// wrote records a write call that accepted n bytes. It is a no-op on a nil
+// receiver.
+func (s *fsStats) wrote(n int) {
+	if s == nil {
+		return
+	}
+	s.writes.Add(1)
+	s.bytesWritten.Add(int64(n))
+}
+
+// snapshot returns the current counters, zeroing each one as it is read if
+// reset is set.
+func (s *fsStats) snapshot(reset bool) FSStats {
+	load := func(v *atomic.Int64) int64 {
+		if reset {
+			return v.Swap(0)
+		}
+		return v.Load()
+	}
+	return FSStats{
+		Opens:        load(&s.opens),
+		Stats:        load(&s.stats),
+		Removes:      load(&s.removes),
+		Reads:        load(&s.reads),
+		Writes:       load(&s.writes),
+		BytesRead:    load(&s.bytesRead),
+		BytesWritten: load(&s.bytesWritten),
+	}
+}
+
+// Stats returns the operation counters accumulated since the FileSystem was
+// created or ResetStats was last called.
+func (fs *FileSystem) Stats() FSStats {
+	return fs.stats.snapshot(false)
+}
+
+// ResetStats returns the accumulated counters and zeroes them, for
+// computing per-interval throughput. Each counter is swapped atomically, so
+// no operation is lost or counted in two intervals.
+func (fs *FileSystem) ResetStats() FSStats {
+	return fs.stats.snapshot(true)
+}
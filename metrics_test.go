@@ -0,0 +1,70 @@
+package sftpfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+)
+
+func TestStatsAndReset(t *testing.T) {
+	mockClient := newMockSFTPClient()
+	mockClient.files["/in.txt"] = &mocks.MockSFTPFile{Data: []byte("hello world")}
+	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
+
+	in, err := fs.OpenFile("/in.txt", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	buf := make([]byte, 5)
+	in.Read(buf)
+	in.ReadAt(buf, 6)
+	in.Close()
+
+	out, err := fs.OpenFile("/out.txt", os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	out.Write([]byte("abc"))
+	out.WriteAt([]byte("defg"), 3)
+	out.Close()
+
+	fs.Stat("/in.txt")
+	fs.Remove("/out.txt")
+
+	want := FSStats{
+		Opens:        2,
+		Stats:        1,
+		Removes:      1,
+		Reads:        2,
+		Writes:       2,
+		BytesRead:    10,
+		BytesWritten: 7,
+	}
+	if got := fs.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+	if got := fs.ResetStats(); got != want {
+		t.Errorf("ResetStats() = %+v, want %+v", got, want)
+	}
+	if got := fs.Stats(); got != (FSStats{}) {
+		t.Errorf("Stats after reset = %+v, want zero", got)
+	}
+
+	fs.Stat("/in.txt")
+	if got := fs.ResetStats(); got != (FSStats{Stats: 1}) {
+		t.Errorf("Second interval = %+v, want one stat", got)
+	}
+}
+
+func TestStatsNilForStandaloneFile(t *testing.T) {
+	file := &File{file: &mocks.MockSFTPFile{Data: []byte("data")}, name: "/test.txt"}
+
+	// Files built without a FileSystem have no counters and must not panic
+	if _, err := file.Read(make([]byte, 4)); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if _, err := file.Write([]byte("x")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+package sftpfs
+
+import "os"
+
+// Permission bits for common kinds of files, for use as the perm argument
+// of OpenFile, WriteFile, Mkdir and MkdirAll. Config.Umask still applies.
+const (
+	ModeFile       os.FileMode = 0644 // readable by all, writable by the owner
+	ModeDir        os.FileMode = 0755 // listable by all, writable by the owner
+	ModePrivate    os.FileMode = 0600 // readable and writable by the owner only
+	ModePrivateDir os.FileMode = 0700 // accessible by the owner only
+)
+
+// MkdirPrivate creates the named directory with mode ModePrivateDir, for
+// keys, credentials and other data no other user may list or enter.
+func (fs *FileSystem) MkdirPrivate(name string) error {
+	return fs.Mkdir(name, ModePrivateDir)
+}
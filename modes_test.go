@@ -0,0 +1,53 @@
+package sftpfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+)
+
+// modeClient records the modes set with Chmod by path.
+type modeClient struct {
+	*mockSFTPClient
+	modes map[string]os.FileMode
+}
+
+func (c *modeClient) Chmod(path string, mode os.FileMode) error {
+	if err := c.mockSFTPClient.Chmod(path, mode); err != nil {
+		return err
+	}
+	c.modes[path] = mode
+	return nil
+}
+
+func TestModeHelpers(t *testing.T) {
+	client := &modeClient{mockSFTPClient: newMockSFTPClient(), modes: make(map[string]os.FileMode)}
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+
+	if err := fs.MkdirPrivate("/keys"); err != nil {
+		t.Fatalf("MkdirPrivate failed: %v", err)
+	}
+	if err := fs.Mkdir("/public", ModeDir); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if got := client.modes["/keys"]; got != 0700 {
+		t.Errorf("Mode of /keys = %v, want 0700", got)
+	}
+	// The mock creates directories 0755 already, so no Chmod is needed
+	if got, ok := client.modes["/public"]; ok {
+		t.Errorf("Mkdir(/public, ModeDir) sent Chmod %v", got)
+	}
+
+	for name, perm := range map[string]os.FileMode{"/keys/id": ModePrivate, "/public/index.html": ModeFile} {
+		if err := fs.WriteFile(name, []byte("x"), perm); err != nil {
+			t.Fatalf("WriteFile %s failed: %v", name, err)
+		}
+	}
+	if got := client.files["/keys/id"].Perm; got != 0600 {
+		t.Errorf("Mode of /keys/id = %v, want 0600", got)
+	}
+	if got := client.files["/public/index.html"].Perm; got != 0644 {
+		t.Errorf("Mode of /public/index.html = %v, want 0644", got)
+	}
+}
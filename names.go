@@ -0,0 +1,28 @@
+package sftpfs
+
+import (
+	"os"
+	"unicode/utf8"
+)
+
+// validName reports whether name is valid UTF-8 without control characters.
+func validName(name string) bool {
+	if !utf8.ValidString(name) {
+		return false
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// checkName returns an *os.PathError wrapping os.ErrInvalid if
+// Config.ValidateNames is set and name is not a valid name.
+func (fs *FileSystem) checkName(op, name string) error {
+	if fs.config.ValidateNames && !validName(name) {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrInvalid}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package sftpfs
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+)
+
+func TestValidateNames(t *testing.T) {
+	mockClient := newMockSFTPClient()
+	mockClient.files["/héllo wörld.txt"] = &mocks.MockSFTPFile{Data: []byte("ok")}
+	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
+	fs.config.ValidateNames = true
+
+	if _, err := fs.Stat("/héllo wörld.txt"); err != nil {
+		t.Errorf("Valid name rejected: %v", err)
+	}
+
+	for _, name := range []string{"/bad\xff.txt", "/two\nlines.txt", "/tab\there", "/del\x7f"} {
+		_, err := fs.Stat(name)
+		if !errors.Is(err, os.ErrInvalid) {
+			t.Errorf("Stat(%q) = %v, want os.ErrInvalid", name, err)
+		}
+		if _, err := fs.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0644); !errors.Is(err, os.ErrInvalid) {
+			t.Errorf("OpenFile(%q) = %v, want os.ErrInvalid", name, err)
+		}
+		if err := fs.Rename("/héllo wörld.txt", name); !errors.Is(err, os.ErrInvalid) {
+			t.Errorf("Rename to %q = %v, want os.ErrInvalid", name, err)
+		}
+		if _, ok := mockClient.files[name]; ok {
+			t.Errorf("Invalid name %q reached the server", name)
+		}
+	}
+}
+
+func TestValidateNamesDisabled(t *testing.T) {
+	mockClient := newMockSFTPClient()
+	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
+
+	f, err := fs.OpenFile("/two\nlines.txt", os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error without ValidateNames: %v", err)
+	}
+	f.Close()
+}
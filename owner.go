@@ -0,0 +1,58 @@
+package sftpfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// OwnerResolver maps user and group names to numeric ids on the server.
+type OwnerResolver interface {
+	LookupUser(name string) (uid int, err error)
+	LookupGroup(name string) (gid int, err error)
+}
+
+// OwnerMap is an OwnerResolver backed by fixed name to id tables, for
+// callers that know the server's accounts in advance.
+type OwnerMap struct {
+	Users  map[string]int
+	Groups map[string]int
+}
+
+// LookupUser returns the uid of the user name.
+func (m OwnerMap) LookupUser(name string) (int, error) {
+	if uid, ok := m.Users[name]; ok {
+		return uid, nil
+	}
+	return 0, fmt.Errorf("sftpfs: unknown user %q", name)
+}
+
+// LookupGroup returns the gid of the group name.
+func (m OwnerMap) LookupGroup(name string) (int, error) {
+	if gid, ok := m.Groups[name]; ok {
+		return gid, nil
+	}
+	return 0, fmt.Errorf("sftpfs: unknown group %q", name)
+}
+
+// errNoOwnerResolver is returned by ChownByName without Config.OwnerResolver.
+var errNoOwnerResolver = errors.New("sftpfs: ChownByName needs Config.OwnerResolver")
+
+// ChownByName changes the owner and group of the named file to user and
+// group, resolved to numeric ids with Config.OwnerResolver. Resolution
+// errors are returned in an *os.PathError without contacting the server.
+func (fs *FileSystem) ChownByName(name, user, group string) error {
+	resolver := fs.config.OwnerResolver
+	if resolver == nil {
+		return &os.PathError{Op: "chown", Path: name, Err: errNoOwnerResolver}
+	}
+	uid, err := resolver.LookupUser(user)
+	if err != nil {
+		return &os.PathError{Op: "chown", Path: name, Err: err}
+	}
+	gid, err := resolver.LookupGroup(group)
+	if err != nil {
+		return &os.PathError{Op: "chown", Path: name, Err: err}
+	}
+	return fs.Chown(name, uid, gid)
+}
@@ -0,0 +1,56 @@
+package sftpfs
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+)
+
+// chownClient records the ids passed to Chown.
+type chownClient struct {
+	*mockSFTPClient
+	uid, gid int
+}
+
+func (c *chownClient) Chown(path string, uid, gid int) error {
+	if err := c.mockSFTPClient.Chown(path, uid, gid); err != nil {
+		return err
+	}
+	c.uid, c.gid = uid, gid
+	return nil
+}
+
+func TestChownByName(t *testing.T) {
+	client := &chownClient{mockSFTPClient: newMockSFTPClient(), uid: -1, gid: -1}
+	client.files["/test.txt"] = &mocks.MockSFTPFile{}
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+
+	if err := fs.ChownByName("/test.txt", "alice", "staff"); !errors.Is(err, errNoOwnerResolver) {
+		t.Errorf("ChownByName without a resolver: %v, want errNoOwnerResolver", err)
+	}
+
+	fs.config.OwnerResolver = OwnerMap{
+		Users:  map[string]int{"alice": 1001},
+		Groups: map[string]int{"staff": 50},
+	}
+	if err := fs.ChownByName("/test.txt", "alice", "staff"); err != nil {
+		t.Fatalf("ChownByName failed: %v", err)
+	}
+	if client.uid != 1001 || client.gid != 50 {
+		t.Errorf("Chown got uid %d gid %d, want 1001 50", client.uid, client.gid)
+	}
+
+	client.uid, client.gid = -1, -1
+	for _, names := range [][2]string{{"bob", "staff"}, {"alice", "wheel"}} {
+		err := fs.ChownByName("/test.txt", names[0], names[1])
+		var pathErr *os.PathError
+		if !errors.As(err, &pathErr) {
+			t.Errorf("ChownByName(%q, %q) = %v, want *os.PathError", names[0], names[1], err)
+		}
+	}
+	if client.uid != -1 || client.gid != -1 {
+		t.Error("Chown was called for an unresolved name")
+	}
+}
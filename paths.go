@@ -0,0 +1,37 @@
+package sftpfs
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrPathEscapes is returned by JoinPath when the joined path lies outside
+// the base directory.
+var ErrPathEscapes = errors.New("path escapes base directory")
+
+// JoinPath joins elem onto base with path.Join and cleans the result,
+// returning an *os.PathError wrapping ErrPathEscapes if ".." elements climb
+// above base. Absolute elements are treated as relative to base, as path.Join
+// does, so JoinPath("/data", "/etc/passwd") is "/data/etc/passwd". Use it
+// for remote paths built from untrusted input.
+func JoinPath(base string, elem ...string) (string, error) {
+	root := path.Clean(base)
+	joined := path.Join(append([]string{root}, elem...)...)
+
+	within := pathWithin(root, joined)
+	if root == "." {
+		within = joined != ".." && !strings.HasPrefix(joined, "../")
+	}
+	if !within {
+		return "", &os.PathError{Op: "join", Path: joined, Err: ErrPathEscapes}
+	}
+	return joined, nil
+}
+
+// JoinPath joins and validates remote path elements; see the package-level
+// JoinPath.
+func (fs *FileSystem) JoinPath(base string, elem ...string) (string, error) {
+	return JoinPath(base, elem...)
+}
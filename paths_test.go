@@ -0,0 +1,50 @@
+package sftpfs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestJoinPath(t *testing.T) {
+	tests := []struct {
+		base string
+		elem []string
+		want string
+	}{
+		{"/data", []string{"a", "b.txt"}, "/data/a/b.txt"},
+		{"/data/", []string{"./a//b/"}, "/data/a/b"},
+		{"/data", []string{"a", "..", "b"}, "/data/b"},
+		{"/data", []string{".."}, ""},
+		{"/data", []string{"a", "../../etc/passwd"}, ""},
+		{"/data", []string{"/etc/passwd"}, "/data/etc/passwd"},
+		{"/data", []string{"/../etc"}, ""},
+		{"/data", nil, "/data"},
+		{"/", []string{"../../x"}, "/x"},
+		{"rel", []string{"a"}, "rel/a"},
+		{"rel", []string{"../other"}, ""},
+		{".", []string{"a/../b"}, "b"},
+		{".", []string{".."}, ""},
+	}
+	for _, tt := range tests {
+		got, err := JoinPath(tt.base, tt.elem...)
+		if tt.want == "" {
+			if !errors.Is(err, ErrPathEscapes) {
+				t.Errorf("JoinPath(%q, %q) = (%q, %v), want ErrPathEscapes", tt.base, tt.elem, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("JoinPath(%q, %q) = (%q, %v), want %q", tt.base, tt.elem, got, err, tt.want)
+		}
+	}
+}
+
+func TestFileSystemJoinPath(t *testing.T) {
+	fs := newWithClients(newMockSFTPClient(), nil)
+	if got, err := fs.JoinPath("/home/user", "docs", "a.txt"); err != nil || got != "/home/user/docs/a.txt" {
+		t.Errorf("JoinPath = (%q, %v)", got, err)
+	}
+	if _, err := fs.JoinPath("/home/user", "../other"); !errors.Is(err, ErrPathEscapes) {
+		t.Errorf("Expected ErrPathEscapes, got %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package sftpfs
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is returned by Pool.Get after the pool has been closed.
+var ErrPoolClosed = errors.New("sftpfs: pool closed")
+
+// PoolConfig configures a Pool.
+type PoolConfig struct {
+	// Config holds the settings used to dial each connection.
+	Config Config
+
+	// MaxIdle is the largest number of idle connections kept for reuse.
+	// Connections returned beyond it are closed. If 0, defaults to 2.
+	MaxIdle int
+
+	// MaxIdleTime closes connections that have been idle longer than this;
+	// the next Get dials a fresh one instead. If 0, idle connections are
+	// kept indefinitely.
+	MaxIdleTime time.Duration
+
+	// HealthCheckInterval, if positive, is how often idle connections are
+	// pinged. Connections that fail the ping are closed and evicted, so a
+	// connection dropped by the network or a NAT timeout is not handed out.
+	HealthCheckInterval time.Duration
+}
+
+// Pool keeps a set of idle connections to one server for reuse, so
+// callers doing many short operations do not pay for a handshake each time.
+// It is safe for concurrent use.
+type Pool struct {
+	config PoolConfig
+	dial   func() (*FileSystem, error)
+
+	mu     sync.Mutex
+	idle   []pooledConn
+	closed bool
+	done   chan struct{}
+}
+
+// pooledConn is an idle connection and the time it was returned.
+type pooledConn struct {
+	fs    *FileSystem
+	since time.Time
+}
+
+// NewPool creates a pool of connections made with config.Config. No
+// connection is dialed until the first Get.
+func NewPool(config *PoolConfig) *Pool {
+	p := &Pool{config: *config, done: make(chan struct{})}
+	if p.config.MaxIdle <= 0 {
+		p.config.MaxIdle = 2
+	}
+	p.dial = func() (*FileSystem, error) {
+		c := p.config.Config
+		return New(&c)
+	}
+	if p.config.HealthCheckInterval > 0 {
+		go p.healthLoop(p.config.HealthCheckInterval)
+	}
+	return p
+}
+
+// Get returns an idle connection, or dials a new one if none is available.
+// Idle connections past MaxIdleTime are closed rather than returned. The
+// caller must hand the connection back with Put, or Close it.
+func (p *Pool) Get() (*FileSystem, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			return p.dial()
+		}
+		pc := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if p.expired(pc, time.Now()) {
+			pc.fs.Close()
+			continue
+		}
+		return pc.fs, nil
+	}
+}
+
+// Put returns fs to the pool for reuse. If the pool is closed or already
+// holds MaxIdle idle connections, fs is closed instead.
+func (p *Pool) Put(fs *FileSystem) {
+	p.mu.Lock()
+	if p.closed || len(p.idle) >= p.config.MaxIdle || fs.shared().closed.Load() {
+		p.mu.Unlock()
+		fs.Close()
+		return
+	}
+	p.idle = append(p.idle, pooledConn{fs: fs, since: time.Now()})
+	p.mu.Unlock()
+}
+
+// Close closes all idle connections and stops health checks. Connections
+// handed out by Get are closed when they are Put back.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	close(p.done)
+	p.mu.Unlock()
+
+	var firstErr error
+	for _, pc := range idle {
+		if err := pc.fs.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// expired reports whether pc has been idle longer than MaxIdleTime.
+func (p *Pool) expired(pc pooledConn, now time.Time) bool {
+	return p.config.MaxIdleTime > 0 && now.Sub(pc.since) > p.config.MaxIdleTime
+}
+
+// healthLoop runs checkIdle every interval until the pool is closed.
+func (p *Pool) healthLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.checkIdle()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// checkIdle pings every idle connection, closing those that fail or have
+// expired.
+func (p *Pool) checkIdle() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	now := time.Now()
+	var healthy []pooledConn
+	for _, pc := range idle {
+		if p.expired(pc, now) || pc.fs.Ping() != nil {
+			pc.fs.Close()
+			continue
+		}
+		healthy = append(healthy, pc)
+	}
+
+	p.mu.Lock()
+	var extra []pooledConn
+	if p.closed {
+		extra = healthy
+	} else {
+		// Connections Put back during the check are kept after the
+		// checked ones, trimmed to MaxIdle
+		p.idle = append(healthy, p.idle...)
+		if n := len(p.idle) - p.config.MaxIdle; n > 0 {
+			extra = p.idle[:n]
+			p.idle = p.idle[n:]
+		}
+	}
+	p.mu.Unlock()
+
+	for _, pc := range extra {
+		pc.fs.Close()
+	}
+}
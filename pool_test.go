@@ -0,0 +1,127 @@
+package sftpfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+)
+
+// newTestPool returns a pool whose connections are backed by mock clients,
+// and a pointer to the slice of clients it has dialed.
+func newTestPool(config *PoolConfig) (*Pool, *[]*mockSFTPClient) {
+	p := NewPool(config)
+	var dialed []*mockSFTPClient
+	p.dial = func() (*FileSystem, error) {
+		client := newMockSFTPClient()
+		client.fileInfos["."] = &mocks.MockFileInfo{FileName: ".", FileIsDir: true}
+		dialed = append(dialed, client)
+		return newWithClients(client, &mocks.MockSSHClient{}), nil
+	}
+	return p, &dialed
+}
+
+func TestPoolReuse(t *testing.T) {
+	p, dialed := newTestPool(&PoolConfig{})
+	defer p.Close()
+
+	fs, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	p.Put(fs)
+	again, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if again != fs || len(*dialed) != 1 {
+		t.Errorf("Expected the idle connection to be reused, dialed %d", len(*dialed))
+	}
+}
+
+func TestPoolEvictsUnhealthy(t *testing.T) {
+	p, dialed := newTestPool(&PoolConfig{})
+	defer p.Close()
+
+	fs, _ := p.Get()
+	p.Put(fs)
+
+	// The connection drops while idle
+	(*dialed)[0].statErr = errors.New("connection lost")
+	p.checkIdle()
+	if !(*dialed)[0].closed {
+		t.Error("Expected the unhealthy connection to be closed")
+	}
+
+	fresh, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if fresh == fs || len(*dialed) != 2 {
+		t.Errorf("Expected a fresh connection after eviction, dialed %d", len(*dialed))
+	}
+}
+
+func TestPoolHealthCheckInterval(t *testing.T) {
+	p, dialed := newTestPool(&PoolConfig{HealthCheckInterval: 10 * time.Millisecond})
+	defer p.Close()
+
+	fs, _ := p.Get()
+	client := (*dialed)[0]
+	client.statErr = errors.New("connection lost")
+	p.Put(fs)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		p.mu.Lock()
+		n := len(p.idle)
+		p.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Unhealthy connection was not evicted by the health check")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPoolMaxIdleTime(t *testing.T) {
+	p, dialed := newTestPool(&PoolConfig{MaxIdleTime: 20 * time.Millisecond})
+	defer p.Close()
+
+	fs, _ := p.Get()
+	p.Put(fs)
+	time.Sleep(40 * time.Millisecond)
+
+	fresh, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if fresh == fs || !(*dialed)[0].closed {
+		t.Error("Expected the expired connection to be closed and replaced")
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	p, dialed := newTestPool(&PoolConfig{MaxIdle: 1})
+
+	a, _ := p.Get()
+	b, _ := p.Get()
+	p.Put(a)
+	p.Put(b) // over MaxIdle
+	if !(*dialed)[1].closed {
+		t.Error("Expected connection beyond MaxIdle to be closed")
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if !(*dialed)[0].closed {
+		t.Error("Expected idle connection to be closed by Close")
+	}
+	if _, err := p.Get(); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Expected ErrPoolClosed, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package sftpfs
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// fileReader reads from a File's handle, below any read-ahead buffer, and
+// counts the bytes fetched.
+type fileReader struct {
+	f *File
+}
+
+func (r fileReader) Read(b []byte) (int, error) {
+	n, err := r.f.file.Read(b)
+	r.f.stats.read(n)
+	return n, err
+}
+
+// WriteTo lets bufio.Reader.WriteTo hand the rest of the file to the
+// handle's concurrent reads once its buffer is drained. Without them it
+// reads in requests the size of the read-ahead buffer.
+func (r fileReader) WriteTo(w io.Writer) (int64, error) {
+	if wt, ok := r.f.file.(io.WriterTo); ok {
+		n, err := wt.WriteTo(w)
+		r.f.stats.read(int(n))
+		return n, err
+	}
+	return io.CopyBuffer(struct{ io.Writer }{w}, struct{ io.Reader }{r}, make([]byte, r.f.rbuf.Size()))
+}
+
+// OpenSequential opens the named file for reading from start to end. Reads
+// are served from a read-ahead buffer of Config.TransferChunkSize bytes,
+// refilled with one large request that github.com/pkg/sftp splits into
+// concurrent packets, and io.Copy from the file fetches the rest with
+// concurrent reads. Seeking discards the buffer, so for scattered reads use
+// OpenRandom instead.
+func (fs *FileSystem) OpenSequential(name string) (*File, error) {
+	f, err := fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	file := f.(*File)
+	file.rbuf = bufio.NewReaderSize(fileReader{file}, fs.transferChunkSize())
+	return file, nil
+}
+
+// OpenRandom opens the named file for reading at scattered offsets. Each
+// Read and ReadAt fetches only the bytes asked for, and io.Copy reads in
+// 32KB requests instead of racing ahead of the caller, so no bandwidth is
+// spent on data that is never used. The concurrent-reads setting of
+// github.com/pkg/sftp applies to the whole client, so this is done by not
+// using it rather than by reconfiguring the connection.
+func (fs *FileSystem) OpenRandom(name string) (*File, error) {
+	f, err := fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	file := f.(*File)
+	file.noReadAhead = true
+	return file, nil
+}
+
+// discardReadAhead drops any read-ahead buffered by OpenSequential before
+// the handle's offset is used or changed directly, and returns how many
+// bytes were dropped.
+func (f *File) discardReadAhead() int64 {
+	if f.rbuf == nil {
+		return 0
+	}
+	n := f.rbuf.Buffered()
+	f.rbuf.Reset(fileReader{f})
+	return int64(n)
+}
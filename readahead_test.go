@@ -0,0 +1,122 @@
+package sftpfs
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+)
+
+// readCountingFile counts the reads that reach the handle and whether
+// WriteTo was used.
+type readCountingFile struct {
+	*mocks.MockSFTPFile
+	reads   int
+	wroteTo bool
+}
+
+func (f *readCountingFile) Read(b []byte) (int, error) {
+	f.reads++
+	return f.MockSFTPFile.Read(b)
+}
+
+func (f *readCountingFile) WriteTo(w io.Writer) (int64, error) {
+	f.wroteTo = true
+	return io.Copy(w, struct{ io.Reader }{f.MockSFTPFile})
+}
+
+type readCountingClient struct {
+	*mockSFTPClient
+	last *readCountingFile
+}
+
+func (c *readCountingClient) OpenFile(path string, flag int) (sftpFileInterface, error) {
+	file, err := c.mockSFTPClient.OpenFile(path, flag)
+	if err != nil {
+		return nil, err
+	}
+	c.last = &readCountingFile{MockSFTPFile: file.(*mocks.MockSFTPFile)}
+	return c.last, nil
+}
+
+func testReadData() []byte {
+	data := make([]byte, 3*MinTransferChunkSize+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func TestOpenSequential(t *testing.T) {
+	data := testReadData()
+	client := &readCountingClient{mockSFTPClient: newMockSFTPClient()}
+	client.files["/seq.bin"] = &mocks.MockSFTPFile{Data: data}
+	fs := &FileSystem{client: client, config: Config{TransferChunkSize: MinTransferChunkSize}}
+
+	f, err := fs.OpenSequential("/seq.bin")
+	if err != nil {
+		t.Fatalf("OpenSequential failed: %v", err)
+	}
+	defer f.Close()
+
+	var got bytes.Buffer
+	buf := make([]byte, 100)
+	for got.Len() < 1000 {
+		n, err := f.Read(buf)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		got.Write(buf[:n])
+	}
+	if client.last.reads != 1 {
+		t.Errorf("Ten small reads made %d requests, want 1", client.last.reads)
+	}
+	if pos, err := f.Seek(0, io.SeekCurrent); err != nil || pos != 1000 {
+		t.Errorf("Seek(0, SeekCurrent) = %d, %v; want 1000", pos, err)
+	}
+
+	// The rest, including what was buffered, comes through io.Copy
+	got.Reset()
+	f.Seek(500, io.SeekStart)
+	if _, err := io.Copy(&got, f); err != nil {
+		t.Fatalf("io.Copy failed: %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), data[500:]) {
+		t.Errorf("io.Copy after Seek returned %d bytes, want %d matching", got.Len(), len(data)-500)
+	}
+	if !client.last.wroteTo {
+		t.Error("io.Copy did not use the handle's WriteTo")
+	}
+}
+
+func TestOpenRandom(t *testing.T) {
+	data := testReadData()
+	client := &readCountingClient{mockSFTPClient: newMockSFTPClient()}
+	client.files["/rand.bin"] = &mocks.MockSFTPFile{Data: data}
+	fs := &FileSystem{client: client}
+
+	f, err := fs.OpenRandom("/rand.bin")
+	if err != nil {
+		t.Fatalf("OpenRandom failed: %v", err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, 100)
+	f.Seek(4000, io.SeekStart)
+	if n, err := f.Read(buf); err != nil || !bytes.Equal(buf[:n], data[4000:4100]) {
+		t.Fatalf("Read at 4000 = %d, %v", n, err)
+	}
+	if fetched := fs.Stats().BytesRead; fetched != 100 {
+		t.Errorf("Fetched %d bytes for a 100-byte read", fetched)
+	}
+
+	var got bytes.Buffer
+	f.Seek(0, io.SeekStart)
+	if _, err := io.Copy(&got, f); err != nil || !bytes.Equal(got.Bytes(), data) {
+		t.Fatalf("io.Copy = %d bytes, %v", got.Len(), err)
+	}
+	if client.last.wroteTo {
+		t.Error("io.Copy from a random-access file used the handle's read-ahead")
+	}
+}
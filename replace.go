@@ -0,0 +1,132 @@
+package sftpfs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// replaceLockTimeout is how long ReplaceContent waits for another caller's
+// lock on the file.
+var replaceLockTimeout = 30 * time.Second
+
+// ReplaceContent rewrites the named file with the result of transform
+// applied to its current content. The result is written to a hidden
+// temporary file in the same directory, given the original's permission
+// bits, and renamed over name, so readers see either the old or the new
+// content in full. Servers that cannot rename over an existing file have
+// it removed first, briefly leaving nothing at name; should the rename then
+// fail, the new content is left in the temporary file, which the error
+// names. If transform fails, the file is left untouched and its error is
+// returned.
+//
+// The file's advisory lock (see File.Lock) is held from the read to the
+// rename, so concurrent ReplaceContent calls, from this or other clients,
+// apply their transforms one after another instead of losing updates. A
+// caller waits up to 30 seconds for the lock before failing with ErrLocked.
+func (fs *FileSystem) ReplaceContent(name string, transform func(old []byte) ([]byte, error)) error {
+	name = fs.abs(name)
+	af, err := fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	f := af.(*File)
+	err = fs.replaceLocked(f, transform)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// replaceLocked does the work of ReplaceContent on the open file f, which
+// the caller closes, releasing the lock.
+func (fs *FileSystem) replaceLocked(f *File, transform func(old []byte) ([]byte, error)) error {
+	if err := f.LockTimeout(replaceLockTimeout); err != nil {
+		return err
+	}
+	// Read by path: until the lock was taken, another caller may have
+	// renamed a new file over the one f has open
+	info, err := fs.Stat(f.name)
+	if err != nil {
+		return err
+	}
+	old, err := fs.ReadFile(f.name)
+	if err != nil {
+		return err
+	}
+	data, err := transform(old)
+	if err != nil {
+		return err
+	}
+
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return err
+	}
+	dir, base := path.Split(f.name)
+	tmp := path.Join(dir, "."+base+"."+hex.EncodeToString(suffix[:])+".tmp")
+	if err := fs.writeReplacement(tmp, data, info.Mode().Perm()); err != nil {
+		fs.client.Remove(tmp)
+		return err
+	}
+	// A plain SFTP rename may refuse to replace name; posix-rename does not
+	err = fs.PosixRename(tmp, f.name)
+	if errors.Is(err, ErrUnsupported) {
+		err = fs.client.Rename(tmp, f.name)
+	}
+	if renameRefused(err) {
+		return fs.replaceByRemoval(tmp, f.name)
+	}
+	if err != nil {
+		fs.client.Remove(tmp)
+	}
+	return err
+}
+
+// replaceByRemoval moves tmp over name for servers that cannot rename over
+// an existing file, removing name first, as the server's AtomicUploads do.
+// Once name is gone tmp holds the only copy of the content, so it is kept
+// if the rename fails.
+func (fs *FileSystem) replaceByRemoval(tmp, name string) error {
+	if err := fs.client.Remove(name); err != nil {
+		fs.client.Remove(tmp)
+		return err
+	}
+	if err := fs.client.Rename(tmp, name); err != nil {
+		return &os.PathError{Op: "replace", Path: name, Err: fmt.Errorf("removed, new content left in %s: %w", tmp, err)}
+	}
+	return nil
+}
+
+// renameRefused reports whether err, from a rename, is how servers refuse
+// to replace an existing file. SFTPv3 has no status code for it, so servers
+// send a generic failure; other errors, such as permission denied, rule it
+// out.
+func renameRefused(err error) bool {
+	var status *sftp.StatusError
+	return errors.Is(err, os.ErrExist) ||
+		errors.As(err, &status) && status.FxCode() == sftp.ErrSSHFxFailure
+}
+
+// writeReplacement creates tmp with data and mode perm, unaffected by
+// Config.Umask.
+func (fs *FileSystem) writeReplacement(tmp string, data []byte, perm os.FileMode) error {
+	f, err := fs.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return fs.client.Chmod(tmp, perm)
+}
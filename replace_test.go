@@ -0,0 +1,216 @@
+package sftpfs
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+// replaceTestFS serves mfs and returns a client FileSystem for it.
+func replaceTestFS(t *testing.T, mfs *memfs.FileSystem) *FileSystem {
+	t.Helper()
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	t.Cleanup(func() { listener.Close() })
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+// dirNames lists the names in dir of the backing filesystem.
+func dirNames(t *testing.T, mfs *memfs.FileSystem, dir string) []string {
+	t.Helper()
+	d, err := mfs.Open(dir)
+	if err != nil {
+		t.Fatalf("Open %s failed: %v", dir, err)
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		t.Fatalf("Readdirnames failed: %v", err)
+	}
+	return names
+}
+
+func TestReplaceContent(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/etc", 0755)
+	fs := replaceTestFS(t, mfs)
+	if err := fs.WriteFile("/etc/app.conf", []byte("version=1\n"), 0640); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	err = fs.ReplaceContent("/etc/app.conf", func(old []byte) ([]byte, error) {
+		return bytes.Replace(old, []byte("version=1"), []byte("version=2"), 1), nil
+	})
+	if err != nil {
+		t.Fatalf("ReplaceContent failed: %v", err)
+	}
+	data, err := fs.ReadFile("/etc/app.conf")
+	if err != nil || string(data) != "version=2\n" {
+		t.Errorf("Content = %q, %v; want version=2", data, err)
+	}
+	info, err := fs.Stat("/etc/app.conf")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("Mode = %v, want 0640", info.Mode().Perm())
+	}
+	if names := dirNames(t, mfs, "/etc"); len(names) != 1 {
+		t.Errorf("Leftover files after ReplaceContent: %v", names)
+	}
+}
+
+func TestReplaceContentTransformError(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/etc", 0755)
+	fs := replaceTestFS(t, mfs)
+	if err := fs.WriteFile("/etc/app.conf", []byte("version=1\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	errBadConfig := errors.New("bad config")
+	err = fs.ReplaceContent("/etc/app.conf", func(old []byte) ([]byte, error) {
+		return []byte("garbage"), errBadConfig
+	})
+	if !errors.Is(err, errBadConfig) {
+		t.Fatalf("Expected the transform's error, got %v", err)
+	}
+	data, err := fs.ReadFile("/etc/app.conf")
+	if err != nil || string(data) != "version=1\n" {
+		t.Errorf("Content after a failed transform = %q, %v; want the original", data, err)
+	}
+	if names := dirNames(t, mfs, "/etc"); len(names) != 1 {
+		t.Errorf("Leftover files after a failed transform: %v", names)
+	}
+
+	if err := fs.ReplaceContent("/etc/missing.conf", func(old []byte) ([]byte, error) { return old, nil }); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReplaceContent of a missing file: expected ErrNotExist, got %v", err)
+	}
+}
+
+// renameFailFS is a backing filesystem whose Rename refuses to replace an
+// existing file if noReplace is set, and otherwise fails with fail if set.
+type renameFailFS struct {
+	*memfs.FileSystem
+	noReplace bool
+	fail      error
+}
+
+func (fs *renameFailFS) Rename(oldname, newname string) error {
+	if _, err := fs.Stat(newname); err == nil && fs.noReplace {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+	if fs.fail != nil {
+		return fs.fail
+	}
+	return fs.FileSystem.Rename(oldname, newname)
+}
+
+func TestReplaceContentRenameFails(t *testing.T) {
+	upgrade := func(old []byte) ([]byte, error) { return []byte("version=2\n"), nil }
+	setup := func(t *testing.T, rfs *renameFailFS) *FileSystem {
+		t.Helper()
+		mfs, err := memfs.NewFS()
+		if err != nil {
+			t.Fatalf("Failed to create memfs: %v", err)
+		}
+		mfs.Mkdir("/etc", 0755)
+		rfs.FileSystem = mfs
+		_, listener := testServerListen(t, rfs, &ServerConfig{})
+		t.Cleanup(func() { listener.Close() })
+		fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		t.Cleanup(func() { fs.Close() })
+		if err := fs.WriteFile("/etc/app.conf", []byte("version=1\n"), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		return fs
+	}
+
+	t.Run("denied", func(t *testing.T) {
+		// The original is kept when the rename fails for another reason
+		rfs := &renameFailFS{fail: syscall.EACCES}
+		fs := setup(t, rfs)
+		if err := fs.ReplaceContent("/etc/app.conf", upgrade); !errors.Is(err, os.ErrPermission) {
+			t.Fatalf("Expected ErrPermission, got %v", err)
+		}
+		if data, err := fs.ReadFile("/etc/app.conf"); err != nil || string(data) != "version=1\n" {
+			t.Errorf("Content = %q, %v; want the original", data, err)
+		}
+		if names := dirNames(t, rfs.FileSystem, "/etc"); len(names) != 1 {
+			t.Errorf("Leftover files after a failed rename: %v", names)
+		}
+	})
+
+	t.Run("final rename", func(t *testing.T) {
+		// Once the original is removed the new content survives in the
+		// temporary file
+		rfs := &renameFailFS{noReplace: true, fail: errors.New("device busy")}
+		fs := setup(t, rfs)
+		err := fs.ReplaceContent("/etc/app.conf", upgrade)
+		if err == nil {
+			t.Fatal("ReplaceContent succeeded although the rename failed")
+		}
+		names := dirNames(t, rfs.FileSystem, "/etc")
+		if len(names) != 1 || !strings.HasSuffix(names[0], ".tmp") {
+			t.Fatalf("Files after a failed final rename = %v, want the temporary file", names)
+		}
+		tmp := "/etc/" + names[0]
+		if !strings.Contains(err.Error(), tmp) {
+			t.Errorf("Error %q does not name %s", err, tmp)
+		}
+		if data, err := fs.ReadFile(tmp); err != nil || string(data) != "version=2\n" {
+			t.Errorf("Temporary file content = %q, %v; want version=2", data, err)
+		}
+	})
+}
+
+func TestReplaceContentConcurrent(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	fs := replaceTestFS(t, mfs)
+	if err := fs.WriteFile("/counter", []byte("0"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	const n = 5
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := fs.ReplaceContent("/counter", func(old []byte) ([]byte, error) {
+				v, err := strconv.Atoi(string(old))
+				return []byte(strconv.Itoa(v + 1)), err
+			})
+			if err != nil {
+				t.Errorf("ReplaceContent failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if data, _ := fs.ReadFile("/counter"); string(data) != strconv.Itoa(n) {
+		t.Errorf("Counter = %s after %d increments", data, n)
+	}
+}
@@ -0,0 +1,83 @@
+package sftpfs
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshDial is the function used to establish SSH connections.
+// Tests replace it to simulate network failures.
+var sshDial = ssh.Dial
+
+// DefaultRetryableError reports whether err looks like a transient network
+// failure: a timeout, a refused or reset connection, or a lost SFTP session.
+// Other network errors, such as an unreachable host or a failed DNS lookup,
+// are not retried. It is used when Config.RetryableError is nil.
+func DefaultRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, sftp.ErrSSHFxConnectionLost) ||
+		errors.Is(err, sftp.ErrSSHFxNoConnection)
+}
+
+// retryable reports whether err should be retried under config.
+func (config *Config) retryable(err error) bool {
+	if config.RetryableError != nil {
+		return config.RetryableError(err)
+	}
+	return DefaultRetryableError(err)
+}
+
+// connLost reports whether err shows that the connection it came from is
+// gone, so that repeating the request over it cannot succeed.
+func connLost(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, sftp.ErrSSHFxConnectionLost) ||
+		errors.Is(err, sftp.ErrSSHFxNoConnection)
+}
+
+// withRetry calls op until it succeeds, returns a non-retryable error, or
+// config.Retries additional attempts have been made. Every attempt reuses
+// the same connection, so errors showing that it was lost are returned at
+// once, whatever config.RetryableError says; only dialing and DownloadTo
+// reconnect.
+func (config *Config) withRetry(op func() error) error {
+	return config.retry(op, func(err error) bool {
+		return !connLost(err) && config.retryable(err)
+	})
+}
+
+// withRetryDial is withRetry for dialing, where each attempt makes a new
+// connection and so any retryable error is retried.
+func (config *Config) withRetryDial(op func() error) error {
+	return config.retry(op, config.retryable)
+}
+
+// retry calls op until it succeeds, fails with an error retryable rejects,
+// or config.Retries additional attempts have been made.
+func (config *Config) retry(op func() error, retryable func(error) bool) error {
+	err := op()
+	for attempt := 0; attempt < config.Retries && err != nil && retryable(err); attempt++ {
+		time.Sleep(config.RetryDelay)
+		err = op()
+	}
+	return err
+}
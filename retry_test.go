@@ -0,0 +1,139 @@
+package sftpfs
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+var errServerBusy = errors.New("server busy")
+
+// flakyStatClient fails Stat with err for the first failures calls.
+type flakyStatClient struct {
+	*mockSFTPClient
+	failures int
+	err      error
+	calls    int
+}
+
+func (c *flakyStatClient) Stat(path string) (os.FileInfo, error) {
+	c.calls++
+	if c.calls <= c.failures {
+		return nil, c.err
+	}
+	return c.mockSFTPClient.Stat(path)
+}
+
+func TestDefaultRetryableError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{io.EOF, true},
+		{sftp.ErrSSHFxConnectionLost, true},
+		{&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.EHOSTUNREACH}, false},
+		{os.ErrNotExist, false},
+		{os.ErrPermission, false},
+		{errServerBusy, false},
+	}
+	for _, tt := range tests {
+		if got := DefaultRetryableError(tt.err); got != tt.want {
+			t.Errorf("DefaultRetryableError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryCustomPredicate(t *testing.T) {
+	mock := newMockSFTPClient()
+	mock.dirs["/data"] = []os.FileInfo{}
+	client := &flakyStatClient{mockSFTPClient: mock, failures: 2, err: errServerBusy}
+	fs := newWithClients(client, nil)
+	fs.config.Retries = 3
+	fs.config.RetryableError = func(err error) bool { return errors.Is(err, errServerBusy) }
+
+	if _, err := fs.Stat("/data"); err != nil {
+		t.Fatalf("Stat should succeed after retries: %v", err)
+	}
+	if client.calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", client.calls)
+	}
+}
+
+func TestRetryPredicateRejects(t *testing.T) {
+	mock := newMockSFTPClient()
+	mock.dirs["/data"] = []os.FileInfo{}
+	client := &flakyStatClient{mockSFTPClient: mock, failures: 2, err: errServerBusy}
+	fs := newWithClients(client, nil)
+	fs.config.Retries = 3
+	fs.config.RetryableError = func(err error) bool { return false }
+
+	if _, err := fs.Stat("/data"); !errors.Is(err, errServerBusy) {
+		t.Fatalf("Expected %v, got %v", errServerBusy, err)
+	}
+	if client.calls != 1 {
+		t.Errorf("Expected 1 attempt, got %d", client.calls)
+	}
+}
+
+func TestRetryConnectionLost(t *testing.T) {
+	mock := newMockSFTPClient()
+	mock.dirs["/data"] = []os.FileInfo{}
+	client := &flakyStatClient{mockSFTPClient: mock, failures: 2, err: sftp.ErrSSHFxConnectionLost}
+	fs := newWithClients(client, nil)
+	fs.config.Retries = 3
+	fs.config.RetryableError = func(error) bool { return true }
+
+	// Retrying over a lost connection cannot succeed
+	if _, err := fs.Stat("/data"); !errors.Is(err, sftp.ErrSSHFxConnectionLost) {
+		t.Fatalf("Expected %v, got %v", sftp.ErrSSHFxConnectionLost, err)
+	}
+	if client.calls != 1 {
+		t.Errorf("Expected 1 attempt, got %d", client.calls)
+	}
+}
+
+func TestRetryDial(t *testing.T) {
+	orig := sshDial
+	defer func() { sshDial = orig }()
+
+	attempts := 0
+	sshDial = func(network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+		attempts++
+		return nil, errServerBusy
+	}
+
+	_, err := New(&Config{
+		Host:           "localhost:22",
+		User:           "testuser",
+		Password:       "testpass",
+		Retries:        2,
+		RetryDelay:     time.Millisecond,
+		RetryableError: func(err error) bool { return errors.Is(err, errServerBusy) },
+	})
+	if !errors.Is(err, errServerBusy) {
+		t.Fatalf("Expected %v, got %v", errServerBusy, err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 dial attempts, got %d", attempts)
+	}
+
+	attempts = 0
+	New(&Config{
+		Host:           "localhost:22",
+		Retries:        2,
+		RetryDelay:     time.Millisecond,
+		RetryableError: func(err error) bool { return false },
+	})
+	if attempts != 1 {
+		t.Errorf("Expected 1 dial attempt, got %d", attempts)
+	}
+}
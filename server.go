@@ -1,7 +1,16 @@
 package sftpfs
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/absfs/absfs"
 	"github.com/pkg/sftp"
@@ -13,9 +22,20 @@ import (
 type Server struct {
 	fs       absfs.FileSystem
 	config   *ssh.ServerConfig
-	handlers sftp.Handlers
+	settings ServerConfig
+	handler  *ServerHandler
+	auth     *authLimiter // nil unless MaxAuthAttemptsPerIP is set
+
+	mu            sync.Mutex
+	sessions      map[string]*session
+	nextSessionID uint64
+	listeners     map[net.Listener]struct{}
+	shuttingDown  bool
 }
 
+// ErrServerClosed is returned by Serve and ServeAll after Shutdown.
+var ErrServerClosed = errors.New("server closed")
+
 // ServerConfig holds configuration for the SFTP server.
 type ServerConfig struct {
 	// HostKeys are the private keys for the SSH server.
@@ -30,6 +50,23 @@ type ServerConfig struct {
 	// If nil, public key authentication is disabled.
 	PublicKeyCallback func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error)
 
+	// TrustedCA lists certificate authority keys whose user certificates
+	// are accepted for public key authentication, as with sshd's
+	// TrustedUserCAKeys. A certificate must name the connecting user among
+	// its principals and be within its validity period. Plain keys are
+	// still passed to PublicKeyCallback, if set.
+	TrustedCA []ssh.PublicKey
+
+	// MaxAuthAttemptsPerIP, if positive, bans a remote IP address after
+	// this many failed authentication attempts within AuthBanDuration.
+	// Connections from a banned address are closed before the handshake
+	// until the ban expires. A successful login clears the count.
+	MaxAuthAttemptsPerIP int
+
+	// AuthBanDuration is both the window in which failures are counted and
+	// how long a ban lasts. If 0, DefaultAuthBanDuration is used.
+	AuthBanDuration time.Duration
+
 	// NoClientAuth allows any client to connect without authentication.
 	// WARNING: Only use this for testing or trusted networks.
 	NoClientAuth bool
@@ -41,6 +78,118 @@ type ServerConfig struct {
 	// ServerVersion is the SSH server version string.
 	// If empty, defaults to "SSH-2.0-sftpfs".
 	ServerVersion string
+
+	// Root exposes only this directory of the backing filesystem, which
+	// clients see as "/". Request paths cannot climb above it, and unless
+	// RestrictToRoot names a different directory, requests through
+	// symlinks that resolve outside it are refused. If empty, the whole
+	// filesystem is served.
+	Root string
+
+	// RestrictToRoot confines requests to the given directory of the
+	// backing filesystem. Symlinks in the request path are resolved before
+	// the check, so a link pointing outside the root cannot be used to
+	// list, stat, read, write or modify anything beyond it, nor can new
+	// links be made to point outside. Lstat, Readlink, Remove and Rename
+	// act on a link itself and only resolve its parent. If empty, no
+	// containment check is performed.
+	RestrictToRoot string
+
+	// NameDecoder translates each name in an incoming request path from the
+	// client's wire encoding into the backing filesystem's encoding.
+	// If nil, names are used unchanged.
+	NameDecoder func(string) string
+
+	// NameEncoder translates names from the backing filesystem's encoding
+	// into the client's wire encoding for listings, stat results and link
+	// targets. It should be the inverse of NameDecoder.
+	NameEncoder func(string) string
+
+	// AtomicUploads makes uploads that create or truncate a file write to a
+	// hidden temporary file in the same directory, which is renamed over the
+	// target when the client closes it. Partial uploads are never visible
+	// under the target name, and are removed if a write fails.
+	AtomicUploads bool
+
+	// RenamePolicy decides what a client's rename does when the target
+	// already exists. If zero, the backing filesystem's Rename decides.
+	RenamePolicy RenamePolicy
+
+	// ReportedCapacity is returned to clients' statvfs requests. Backing
+	// filesystems such as memfs have no notion of disk space, and some
+	// clients refuse to upload when free space is reported as zero. If nil,
+	// statvfs requests are unsupported.
+	ReportedCapacity *StatVFSInfo
+
+	// StatCacheTTL, if positive, caches the backing filesystem's stat and
+	// directory listing results for this long, reducing its load when
+	// clients repeatedly stat the same paths. Changes made through the
+	// server invalidate the affected entries at once; changes made to the
+	// backing filesystem directly become visible after at most the TTL.
+	StatCacheTTL time.Duration
+
+	// PrefetchRoot lists the session's root directory into the stat cache
+	// when a session starts, so a client listing "/" right after
+	// connecting is answered without reaching the backing filesystem. It
+	// is best-effort: a failed listing is read on demand as usual. It has
+	// no effect unless StatCacheTTL is positive.
+	PrefetchRoot bool
+
+	// MaxPathDepth, if positive, rejects requests whose path has more
+	// elements than this with a failure status, guarding against abusive
+	// clients and matching the limits of the backing store.
+	MaxPathDepth int
+
+	// MaxNameLength, if positive, rejects requests with any path element
+	// longer than this many bytes with a failure status.
+	MaxNameLength int
+
+	// MaxInMemoryListing, if positive, bounds the memory used to list a
+	// directory: one with more entries than this is streamed to the
+	// client as it is read from the backing filesystem, in that
+	// filesystem's order rather than sorted by name, and is not cached.
+	MaxInMemoryListing int
+
+	// Logger receives the server's diagnostic messages.
+	// If nil, slog.Default is used.
+	Logger *slog.Logger
+
+	// SlowThreshold, if positive, logs every request, file read and file
+	// write that takes longer than this, with its method, path and
+	// duration, as a warning on Logger.
+	SlowThreshold time.Duration
+
+	// HomeDir returns the directory each authenticated user starts in. The
+	// client's "." and relative request paths resolve against it, so a
+	// fresh client's RealPath(".") reports it. It is a client-visible path:
+	// when Root is also set, it is interpreted inside Root. If nil or if it
+	// returns "", users start in "/".
+	HomeDir func(user string) string
+
+	// MaxOpenFiles, if positive, limits how many files each connection can
+	// have open at once. Further opens fail with sftp.ErrSSHFxFailure until
+	// the client closes some, so one client cannot exhaust the server's
+	// file descriptors.
+	MaxOpenFiles int
+
+	// HandshakeTimeout, if positive, limits how long a client may take to
+	// complete the SSH handshake, including authentication, after
+	// connecting. Connections that do not finish in time are closed, so
+	// idle TCP connections cannot hold server resources indefinitely.
+	HandshakeTimeout time.Duration
+
+	// ChannelHandler is called, in its own goroutine, for every channel
+	// whose type is not "session", such as "direct-tcpip" port forwarding
+	// requests. It must Accept or Reject the channel. If nil, such channels
+	// are rejected with ssh.UnknownChannelType.
+	ChannelHandler func(ssh.NewChannel)
+
+	// FilesystemForUser selects the filesystem served to each authenticated
+	// user, isolating tenants from each other. It is called once per SSH
+	// connection after authentication; if it returns an error the
+	// connection is closed. If nil, every user is served the filesystem
+	// passed to NewServer.
+	FilesystemForUser func(user string) (absfs.FileSystem, error)
 }
 
 // NewServer creates a new SFTP server for the given filesystem.
@@ -50,8 +199,7 @@ type ServerConfig struct {
 //	fs, _ := memfs.NewFS()
 //
 //	// Load or generate host key
-//	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
-//	signer, _ := ssh.NewSignerFromKey(privateKey)
+//	signer, _ := sftpfs.GenerateHostKey()
 //
 //	server := sftpfs.NewServer(fs, &sftpfs.ServerConfig{
 //	    HostKeys: []ssh.Signer{signer},
@@ -82,6 +230,15 @@ func NewServer(fs absfs.FileSystem, config *ServerConfig) *Server {
 		if config.PublicKeyCallback != nil {
 			sshConfig.PublicKeyCallback = config.PublicKeyCallback
 		}
+		if len(config.TrustedCA) > 0 {
+			sshConfig.PublicKeyCallback = CertificateAuth(config.TrustedCA, config.PublicKeyCallback)
+		}
+	}
+	var limiter *authLimiter
+	if config.MaxAuthAttemptsPerIP > 0 && !config.NoClientAuth {
+		limiter = newAuthLimiter(config.MaxAuthAttemptsPerIP, config.AuthBanDuration)
+		sshConfig.PasswordCallback = limitAuth(limiter, sshConfig.PasswordCallback)
+		sshConfig.PublicKeyCallback = limitAuth(limiter, sshConfig.PublicKeyCallback)
 	}
 
 	// Add host keys
@@ -101,25 +258,329 @@ func NewServer(fs absfs.FileSystem, config *ServerConfig) *Server {
 		sshConfig.ServerVersion = "SSH-2.0-sftpfs"
 	}
 
-	return &Server{
+	s := &Server{
 		fs:       fs,
 		config:   sshConfig,
-		handlers: NewServerHandler(fs),
+		settings: *config,
+		auth:     limiter,
+	}
+	s.handler = s.newHandler(fs)
+	return s
+}
+
+// newHandler creates a ServerHandler for fs with the server's settings.
+func (s *Server) newHandler(fs absfs.FileSystem) *ServerHandler {
+	h := newServerHandler(fs)
+	h.restrictToRoot = s.settings.RestrictToRoot
+	h.nameDecoder = s.settings.NameDecoder
+	h.nameEncoder = s.settings.NameEncoder
+	h.atomicUploads = s.settings.AtomicUploads
+	h.capacity = s.settings.ReportedCapacity
+	h.renamePolicy = s.settings.RenamePolicy
+	h.maxPathDepth = s.settings.MaxPathDepth
+	h.maxNameLength = s.settings.MaxNameLength
+	if s.settings.StatCacheTTL > 0 {
+		h.cache = newStatCache(s.settings.StatCacheTTL)
+	}
+	h.maxListing = s.settings.MaxInMemoryListing
+	h.logger = s.settings.Logger
+	h.slowThreshold = s.settings.SlowThreshold
+	if s.settings.Root != "" {
+		h.setRoot(s.settings.Root)
+	}
+	return h
+}
+
+// errNoFilesystem is returned for a session that has no filesystem to
+// serve, because NewServer was given none or FilesystemForUser returned none.
+var errNoFilesystem = errors.New("sftpfs: no filesystem to serve")
+
+// sessionHandlers returns the handlers that serve the user of sshConn.
+// Request contexts are also cancelled when ctx is.
+func (s *Server) sessionHandlers(ctx context.Context, sshConn *ssh.ServerConn) (sftp.Handlers, error) {
+	h := s.handler
+	if s.settings.FilesystemForUser != nil {
+		fs, err := s.settings.FilesystemForUser(sshConn.User())
+		if err != nil {
+			return sftp.Handlers{}, err
+		}
+		h = s.newHandler(fs)
+	}
+	if h.fs == nil {
+		return sftp.Handlers{}, errNoFilesystem
+	}
+	if s.settings.PrefetchRoot {
+		h.prefetchRoot()
+	}
+	sh := &sessionHandler{ServerHandler: h, ctx: ctx, maxOpen: int32(s.settings.MaxOpenFiles)}
+	return sh.Handlers(), nil
+}
+
+// sessionHandler serves the requests of one SSH connection, tying each
+// request's context to the connection's lifetime.
+type sessionHandler struct {
+	*ServerHandler
+	ctx context.Context
+
+	// maxOpen, if positive, limits the files open at once; open counts
+	// them.
+	maxOpen int32
+	open    atomic.Int32
+}
+
+// Handlers returns the sftp.Handlers backed by h.
+func (h *sessionHandler) Handlers() sftp.Handlers {
+	return sftp.Handlers{
+		FileGet:  h,
+		FilePut:  h,
+		FileCmd:  h,
+		FileList: h,
+	}
+}
+
+// request returns a copy of r whose context is done when either r's context
+// or the session's is. Requests made for open handles are returned as is,
+// so ServerHandler removes their tags from the requests pkg/sftp replies
+// from.
+func (h *sessionHandler) request(r *sftp.Request) *sftp.Request {
+	if _, _, ok := h.parseTag(r.Filepath); ok {
+		return r
+	}
+	ctx, cancel := context.WithCancel(h.ctx)
+	context.AfterFunc(r.Context(), cancel)
+	return r.WithContext(ctx)
+}
+
+func (h *sessionHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	if !h.reserveOpen() {
+		return nil, sftp.ErrSSHFxFailure
+	}
+	f, err := h.opened(h.readFile(h.request(r)))
+	if err != nil {
+		return nil, err
+	}
+	tagHandle(r, f)
+	return f, nil
+}
+
+func (h *sessionHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	if !h.reserveOpen() {
+		return nil, sftp.ErrSSHFxFailure
+	}
+	f, err := h.opened(h.writeFile(h.request(r)))
+	if err != nil {
+		return nil, err
+	}
+	tagHandle(r, f)
+	return f, nil
+}
+
+// reserveOpen counts a file about to be opened, reporting false if the
+// session is already at its limit.
+func (h *sessionHandler) reserveOpen() bool {
+	if h.maxOpen <= 0 {
+		return true
+	}
+	if h.open.Add(1) > h.maxOpen {
+		h.open.Add(-1)
+		return false
+	}
+	return true
+}
+
+// opened releases the reservation made by reserveOpen when the open failed,
+// or when the client closes the file.
+func (h *sessionHandler) opened(f *serverFile, err error) (*serverFile, error) {
+	if err != nil {
+		if h.maxOpen > 0 {
+			h.open.Add(-1)
+		}
+		return nil, err
+	}
+	if h.maxOpen > 0 {
+		f.onClose = func() { h.open.Add(-1) }
 	}
+	return f, nil
+}
+
+func (h *sessionHandler) Filecmd(r *sftp.Request) error {
+	return h.ServerHandler.Filecmd(h.request(r))
+}
+
+func (h *sessionHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	return h.ServerHandler.Filelist(h.request(r))
+}
+
+func (h *sessionHandler) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	return h.ServerHandler.Lstat(h.request(r))
 }
 
 // Serve accepts incoming connections on the listener and serves SFTP.
-// This function blocks until the listener is closed.
+// This function blocks until the listener is closed or returns a permanent
+// error. Temporary accept errors, such as running out of file descriptors,
+// are retried with an increasing delay of up to one second. After Shutdown,
+// Serve returns ErrServerClosed.
 func (s *Server) Serve(listener net.Listener) error {
+	if !s.trackListener(listener, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(listener, false)
+
+	var tempDelay time.Duration
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			return err
+			if s.isShuttingDown() {
+				return ErrServerClosed
+			}
+			if !isTemporary(err) {
+				return err
+			}
+			if tempDelay == 0 {
+				tempDelay = 5 * time.Millisecond
+			} else {
+				tempDelay *= 2
+			}
+			if tempDelay > time.Second {
+				tempDelay = time.Second
+			}
+			time.Sleep(tempDelay)
+			continue
 		}
+		tempDelay = 0
 		go s.handleConnection(conn)
 	}
 }
 
+// ServeAll serves SFTP on each of the listeners concurrently, for example
+// on IPv4 and IPv6 addresses or several ports. If any listener fails, the
+// others are closed. ServeAll returns once all have stopped, with the first
+// error encountered, or ErrServerClosed after Shutdown.
+func (s *Server) ServeAll(listeners ...net.Listener) error {
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errs <- s.Serve(l)
+		}(l)
+	}
+
+	var first error
+	for range listeners {
+		err := <-errs
+		if first == nil {
+			first = err
+			for _, l := range listeners {
+				l.Close()
+			}
+		}
+	}
+	return first
+}
+
+// Shutdown stops the server gracefully: it closes every listener passed to
+// Serve or ServeAll, then waits for connected sessions to end. If ctx is
+// done first, the remaining sessions are disconnected and ctx's error is
+// returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shuttingDown = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		n := len(s.sessions)
+		s.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			for _, sess := range s.sessions {
+				sess.conn.Close()
+			}
+			s.mu.Unlock()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// runShutdownGrace is how long Run waits for sessions to end after its
+// context is cancelled before disconnecting them.
+const runShutdownGrace = 10 * time.Second
+
+// Run listens on the TCP address addr and serves SFTP until ctx is
+// cancelled, then shuts down gracefully, giving sessions up to ten seconds
+// to end. The bound address is logged, which shows the port chosen for an
+// addr such as ":0". Run returns nil after a cancellation, or the error
+// that stopped it otherwise, so it fits in an errgroup:
+//
+//	g.Go(func() error { return srv.Run(ctx, ":2222") })
+//
+// Like Shutdown, Run stops the server for good.
+func (s *Server) Run(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.logger().Info("sftp server listening", "addr", listener.Addr().String())
+
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(listener)
+	}()
+
+	select {
+	case err := <-served:
+		listener.Close()
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), runShutdownGrace)
+	defer cancel()
+	err = s.Shutdown(shutdownCtx)
+	<-served
+	return err
+}
+
+// trackListener adds or removes l from the listeners closed by Shutdown.
+// It reports false if l cannot be added because the server is shutting down.
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.listeners, l)
+		return true
+	}
+	if s.shuttingDown {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+func (s *Server) isShuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shuttingDown
+}
+
+// isTemporary reports whether err is marked as temporary, as with the
+// errors returned by net.Listener implementations for EMFILE or ECONNABORTED.
+func isTemporary(err error) bool {
+	var temp interface{ Temporary() bool }
+	return errors.As(err, &temp) && temp.Temporary()
+}
+
 // ServeConn handles a single incoming connection.
 // This is useful for custom connection handling or testing.
 func (s *Server) ServeConn(conn net.Conn) error {
@@ -129,20 +590,53 @@ func (s *Server) ServeConn(conn net.Conn) error {
 // handleConnection performs SSH handshake and serves SFTP.
 func (s *Server) handleConnection(conn net.Conn) error {
 	// Perform SSH handshake
-	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if s.auth.banned(hostOf(conn.RemoteAddr())) {
+		conn.Close()
+		return ErrAddressBanned
+	}
+	counter := &countingConn{Conn: conn}
+	if s.settings.HandshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.settings.HandshakeTimeout))
+	}
+	sshConn, chans, reqs, err := ssh.NewServerConn(counter, s.config)
 	if err != nil {
 		conn.Close()
 		return err
 	}
+	if s.settings.HandshakeTimeout > 0 {
+		conn.SetDeadline(time.Time{})
+	}
 	defer sshConn.Close()
 
+	sess := s.addSession(sshConn, counter)
+	defer s.removeSession(sess)
+
+	// Cancelled once the connection ends, aborting in-flight requests
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := s.logger().With("user", sshConn.User(), "remote", sshConn.RemoteAddr().String())
+	handlers, err := s.sessionHandlers(ctx, sshConn)
+	if err != nil {
+		log.Error("sftp session setup failed", "err", err)
+		return err
+	}
+	var home string
+	if s.settings.HomeDir != nil {
+		home = s.settings.HomeDir(sshConn.User())
+	}
+
 	// Discard global requests
 	go ssh.DiscardRequests(reqs)
 
 	// Handle channels
 	for newChannel := range chans {
 		if newChannel.ChannelType() != "session" {
-			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			if s.settings.ChannelHandler != nil {
+				go s.settings.ChannelHandler(newChannel)
+			} else {
+				newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			}
 			continue
 		}
 
@@ -151,26 +645,32 @@ func (s *Server) handleConnection(conn net.Conn) error {
 			continue
 		}
 
-		go s.handleChannel(channel, requests)
+		go s.handleChannel(channel, requests, handlers, home, log)
 	}
 
 	return nil
 }
 
 // handleChannel handles an SSH channel, looking for SFTP subsystem requests.
-func (s *Server) handleChannel(channel ssh.Channel, requests <-chan *ssh.Request) {
+// home is the session's start directory, or "" for "/". A failed SFTP
+// session is logged on log and reported to the client with exit status 1
+// before the channel is closed.
+func (s *Server) handleChannel(channel ssh.Channel, requests <-chan *ssh.Request, handlers sftp.Handlers, home string, log *slog.Logger) {
 	defer channel.Close()
 
 	for req := range requests {
 		ok := false
 		switch req.Type {
 		case "subsystem":
-			if string(req.Payload[4:]) == "sftp" {
+			if name, valid := subsystemName(req.Payload); valid && name == "sftp" {
 				ok = true
 				if req.WantReply {
 					req.Reply(ok, nil)
 				}
-				s.serveSFTP(channel)
+				if err := s.serveSFTP(channel, handlers, home); err != nil {
+					log.Error("sftp session failed", "err", err)
+					channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{1}))
+				}
 				return
 			}
 		}
@@ -180,11 +680,43 @@ func (s *Server) handleChannel(channel ssh.Channel, requests <-chan *ssh.Request
 	}
 }
 
-// serveSFTP creates and runs an SFTP server on the channel.
-func (s *Server) serveSFTP(channel ssh.Channel) {
-	server := sftp.NewRequestServer(channel, s.handlers)
-	server.Serve()
+// subsystemName decodes the SSH string holding the subsystem name in a
+// "subsystem" request payload. It reports false if the payload is too short
+// or its length prefix does not match the data that follows.
+func subsystemName(payload []byte) (string, bool) {
+	if len(payload) < 4 {
+		return "", false
+	}
+	n := binary.BigEndian.Uint32(payload)
+	if uint64(n) != uint64(len(payload)-4) {
+		return "", false
+	}
+	return string(payload[4:]), true
+}
+
+// serveSFTP creates and runs an SFTP server on the channel, resolving
+// relative paths against home if it is not empty. It returns the error that
+// ended the session, or nil when the client closed it.
+func (s *Server) serveSFTP(channel ssh.Channel, handlers sftp.Handlers, home string) error {
+	var opts []sftp.RequestServerOption
+	if home != "" {
+		opts = append(opts, sftp.WithStartDirectory(home))
+	}
+	server := sftp.NewRequestServer(channel, handlers, opts...)
+	err := server.Serve()
 	server.Close()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// logger returns the configured Logger or slog.Default.
+func (s *Server) logger() *slog.Logger {
+	if s.settings.Logger != nil {
+		return s.settings.Logger
+	}
+	return slog.Default()
 }
 
 // SSHConfig returns the underlying SSH server configuration.
@@ -215,6 +747,30 @@ func MultiUserPasswordAuth(users map[string]string) func(ssh.ConnMetadata, []byt
 	}
 }
 
+// CertificateAuth returns a PublicKeyCallback that accepts user certificates
+// signed by any of the keys in cas, for the principals they list and during
+// their validity period. Keys that are not certificates are passed to
+// fallback, or rejected if fallback is nil.
+func CertificateAuth(cas []ssh.PublicKey, fallback func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error)) func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			for _, ca := range cas {
+				if bytes.Equal(auth.Marshal(), ca.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+		UserKeyFallback: fallback,
+	}
+	return func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		if _, ok := key.(*ssh.Certificate); !ok && fallback == nil {
+			return nil, ErrAuthFailed
+		}
+		return checker.Authenticate(conn, key)
+	}
+}
+
 // ErrAuthFailed is returned when authentication fails.
 var ErrAuthFailed = &AuthError{msg: "authentication failed"}
 
@@ -1,11 +1,20 @@
 package sftpfs
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/absfs/absfs"
@@ -18,11 +27,135 @@ import (
 type ServerHandler struct {
 	fs absfs.FileSystem
 	mu sync.RWMutex
+
+	// root, if set, is the directory of the backing filesystem that
+	// request paths are relative to.
+	root string
+
+	// restrictToRoot, if set, is the directory requests must resolve into.
+	restrictToRoot string
+
+	// nameDecoder and nameEncoder translate names between the wire encoding
+	// and the backing filesystem's encoding. Either may be nil.
+	nameDecoder func(string) string
+	nameEncoder func(string) string
+
+	// atomicUploads makes Filewrite write to a temporary file that is
+	// renamed over the target when the upload is closed.
+	atomicUploads bool
+
+	// capacity, if set, is reported in reply to statvfs requests.
+	capacity *StatVFSInfo
+
+	// renamePolicy decides renames onto existing targets.
+	renamePolicy RenamePolicy
+
+	// cache, if set, holds recent Stat and List results of fs.
+	cache *statCache
+
+	// maxListing, if positive, is the most directory entries read into
+	// memory for a listing; larger directories are streamed.
+	maxListing int
+
+	// maxPathDepth and maxNameLength, if positive, limit the number of
+	// elements in request paths and the bytes in each element.
+	maxPathDepth  int
+	maxNameLength int
+
+	// logger receives diagnostics; slog.Default is used if nil.
+	logger *slog.Logger
+
+	// slowThreshold, if positive, is the duration above which requests
+	// and file reads and writes are logged as slow.
+	slowThreshold time.Duration
+
+	// open holds the files currently open, by the id track gave them, so
+	// Fstat requests can be answered from the file their handle is open on.
+	openMu    sync.Mutex
+	open      map[uint64]*serverFile
+	openID    uint64
+	openToken string // in the tags of open requests; see handleTag
+}
+
+// StatVFSInfo is the filesystem capacity a server reports to statvfs
+// requests from clients.
+type StatVFSInfo struct {
+	BlockSize     uint64 // Block size in bytes; 4096 if 0
+	TotalBytes    uint64 // Total size of the filesystem
+	FreeBytes     uint64 // Space available for new data
+	TotalFiles    uint64 // Total number of files (inodes)
+	FreeFiles     uint64 // Number of files that can still be created
+	MaxNameLength uint64 // Longest allowed file name; 255 if 0
+}
+
+// RenamePolicy is what the server does when a client renames a file onto a
+// path that already exists. The SFTP protocol leaves this to the server,
+// and backing filesystems disagree.
+type RenamePolicy int
+
+const (
+	// RenameDefault leaves the outcome to the backing filesystem's Rename.
+	RenameDefault RenamePolicy = iota
+
+	// RenameFailExisting refuses the rename, leaving both files in place,
+	// as SFTPv3 specifies.
+	RenameFailExisting
+
+	// RenameOverwrite replaces the existing file. If the backing
+	// filesystem refuses to rename over it, the file is removed first, in
+	// which case the replacement is not atomic.
+	RenameOverwrite
+
+	// RenameNumberedBackup first renames the existing file to the first
+	// free name of the form target.~N~, as mv --backup=numbered does.
+	RenameNumberedBackup
+)
+
+// ContextFileSystem is implemented by backing filesystems that can bind an
+// open file to a context. ServerHandler opens files through it when
+// available, passing a context that is cancelled when the client closes the
+// file or disconnects, so slow reads and writes can be abandoned.
+type ContextFileSystem interface {
+	OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (absfs.File, error)
+}
+
+// LinkFileSystem is implemented by backing filesystems that support hard
+// links. ServerHandler serves the hardlink@openssh.com extension through it;
+// for other filesystems link requests are unsupported.
+type LinkFileSystem interface {
+	Link(oldname, newname string) error
 }
 
 // NewServerHandler creates SFTP handlers that serve the given absfs.FileSystem.
 func NewServerHandler(fs absfs.FileSystem) sftp.Handlers {
-	h := &ServerHandler{fs: fs}
+	return newServerHandler(fs).Handlers()
+}
+
+// NewServerHandlerSub creates SFTP handlers that serve only the subtree of fs
+// rooted at root, which clients see as "/". Paths cannot climb above root
+// with "..", and opens through symlinks resolving outside root are refused.
+func NewServerHandlerSub(fs absfs.FileSystem, root string) sftp.Handlers {
+	h := newServerHandler(fs)
+	h.setRoot(root)
+	return h.Handlers()
+}
+
+// setRoot confines h to root, also restricting opens to it unless a
+// different restriction is already configured.
+func (h *ServerHandler) setRoot(root string) {
+	h.root = path.Clean("/" + root)
+	if h.restrictToRoot == "" {
+		h.restrictToRoot = h.root
+	}
+}
+
+// newServerHandler creates a ServerHandler with default settings.
+func newServerHandler(fs absfs.FileSystem) *ServerHandler {
+	return &ServerHandler{fs: fs}
+}
+
+// Handlers returns the sftp.Handlers backed by h.
+func (h *ServerHandler) Handlers() sftp.Handlers {
 	return sftp.Handlers{
 		FileGet:  h,
 		FilePut:  h,
@@ -35,20 +168,49 @@ func NewServerHandler(fs absfs.FileSystem) sftp.Handlers {
 // Returns an io.ReaderAt for the requested file path.
 // Called for SFTP Method: Get
 func (h *ServerHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	f, err := h.readFile(r)
+	if err != nil {
+		return nil, err
+	}
+	tagHandle(r, f)
+	return f, nil
+}
+
+// readFile opens the file for a Fileread request.
+func (h *ServerHandler) readFile(r *sftp.Request) (*serverFile, error) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	f, err := h.fs.Open(r.Filepath)
+	name := h.backendPath(r.Filepath)
+	defer h.logSlow(r.Method, name, time.Now())
+	if err := h.checkLimits(r); err != nil {
+		return nil, err
+	}
+	if err := h.checkRoot(name); err != nil {
+		return nil, err
+	}
+
+	f, err := h.openFile(r.Context(), name, os.O_RDONLY, 0)
 	if err != nil {
 		return nil, err
 	}
-	return &serverFile{file: f, path: r.Filepath}, nil
+	return h.track(&serverFile{file: f, path: name, h: h}), nil
 }
 
 // Filewrite implements sftp.FileWriter.
 // Returns an io.WriterAt for the requested file path.
 // Called for SFTP Methods: Put, Open
 func (h *ServerHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	f, err := h.writeFile(r)
+	if err != nil {
+		return nil, err
+	}
+	tagHandle(r, f)
+	return f, nil
+}
+
+// writeFile opens the file for a Filewrite request.
+func (h *ServerHandler) writeFile(r *sftp.Request) (*serverFile, error) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
@@ -70,11 +232,62 @@ func (h *ServerHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
 		flags = os.O_RDWR | os.O_CREATE
 	}
 
-	f, err := h.fs.OpenFile(r.Filepath, flags, 0644)
+	name := h.backendPath(r.Filepath)
+	defer h.logSlow(r.Method, name, time.Now())
+	if err := h.checkLimits(r); err != nil {
+		return nil, err
+	}
+	if err := h.checkRoot(name); err != nil {
+		return nil, err
+	}
+
+	if h.atomicUploads && !pflags.Append && (pflags.Trunc || !h.exists(name)) {
+		// O_EXCL applies to the target, not the temporary file
+		if pflags.Excl && h.exists(name) {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+		}
+		return h.openTempUpload(r.Context(), name, pflags.Excl)
+	}
+
+	f, err := h.openFile(r.Context(), name, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	h.cache.invalidateFile(name)
+	return h.track(&serverFile{file: f, path: name, h: h}), nil
+}
+
+// openTempUpload creates a hidden temporary file next to name. The returned
+// serverFile renames it over name when closed, unless excl is set and name
+// has been created in the meantime.
+func (h *ServerHandler) openTempUpload(ctx context.Context, name string, excl bool) (*serverFile, error) {
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return nil, err
+	}
+	dir, base := path.Split(name)
+	tmp := path.Join(dir, "."+base+"."+hex.EncodeToString(suffix[:])+".tmp")
+
+	f, err := h.openFile(ctx, tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
 		return nil, err
 	}
-	return &serverFile{file: f, path: r.Filepath}, nil
+	return h.track(&serverFile{file: f, path: name, h: h, fs: h.fs, tmpPath: tmp, excl: excl}), nil
+}
+
+// openFile opens name in the backing filesystem, binding the file to ctx if
+// the filesystem implements ContextFileSystem.
+func (h *ServerHandler) openFile(ctx context.Context, name string, flag int, perm os.FileMode) (absfs.File, error) {
+	if cfs, ok := h.fs.(ContextFileSystem); ok {
+		return cfs.OpenFileContext(ctx, name, flag, perm)
+	}
+	return h.fs.OpenFile(name, flag, perm)
+}
+
+// exists reports whether name exists in the backing filesystem.
+func (h *ServerHandler) exists(name string) bool {
+	_, err := h.fs.Stat(name)
+	return err == nil
 }
 
 // Filecmd implements sftp.FileCmder.
@@ -84,59 +297,191 @@ func (h *ServerHandler) Filecmd(r *sftp.Request) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	p, f := h.openHandle(r)
+	name := h.backendPath(p)
+	defer h.logSlow(r.Method, name, time.Now())
+	if err := h.checkLimits(r); err != nil {
+		return err
+	}
+	defer h.cache.invalidate(name)
+	if r.Target != "" {
+		defer h.cache.invalidate(h.backendPath(r.Target))
+	}
 	switch r.Method {
 	case "Setstat":
-		return h.handleSetstat(r)
+		if f != nil {
+			// Fsetstat: an atomic upload's target does not exist until
+			// the handle is closed
+			return h.handleSetstat(f.backingPath(), r)
+		}
+		if err := h.checkRoot(name); err != nil {
+			return err
+		}
+		return h.handleSetstat(name, r)
 	case "Rename":
-		return h.fs.Rename(r.Filepath, r.Target)
-	case "Rmdir":
-		return h.fs.Remove(r.Filepath)
+		target := h.backendPath(r.Target)
+		for _, p := range []string{name, target} {
+			if err := h.checkRootEntry(p); err != nil {
+				return err
+			}
+		}
+		return h.rename(name, target)
+	case "Rmdir", "Remove":
+		if err := h.checkRootEntry(name); err != nil {
+			return err
+		}
+		return h.fs.Remove(name)
 	case "Mkdir":
-		return h.fs.Mkdir(r.Filepath, 0755)
-	case "Remove":
-		return h.fs.Remove(r.Filepath)
+		if err := h.checkRoot(name); err != nil {
+			return err
+		}
+		// pkg/sftp's RequestServer drops the attributes of mkdir
+		// requests, so the mode is always 0755 here; clients set their
+		// own with a Setstat afterwards
+		return h.fs.Mkdir(name, 0755)
 	case "Symlink":
+		// For symlinks Filepath holds the link's target and Target the
+		// path of the new link. Relative targets are stored as given.
 		if sfs, ok := h.fs.(absfs.SymlinkFileSystem); ok {
-			return sfs.Symlink(r.Target, r.Filepath)
+			link := h.backendPath(r.Target)
+			target := r.Filepath
+			if path.IsAbs(target) {
+				target = name
+			} else if h.nameDecoder != nil {
+				target = mapPathNames(target, h.nameDecoder)
+			}
+			if err := h.checkRootEntry(link); err != nil {
+				return err
+			}
+			resolved := target
+			if !path.IsAbs(resolved) {
+				resolved = path.Join(path.Dir(link), resolved)
+			}
+			if err := h.checkRoot(resolved); err != nil {
+				return err
+			}
+			return sfs.Symlink(target, link)
 		}
 		return sftp.ErrSSHFxOpUnsupported
 	case "Link":
-		// Hard links not commonly supported
-		return sftp.ErrSSHFxOpUnsupported
+		lfs, ok := h.fs.(LinkFileSystem)
+		if !ok {
+			return sftp.ErrSSHFxOpUnsupported
+		}
+		target := h.backendPath(r.Target)
+		for _, p := range []string{name, target} {
+			if err := h.checkRoot(p); err != nil {
+				return err
+			}
+		}
+		return lfs.Link(name, target)
 	default:
 		return sftp.ErrSSHFxOpUnsupported
 	}
 }
 
+// StatVFS implements sftp.StatVFSFileCmder, reporting the configured
+// capacity. Without one it returns sftp.ErrSSHFxOpUnsupported.
+func (h *ServerHandler) StatVFS(r *sftp.Request) (*sftp.StatVFS, error) {
+	if h.capacity == nil {
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+	c := *h.capacity
+	if c.BlockSize == 0 {
+		c.BlockSize = 4096
+	}
+	if c.MaxNameLength == 0 {
+		c.MaxNameLength = 255
+	}
+	return &sftp.StatVFS{
+		Bsize:   c.BlockSize,
+		Frsize:  c.BlockSize,
+		Blocks:  c.TotalBytes / c.BlockSize,
+		Bfree:   c.FreeBytes / c.BlockSize,
+		Bavail:  c.FreeBytes / c.BlockSize,
+		Files:   c.TotalFiles,
+		Ffree:   c.FreeFiles,
+		Favail:  c.FreeFiles,
+		Namemax: c.MaxNameLength,
+	}, nil
+}
+
+// rename moves oldname to newname according to h.renamePolicy.
+func (h *ServerHandler) rename(oldname, newname string) error {
+	if h.renamePolicy == RenameDefault || oldname == newname {
+		return h.fs.Rename(oldname, newname)
+	}
+	existing, err := h.fs.Stat(newname)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return h.fs.Rename(oldname, newname)
+	}
+	switch h.renamePolicy {
+	case RenameFailExisting:
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrExist}
+	case RenameOverwrite:
+		err := h.fs.Rename(oldname, newname)
+		if err != nil && !existing.IsDir() {
+			if rmErr := h.fs.Remove(newname); rmErr == nil {
+				err = h.fs.Rename(oldname, newname)
+			}
+		}
+		return err
+	case RenameNumberedBackup:
+		for n := 1; ; n++ {
+			backup := fmt.Sprintf("%s.~%d~", newname, n)
+			if _, err := h.fs.Stat(backup); errors.Is(err, os.ErrNotExist) {
+				if err := h.fs.Rename(newname, backup); err != nil {
+					return err
+				}
+				return h.fs.Rename(oldname, newname)
+			} else if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("sftpfs: unknown RenamePolicy %d", h.renamePolicy)
+	}
+}
+
 // handleSetstat handles the Setstat command for changing file attributes.
-func (h *ServerHandler) handleSetstat(r *sftp.Request) error {
+// Each attribute is changed only when its flag is set in the request, so a
+// client setting times alone never changes the mode, owner or size.
+func (h *ServerHandler) handleSetstat(name string, r *sftp.Request) error {
+	flags := r.AttrFlags()
 	attrs := r.Attributes()
 
-	// Handle mode change
-	if attrs.FileMode() != 0 {
-		if err := h.fs.Chmod(r.Filepath, attrs.FileMode()); err != nil {
+	if flags.Permissions {
+		mode := attrs.FileMode() &^ os.ModeType
+		// Clients send only permission bits; keep the file's type, since
+		// some filesystems store the mode given to Chmod verbatim
+		if info, err := h.fs.Stat(name); err == nil {
+			mode |= info.Mode() & os.ModeType
+		}
+		if err := h.fs.Chmod(name, mode); err != nil {
 			return err
 		}
 	}
 
-	// Handle time changes (Atime and Mtime are uint32 Unix timestamps)
-	if attrs.Atime != 0 || attrs.Mtime != 0 {
+	// Atime and Mtime are uint32 Unix timestamps, always sent together
+	if flags.Acmodtime {
 		atime := time.Unix(int64(attrs.Atime), 0)
 		mtime := time.Unix(int64(attrs.Mtime), 0)
-		if attrs.Atime == 0 {
-			atime = mtime
-		}
-		if attrs.Mtime == 0 {
-			mtime = atime
+		if err := h.fs.Chtimes(name, atime, mtime); err != nil {
+			return err
 		}
-		if err := h.fs.Chtimes(r.Filepath, atime, mtime); err != nil {
+	}
+
+	if flags.UidGid {
+		if err := h.fs.Chown(name, int(attrs.UID), int(attrs.GID)); err != nil {
 			return err
 		}
 	}
 
-	// Handle ownership changes
-	if attrs.UID != 0 || attrs.GID != 0 {
-		if err := h.fs.Chown(r.Filepath, int(attrs.UID), int(attrs.GID)); err != nil {
+	if flags.Size {
+		if err := h.fs.Truncate(name, int64(attrs.Size)); err != nil {
 			return err
 		}
 	}
@@ -151,75 +496,501 @@ func (h *ServerHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
+	p, f := h.openHandle(r)
+	name := h.backendPath(p)
+	defer h.logSlow(r.Method, name, time.Now())
+	if err := h.checkLimits(r); err != nil {
+		return nil, err
+	}
 	switch r.Method {
 	case "List":
-		return h.handleList(r)
+		if err := h.checkRoot(name); err != nil {
+			return nil, err
+		}
+		return h.handleList(name)
 	case "Stat":
-		return h.handleStat(r)
+		if f != nil {
+			return h.handleFstat(f)
+		}
+		if err := h.checkRoot(name); err != nil {
+			return nil, err
+		}
+		return h.handleStat(name)
 	case "Readlink":
-		return h.handleReadlink(r)
+		if err := h.checkRootEntry(name); err != nil {
+			return nil, err
+		}
+		return h.handleReadlink(name)
 	default:
 		return nil, sftp.ErrSSHFxOpUnsupported
 	}
 }
 
-// handleList returns directory contents.
-func (h *ServerHandler) handleList(r *sftp.Request) (sftp.ListerAt, error) {
-	dir, err := h.fs.Open(r.Filepath)
-	if err != nil {
-		return nil, err
+// handleList returns directory contents. A directory with more entries
+// than maxListing is streamed rather than read into memory.
+func (h *ServerHandler) handleList(name string) (sftp.ListerAt, error) {
+	entries, ok := h.cache.list(name)
+	if !ok {
+		var stream *streamLister
+		var err error
+		if entries, stream, err = h.readDir(name, h.maxListing); err != nil {
+			return nil, err
+		}
+		if stream != nil {
+			return stream, nil
+		}
+		h.cache.putList(name, entries)
+	}
+
+	wire := make([]os.FileInfo, len(entries))
+	for i, info := range entries {
+		wire[i] = h.wireInfo(info)
 	}
-	defer dir.Close()
+	return &listerat{entries: wire}, nil
+}
 
-	entries, err := dir.Readdir(-1)
+// readDir returns the entries of the directory name in the backing
+// filesystem, sorted by name for consistent ordering. If limit is
+// positive and the directory has more entries than limit, it returns a
+// streamLister over the directory instead, holding the entries read so
+// far, in the backing filesystem's order.
+func (h *ServerHandler) readDir(name string, limit int) ([]os.FileInfo, *streamLister, error) {
+	dir, err := h.fs.Open(name)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Sort entries by name for consistent ordering
+	n := -1
+	if limit > 0 {
+		n = limit + 1
+	}
+	entries, err := dir.Readdir(n)
+	if err != nil && !(n > 0 && err == io.EOF) {
+		dir.Close()
+		return nil, nil, err
+	}
+	h.lstatEntries(name, entries)
+	if n > 0 && len(entries) == n {
+		return nil, &streamLister{h: h, name: name, dir: dir, buf: entries}, nil
+	}
+	dir.Close()
+
 	sort.Slice(entries, func(i, j int) bool {
 		return entries[i].Name() < entries[j].Name()
 	})
+	return entries, nil, nil
+}
 
-	return &listerat{entries: entries}, nil
+// lstatEntries makes the entries of the directory name describe symlinks
+// themselves, as readdir replies should: a backing filesystem with
+// symlinks may follow them in Readdir, so each entry not already reported
+// as a link is Lstat'ed, keeping the Readdir info if that fails.
+func (h *ServerHandler) lstatEntries(name string, entries []os.FileInfo) {
+	sfs, ok := h.fs.(absfs.SymlinkFileSystem)
+	if !ok {
+		return
+	}
+	for i, info := range entries {
+		if info.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+		if linfo, err := sfs.Lstat(path.Join(name, info.Name())); err == nil {
+			entries[i] = linfo
+		}
+	}
 }
 
-// handleStat returns file info for a single file.
-func (h *ServerHandler) handleStat(r *sftp.Request) (sftp.ListerAt, error) {
-	info, err := h.fs.Stat(r.Filepath)
+// prefetchRoot lists the root directory into the cache unless a fresh
+// listing is already there. Errors, and roots too large to hold in
+// memory, are ignored.
+func (h *ServerHandler) prefetchRoot() {
+	if h.cache == nil {
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	name := h.backendPath("/")
+	if _, ok := h.cache.list(name); ok {
+		return
+	}
+	entries, stream, err := h.readDir(name, h.maxListing)
+	if stream != nil {
+		stream.Close()
+	} else if err == nil {
+		h.cache.putList(name, entries)
+	}
+}
+
+// handleFstat returns file info for an open file.
+func (h *ServerHandler) handleFstat(f *serverFile) (sftp.ListerAt, error) {
+	f.mu.Lock()
+	info, err := f.file.Stat()
+	f.mu.Unlock()
 	if err != nil {
 		return nil, err
 	}
-	return &listerat{entries: []os.FileInfo{info}}, nil
+	return &listerat{entries: []os.FileInfo{h.wireInfo(info)}}, nil
+}
+
+// track records f as open until it is closed.
+func (h *ServerHandler) track(f *serverFile) *serverFile {
+	h.openMu.Lock()
+	defer h.openMu.Unlock()
+	if h.open == nil {
+		h.open = make(map[uint64]*serverFile)
+	}
+	if h.openToken == "" {
+		var token [8]byte
+		if _, err := rand.Read(token[:]); err == nil {
+			h.openToken = hex.EncodeToString(token[:])
+		}
+	}
+	h.openID++
+	f.id = h.openID
+	f.tag = handleTag + h.openToken + strconv.FormatUint(f.id, 10)
+	h.open[f.id] = f
+	return f
+}
+
+// untrack forgets the open file f.
+func (h *ServerHandler) untrack(f *serverFile) {
+	h.openMu.Lock()
+	defer h.openMu.Unlock()
+	delete(h.open, f.id)
+}
+
+// handleTag starts the tag that tagHandle appends to the path of an open
+// request. pkg/sftp's RequestServer answers SSH_FXP_FSTAT and
+// SSH_FXP_FSETSTAT by making new Stat and Setstat requests that carry only
+// the Filepath of the request that opened the handle, so the tag is how
+// those find the file their handle is open on. It holds a random token of
+// the handler's, which clients cannot guess, and the file's id.
+const handleTag = "\x00"
+
+// tagHandle records in r, the request that opened f, which file its handle
+// is open on.
+func tagHandle(r *sftp.Request, f *serverFile) {
+	r.Filepath += f.tag
+}
+
+// parseTag splits p into a path and the id in its tag, reporting whether p
+// carries a tag made by h. Paths from the wire never do.
+func (h *ServerHandler) parseTag(p string) (string, uint64, bool) {
+	base, tag, ok := strings.Cut(p, handleTag)
+	if !ok {
+		return p, 0, false
+	}
+	h.openMu.Lock()
+	token := h.openToken
+	h.openMu.Unlock()
+	rest, ok := strings.CutPrefix(tag, token)
+	if token == "" || !ok {
+		return p, 0, false
+	}
+	id, err := strconv.ParseUint(rest, 10, 64)
+	if err != nil {
+		return p, 0, false
+	}
+	return base, id, true
+}
+
+// openHandle returns the path of r and, if r was made for an open handle,
+// the file the handle is open on, or nil once it is closed. The tag is
+// removed from such requests, which pkg/sftp makes for one operation and
+// then discards, so that no error reply built from their path shows it.
+func (h *ServerHandler) openHandle(r *sftp.Request) (string, *serverFile) {
+	p, id, ok := h.parseTag(r.Filepath)
+	if !ok {
+		return r.Filepath, nil
+	}
+	r.Filepath = p
+	h.openMu.Lock()
+	defer h.openMu.Unlock()
+	return p, h.open[id]
+}
+
+// handleStat returns file info for a single file, following symbolic
+// links. The linkInfo built for readlink replies never appears here: its size
+// is the length of the target path, not of the file.
+func (h *ServerHandler) handleStat(name string) (sftp.ListerAt, error) {
+	info, ok := h.cache.stat(name)
+	if !ok {
+		var err error
+		if info, err = h.fs.Stat(name); err != nil {
+			return nil, err
+		}
+		h.cache.putStat(name, info)
+	}
+	return &listerat{entries: []os.FileInfo{h.wireInfo(info)}}, nil
+}
+
+// Lstat implements sftp.LstatFileLister, describing a symbolic link itself
+// rather than the file it points to. Without it, pkg/sftp answers lstat
+// requests as stat. Backing filesystems without symlinks are stat'ed.
+func (h *ServerHandler) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	name := h.backendPath(r.Filepath)
+	defer h.logSlow(r.Method, name, time.Now())
+	if err := h.checkLimits(r); err != nil {
+		return nil, err
+	}
+	if err := h.checkRootEntry(name); err != nil {
+		return nil, err
+	}
+	sfs, ok := h.fs.(absfs.SymlinkFileSystem)
+	if !ok {
+		return h.handleStat(name)
+	}
+	info, err := sfs.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	return &listerat{entries: []os.FileInfo{h.wireInfo(info)}}, nil
 }
 
 // handleReadlink returns the target of a symbolic link.
-func (h *ServerHandler) handleReadlink(r *sftp.Request) (sftp.ListerAt, error) {
+func (h *ServerHandler) handleReadlink(name string) (sftp.ListerAt, error) {
 	sfs, ok := h.fs.(absfs.SymlinkFileSystem)
 	if !ok {
 		return nil, sftp.ErrSSHFxOpUnsupported
 	}
 
-	target, err := sfs.Readlink(r.Filepath)
+	target, err := sfs.Readlink(name)
 	if err != nil {
 		return nil, err
 	}
+	target = h.wirePath(target)
+
+	// Return a fake FileInfo with the link target as the name. pkg/sftp
+	// replies with the name as is, so it must be the whole target, not
+	// the base name linkInfo reports.
+	info := &renamedInfo{FileInfo: &linkInfo{name: target}, name: target}
+	return &listerat{entries: []os.FileInfo{info}}, nil
+}
+
+// logSlow logs an operation on name that began at start if it took longer
+// than the slow threshold.
+func (h *ServerHandler) logSlow(method, name string, start time.Time) {
+	if h.slowThreshold <= 0 {
+		return
+	}
+	d := time.Since(start)
+	if d < h.slowThreshold {
+		return
+	}
+	logger := h.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Warn("slow sftp operation", "method", method, "path", name, "duration", d)
+}
+
+// errPathTooDeep is returned for request paths with more elements than
+// ServerConfig.MaxPathDepth.
+var errPathTooDeep = errors.New("path too deep")
+
+// checkLimits rejects r if its path, or its target for renames and links,
+// exceeds the configured depth or name length. Clients receive a failure
+// status carrying the error message.
+func (h *ServerHandler) checkLimits(r *sftp.Request) error {
+	if h.maxPathDepth <= 0 && h.maxNameLength <= 0 {
+		return nil
+	}
+	for _, p := range []string{r.Filepath, r.Target} {
+		if p == "" {
+			continue
+		}
+		names := strings.FieldsFunc(p, func(c rune) bool { return c == '/' })
+		if h.maxPathDepth > 0 && len(names) > h.maxPathDepth {
+			return &os.PathError{Op: strings.ToLower(r.Method), Path: p, Err: errPathTooDeep}
+		}
+		for _, name := range names {
+			if h.maxNameLength > 0 && len(name) > h.maxNameLength {
+				return &os.PathError{Op: strings.ToLower(r.Method), Path: p, Err: syscall.ENAMETOOLONG}
+			}
+		}
+	}
+	return nil
+}
+
+// backendPath translates a request path from the wire encoding into the
+// backing filesystem's encoding, and into the served subtree if a root is
+// set.
+func (h *ServerHandler) backendPath(p string) string {
+	if h.nameDecoder != nil {
+		p = mapPathNames(p, h.nameDecoder)
+	}
+	if h.root != "" {
+		p = path.Join(h.root, path.Clean("/"+p))
+	}
+	return p
+}
+
+// wirePath translates a backing filesystem path, such as a link target,
+// into the path clients see: out of the served subtree if it lies within a
+// root, and into the wire encoding. Relative paths and paths outside the
+// root keep their form.
+func (h *ServerHandler) wirePath(p string) string {
+	if h.root != "" && h.root != "/" && path.IsAbs(p) {
+		if c := path.Clean(p); c == h.root {
+			p = "/"
+		} else if strings.HasPrefix(c, h.root+"/") {
+			p = strings.TrimPrefix(c, h.root)
+		}
+	}
+	if h.nameEncoder != nil {
+		p = mapPathNames(p, h.nameEncoder)
+	}
+	return p
+}
 
-	// Return a fake FileInfo with the link target as the name
-	return &listerat{entries: []os.FileInfo{&linkInfo{name: target}}}, nil
+// wireInfo returns info with its name translated into the wire encoding.
+func (h *ServerHandler) wireInfo(info os.FileInfo) os.FileInfo {
+	if h.nameEncoder == nil {
+		return info
+	}
+	return &renamedInfo{FileInfo: info, name: h.nameEncoder(info.Name())}
+}
+
+// mapPathNames applies fn to each element of the slash-separated path p.
+func mapPathNames(p string, fn func(string) string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		if part != "" && part != "." && part != ".." {
+			parts[i] = fn(part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// maxSymlinkHops bounds symlink resolution to guard against link loops.
+const maxSymlinkHops = 40
+
+// checkRoot returns os.ErrPermission if name, with all symlinks resolved,
+// lies outside the configured root. It is a no-op when no root is set.
+func (h *ServerHandler) checkRoot(name string) error {
+	if h.restrictToRoot == "" {
+		return nil
+	}
+	resolved, err := h.resolvePath(name)
+	if err != nil {
+		return err
+	}
+	if !pathWithin(path.Clean("/"+h.restrictToRoot), resolved) {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+// checkRootEntry is checkRoot for requests that act on the directory entry
+// name itself, such as removing or renaming a symlink: name's parent must
+// resolve inside the root, but name is not followed.
+func (h *ServerHandler) checkRootEntry(name string) error {
+	if h.restrictToRoot == "" {
+		return nil
+	}
+	return h.checkRoot(path.Dir(path.Clean("/" + name)))
+}
+
+// resolvePath returns the absolute form of name with every symlink component
+// resolved against the backing filesystem. Components that do not exist yet
+// are kept as-is so paths of files about to be created can still be checked.
+func (h *ServerHandler) resolvePath(name string) (string, error) {
+	cleaned := path.Clean("/" + name)
+	sfs, ok := h.fs.(absfs.SymlinkFileSystem)
+	if !ok {
+		return cleaned, nil
+	}
+
+	pending := strings.Split(cleaned, "/")
+	resolved := "/"
+	hops := 0
+	for len(pending) > 0 {
+		part := pending[0]
+		pending = pending[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, part)
+		info, err := sfs.Lstat(next)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return "", &os.PathError{Op: "resolve", Path: name, Err: syscall.ELOOP}
+		}
+		target, err := sfs.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(resolved, target)
+		}
+		pending = append(strings.Split(path.Clean(target), "/"), pending...)
+		resolved = "/"
+	}
+	return resolved, nil
+}
+
+// pathWithin reports whether p is root or lies beneath it.
+// Both paths must be clean and absolute.
+func pathWithin(root, p string) bool {
+	if root == "/" || p == root {
+		return true
+	}
+	return strings.HasPrefix(p, root+"/")
 }
 
 // serverFile wraps an absfs.File to implement io.ReaderAt, io.WriterAt, and io.Closer.
+// Every SFTP open gets its own serverFile over a backing file opened just for
+// it, so readers of the same path never share an offset. ReadAt and WriteAt
+// seek and transfer under mu, as the client may issue several requests on
+// one handle concurrently.
 type serverFile struct {
 	file absfs.File
 	path string
+	h    *ServerHandler // for slow operation logging; may be nil
+	id   uint64         // set by track
+	tag  string         // set by track; see handleTag
 	mu   sync.Mutex
+
+	// onClose, if set, is called once when the file is closed.
+	onClose func()
+
+	// For atomic uploads, file is open on tmpPath in fs and is renamed to
+	// path on Close unless a write failed.
+	fs      absfs.FileSystem
+	tmpPath string
+	excl    bool // the upload was opened with O_EXCL
+	failed  bool
+}
+
+// backingPath returns the path f is open on in the backing filesystem.
+func (f *serverFile) backingPath() string {
+	if f.tmpPath != "" {
+		return f.tmpPath
+	}
+	return f.path
 }
 
 // ReadAt implements io.ReaderAt.
 func (f *serverFile) ReadAt(p []byte, off int64) (int, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	if f.h != nil {
+		defer f.h.logSlow("Read", f.path, time.Now())
+	}
 
 	_, err := f.file.Seek(off, io.SeekStart)
 	if err != nil {
@@ -232,17 +1003,69 @@ func (f *serverFile) ReadAt(p []byte, off int64) (int, error) {
 func (f *serverFile) WriteAt(p []byte, off int64) (int, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	if f.h != nil {
+		defer f.h.logSlow("Write", f.path, time.Now())
+	}
 
 	_, err := f.file.Seek(off, io.SeekStart)
 	if err != nil {
+		f.failed = true
 		return 0, err
 	}
-	return f.file.Write(p)
+	n, err := f.file.Write(p)
+	if err != nil {
+		f.failed = true
+	}
+	if f.h != nil {
+		f.h.cache.invalidateFile(f.path)
+	}
+	return n, err
 }
 
 // Close implements io.Closer.
+// For atomic uploads it moves the temporary file into place, or removes it
+// if any write failed.
 func (f *serverFile) Close() error {
-	return f.file.Close()
+	if f.h != nil {
+		f.h.untrack(f)
+		defer f.h.cache.invalidateFile(f.path)
+	}
+	if f.onClose != nil {
+		f.onClose()
+		f.onClose = nil
+	}
+	err := f.file.Close()
+	if f.tmpPath == "" {
+		return err
+	}
+	if err != nil || f.failed {
+		f.fs.Remove(f.tmpPath)
+		return err
+	}
+	return f.commit()
+}
+
+// commit renames the temporary upload over the target path. Backing
+// filesystems that refuse to rename over an existing file get the target
+// removed first. An O_EXCL upload is discarded instead if the target has
+// been created since it was opened.
+func (f *serverFile) commit() error {
+	if f.excl {
+		if _, err := f.fs.Stat(f.path); err == nil {
+			f.fs.Remove(f.tmpPath)
+			return &os.PathError{Op: "open", Path: f.path, Err: os.ErrExist}
+		}
+	}
+	err := f.fs.Rename(f.tmpPath, f.path)
+	if errors.Is(err, os.ErrExist) {
+		if err = f.fs.Remove(f.path); err == nil {
+			err = f.fs.Rename(f.tmpPath, f.path)
+		}
+	}
+	if err != nil {
+		f.fs.Remove(f.tmpPath)
+	}
+	return err
 }
 
 // listerat implements sftp.ListerAt for directory listings.
@@ -264,6 +1087,78 @@ func (l *listerat) ListAt(ls []os.FileInfo, offset int64) (int, error) {
 	return n, nil
 }
 
+// errListOffset is returned by streamLister for a read at an offset other
+// than where the previous one ended, which pkg/sftp never makes.
+var errListOffset = errors.New("directory listing read out of order")
+
+// streamLister implements sftp.ListerAt over an open directory, reading
+// entries from it as the client asks for them. pkg/sftp does not close
+// listers, so the directory is closed once it has been read to the end or
+// failed; a client that abandons a listing leaves it open until the
+// handle is collected.
+type streamLister struct {
+	h    *ServerHandler
+	name string
+
+	mu   sync.Mutex
+	dir  absfs.File
+	buf  []os.FileInfo // entries read but not yet returned
+	next int64         // offset of buf[0]
+	err  error         // why reading ended, io.EOF at the end
+}
+
+// ListAt implements sftp.ListerAt. Reads must be sequential.
+func (l *streamLister) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if offset != l.next {
+		return 0, errListOffset
+	}
+
+	for len(l.buf) < len(ls) && l.err == nil {
+		entries, err := l.dir.Readdir(len(ls) - len(l.buf))
+		l.h.lstatEntries(l.name, entries)
+		l.buf = append(l.buf, entries...)
+		if err == nil && len(entries) == 0 {
+			err = io.EOF
+		}
+		if err != nil {
+			l.err = err
+			l.dir.Close()
+		}
+	}
+
+	n := 0
+	for ; n < len(ls) && n < len(l.buf); n++ {
+		ls[n] = l.h.wireInfo(l.buf[n])
+	}
+	l.buf = l.buf[n:]
+	l.next += int64(n)
+	if len(l.buf) == 0 && l.err != nil && (n == 0 || l.err == io.EOF) {
+		return n, l.err
+	}
+	return n, nil
+}
+
+// Close closes the directory if it is still open.
+func (l *streamLister) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return nil
+	}
+	l.err = os.ErrClosed
+	return l.dir.Close()
+}
+
+// renamedInfo overrides the name reported by a FileInfo.
+type renamedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (r *renamedInfo) Name() string { return r.name }
+
 // linkInfo is a minimal FileInfo for symlink targets.
 type linkInfo struct {
 	name string
@@ -1,12 +1,20 @@
 package sftpfs
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -16,47 +24,34 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-// testServerSetup creates a server and client for testing.
-func testServerSetup(t *testing.T, fs absfs.FileSystem) (*Server, *sftp.Client, func()) {
+// testHostKey returns a freshly generated ed25519 host key.
+func testHostKey(t *testing.T) ssh.Signer {
 	t.Helper()
-
-	// Generate a test host key
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	_, key, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		t.Fatalf("Failed to generate host key: %v", err)
 	}
-	signer, err := ssh.NewSignerFromKey(privateKey)
+	signer, err := ssh.NewSignerFromKey(key)
 	if err != nil {
 		t.Fatalf("Failed to create signer: %v", err)
 	}
+	return signer
+}
 
-	// Create server
-	server := NewServer(fs, &ServerConfig{
-		HostKeys:         []ssh.Signer{signer},
-		PasswordCallback: SimplePasswordAuth("testuser", "testpass"),
-	})
-
-	// Create listener on random port
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("Failed to create listener: %v", err)
-	}
-
-	// Start server in background
-	go server.Serve(listener)
+// testServerSetup creates a server and client for testing.
+func testServerSetup(t *testing.T, fs absfs.FileSystem) (*Server, *sftp.Client, func()) {
+	t.Helper()
+	return testServerSetupWithConfig(t, fs, &ServerConfig{})
+}
 
-	// Give server time to start
-	time.Sleep(50 * time.Millisecond)
+// testServerSetupWithConfig is like testServerSetup but lets the caller set
+// additional server options. Host keys and password auth are filled in.
+func testServerSetupWithConfig(t *testing.T, fs absfs.FileSystem, config *ServerConfig) (*Server, *sftp.Client, func()) {
+	t.Helper()
 
-	// Create client
-	sshConfig := &ssh.ClientConfig{
-		User:            "testuser",
-		Auth:            []ssh.AuthMethod{ssh.Password("testpass")},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         5 * time.Second,
-	}
+	server, listener := testServerListen(t, fs, config)
 
-	sshClient, err := ssh.Dial("tcp", listener.Addr().String(), sshConfig)
+	sshClient, err := testDialSSH(listener.Addr().String(), "testuser", "testpass")
 	if err != nil {
 		listener.Close()
 		t.Fatalf("Failed to connect SSH: %v", err)
@@ -78,6 +73,54 @@ func testServerSetup(t *testing.T, fs absfs.FileSystem) (*Server, *sftp.Client,
 	return server, client, cleanup
 }
 
+// testServerListen starts a server on a random loopback port. If config has
+// no host keys one is generated, and if it has no auth callbacks testuser
+// with password testpass is accepted. The caller must close the listener.
+func testServerListen(t *testing.T, fs absfs.FileSystem, config *ServerConfig) (*Server, net.Listener) {
+	t.Helper()
+
+	if len(config.HostKeys) == 0 {
+		// Generate a test host key
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("Failed to generate host key: %v", err)
+		}
+		signer, err := ssh.NewSignerFromKey(privateKey)
+		if err != nil {
+			t.Fatalf("Failed to create signer: %v", err)
+		}
+		config.HostKeys = []ssh.Signer{signer}
+	}
+	if config.PasswordCallback == nil && config.PublicKeyCallback == nil && !config.NoClientAuth {
+		config.PasswordCallback = SimplePasswordAuth("testuser", "testpass")
+	}
+	server := NewServer(fs, config)
+
+	// Create listener on random port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+
+	// Start server in background
+	go server.Serve(listener)
+
+	// Give server time to start
+	time.Sleep(50 * time.Millisecond)
+
+	return server, listener
+}
+
+// testDialSSH connects to addr with password authentication.
+func testDialSSH(addr, user, password string) (*ssh.Client, error) {
+	return ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+}
+
 func TestServer_BasicOperations(t *testing.T) {
 	fs, err := memfs.NewFS()
 	if err != nil {
@@ -229,6 +272,87 @@ func TestServer_Rename(t *testing.T) {
 	}
 }
 
+// noReplaceFS refuses to rename onto an existing path, as some backing
+// filesystems do.
+type noReplaceFS struct {
+	absfs.FileSystem
+}
+
+func (fs *noReplaceFS) Rename(oldpath, newpath string) error {
+	if _, err := fs.Stat(newpath); err == nil {
+		return os.ErrExist
+	}
+	return fs.FileSystem.Rename(oldpath, newpath)
+}
+
+func TestServer_RenamePolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		policy    RenamePolicy
+		noReplace bool
+		ok        bool
+		want      map[string]string // backing file contents after the rename
+	}{
+		{"fail existing", RenameFailExisting, false, false, map[string]string{
+			"/src.txt": "new", "/dst.txt": "old",
+		}},
+		{"overwrite", RenameOverwrite, false, true, map[string]string{
+			"/dst.txt": "new",
+		}},
+		{"overwrite without replace", RenameOverwrite, true, true, map[string]string{
+			"/dst.txt": "new",
+		}},
+		{"numbered backup", RenameNumberedBackup, true, true, map[string]string{
+			"/dst.txt": "new", "/dst.txt.~1~": "older", "/dst.txt.~2~": "old",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mfs, err := memfs.NewFS()
+			if err != nil {
+				t.Fatalf("Failed to create memfs: %v", err)
+			}
+			for name, body := range map[string]string{"/src.txt": "new", "/dst.txt": "old", "/dst.txt.~1~": "older"} {
+				f, err := mfs.Create(name)
+				if err != nil {
+					t.Fatalf("Create %s failed: %v", name, err)
+				}
+				f.Write([]byte(body))
+				f.Close()
+			}
+			var backing absfs.FileSystem = mfs
+			if tc.noReplace {
+				backing = &noReplaceFS{mfs}
+			}
+			_, client, cleanup := testServerSetupWithConfig(t, backing, &ServerConfig{RenamePolicy: tc.policy})
+			defer cleanup()
+
+			err = client.Rename("/src.txt", "/dst.txt")
+			if tc.ok && err != nil {
+				t.Fatalf("Rename failed: %v", err)
+			} else if !tc.ok && err == nil {
+				t.Fatal("Expected Rename onto an existing file to fail")
+			}
+			if _, ok := tc.want["/src.txt"]; !ok {
+				if _, err := mfs.Stat("/src.txt"); err == nil {
+					t.Error("The source still exists after the rename")
+				}
+			}
+			for name, body := range tc.want {
+				f, err := mfs.Open(name)
+				if err != nil {
+					t.Errorf("Open %s failed: %v", name, err)
+					continue
+				}
+				got, _ := io.ReadAll(f)
+				f.Close()
+				if string(got) != body {
+					t.Errorf("%s = %q, want %q", name, got, body)
+				}
+			}
+		})
+	}
+}
+
 func TestServer_Remove(t *testing.T) {
 	fs, err := memfs.NewFS()
 	if err != nil {
@@ -349,6 +473,188 @@ func TestServer_Chtimes(t *testing.T) {
 	}
 }
 
+// setstatFS records which attribute changes reach the backing filesystem.
+type setstatFS struct {
+	absfs.FileSystem
+	calls []string
+}
+
+func (fs *setstatFS) Chmod(name string, mode os.FileMode) error {
+	fs.calls = append(fs.calls, "chmod")
+	return fs.FileSystem.Chmod(name, mode)
+}
+
+func (fs *setstatFS) Chtimes(name string, atime, mtime time.Time) error {
+	fs.calls = append(fs.calls, "chtimes")
+	return fs.FileSystem.Chtimes(name, atime, mtime)
+}
+
+func (fs *setstatFS) Chown(name string, uid, gid int) error {
+	fs.calls = append(fs.calls, "chown")
+	return fs.FileSystem.Chown(name, uid, gid)
+}
+
+func (fs *setstatFS) Truncate(name string, size int64) error {
+	fs.calls = append(fs.calls, "truncate")
+	return fs.FileSystem.Truncate(name, size)
+}
+
+func TestServer_SetstatFlags(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	fs := &setstatFS{FileSystem: mfs}
+	_, client, cleanup := testServerSetup(t, fs)
+	defer cleanup()
+
+	f, err := client.Create("/attrs.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Write([]byte("hello"))
+	f.Close()
+
+	epoch := time.Unix(0, 0)
+	for _, tc := range []struct {
+		want string
+		op   func() error
+	}{
+		{"chtimes", func() error { return client.Chtimes("/attrs.txt", epoch, epoch) }},
+		{"chmod", func() error { return client.Chmod("/attrs.txt", 0600) }},
+		{"chown", func() error { return client.Chown("/attrs.txt", 0, 0) }},
+		{"truncate", func() error { return client.Truncate("/attrs.txt", 2) }},
+	} {
+		fs.calls = nil
+		if err := tc.op(); err != nil {
+			t.Fatalf("%s failed: %v", tc.want, err)
+		}
+		if len(fs.calls) != 1 || fs.calls[0] != tc.want {
+			t.Errorf("%s Setstat made calls %v", tc.want, fs.calls)
+		}
+	}
+
+	info, err := mfs.Stat("/attrs.txt")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0600 || info.Size() != 2 || !info.ModTime().Equal(epoch) {
+		t.Errorf("Attributes after Setstats: mode %v, size %d, mtime %v", info.Mode(), info.Size(), info.ModTime())
+	}
+}
+
+// countingFS counts the Stat and Open calls that reach the backing
+// filesystem.
+type countingFS struct {
+	absfs.FileSystem
+	mu           sync.Mutex
+	stats, opens int
+}
+
+func (fs *countingFS) Stat(name string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	fs.stats++
+	fs.mu.Unlock()
+	return fs.FileSystem.Stat(name)
+}
+
+func (fs *countingFS) Open(name string) (absfs.File, error) {
+	fs.mu.Lock()
+	fs.opens++
+	fs.mu.Unlock()
+	return fs.FileSystem.Open(name)
+}
+
+func (fs *countingFS) counts() (int, int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.stats, fs.opens
+}
+
+func TestServer_StatCacheTTL(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/dir", 0755)
+	fs := &countingFS{FileSystem: mfs}
+	_, client, cleanup := testServerSetupWithConfig(t, fs, &ServerConfig{StatCacheTTL: time.Minute})
+	defer cleanup()
+
+	f, err := client.Create("/dir/a.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Write([]byte("hello"))
+	f.Close()
+
+	// Repeated stats and listings within the TTL are served from the cache
+	stats, opens := fs.counts()
+	for i := 0; i < 3; i++ {
+		if info, err := client.Stat("/dir/a.txt"); err != nil || info.Size() != 5 {
+			t.Fatalf("Stat = %v, %v", info, err)
+		}
+		if entries, err := client.ReadDir("/dir"); err != nil || len(entries) != 1 {
+			t.Fatalf("ReadDir = %v, %v", entries, err)
+		}
+	}
+	if s, o := fs.counts(); s != stats+1 || o != opens+1 {
+		t.Errorf("Backing fs got %d stats and %d opens for 3 rounds, want 1 each", s-stats, o-opens)
+	}
+
+	// Changes through the server invalidate the affected entries
+	if err := client.Chmod("/dir/a.txt", 0600); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	if info, err := client.Stat("/dir/a.txt"); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Stat after Chmod = %v, %v; want mode 0600", info, err)
+	}
+	f, err = client.OpenFile("/dir/a.txt", os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	f.Write([]byte(" world"))
+	f.Close()
+	if info, err := client.Stat("/dir/a.txt"); err != nil || info.Size() != 11 {
+		t.Errorf("Stat after append = %v, %v; want size 11", info, err)
+	}
+	if err := client.Rename("/dir/a.txt", "/dir/b.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if _, err := client.Stat("/dir/a.txt"); err == nil {
+		t.Error("Stat of a renamed file hit a stale cache entry")
+	}
+	if entries, err := client.ReadDir("/dir"); err != nil || len(entries) != 1 || entries[0].Name() != "b.txt" {
+		t.Errorf("ReadDir after Rename = %v, %v; want [b.txt]", entries, err)
+	}
+}
+
+func TestServer_PrefetchRoot(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/dir", 0755)
+	f, _ := mfs.Create("/a.txt")
+	f.Close()
+	fs := &countingFS{FileSystem: mfs}
+	_, client, cleanup := testServerSetupWithConfig(t, fs, &ServerConfig{StatCacheTTL: time.Minute, PrefetchRoot: true})
+	defer cleanup()
+
+	// The root was listed when the session started
+	_, opens := fs.counts()
+	if opens == 0 {
+		t.Fatal("Session start did not list the root")
+	}
+	entries, err := client.ReadDir("/")
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("ReadDir = %v, %v; want 2 entries", entries, err)
+	}
+	if _, o := fs.counts(); o != opens {
+		t.Errorf("Initial ReadDir(\"/\") made %d backing opens, want 0", o-opens)
+	}
+}
+
 func TestServer_LargeFile(t *testing.T) {
 	fs, err := memfs.NewFS()
 	if err != nil {
@@ -436,6 +742,91 @@ func TestServer_ReadAt(t *testing.T) {
 	}
 }
 
+func TestServer_ConcurrentReaders(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	data := make([]byte, 256*1024)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	f, err := mfs.OpenFile("/shared.bin", os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Write(data)
+	f.Close()
+
+	const readers = 6
+	var wg sync.WaitGroup
+	errs := make(chan error, readers)
+	for i := 0; i < readers; i++ {
+		sshClient, err := testDialSSH(addr, "testuser", "testpass")
+		if err != nil {
+			t.Fatalf("Failed to connect SSH: %v", err)
+		}
+		defer sshClient.Close()
+		client, err := sftp.NewClient(sshClient)
+		if err != nil {
+			t.Fatalf("Failed to create SFTP client: %v", err)
+		}
+		defer client.Close()
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- readShared(client, data, i)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// readShared checks the content of /shared.bin through client: even
+// readers stream it from the start, odd readers fetch chunks of a size that
+// depends on i from the end backwards, so the handles' offsets diverge.
+func readShared(client *sftp.Client, want []byte, i int) error {
+	f, err := client.Open("/shared.bin")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if i%2 == 0 {
+		got, err := io.ReadAll(f)
+		if err != nil {
+			return fmt.Errorf("reader %d: %w", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			return fmt.Errorf("reader %d: streamed content differs", i)
+		}
+		return nil
+	}
+	chunk := 1000 * (i + 1)
+	buf := make([]byte, chunk)
+	for end := len(want); end > 0; end -= chunk {
+		off := max(end-chunk, 0)
+		n, err := f.ReadAt(buf[:end-off], int64(off))
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("reader %d: ReadAt %d: %w", i, off, err)
+		}
+		if !bytes.Equal(buf[:n], want[off:end]) {
+			return fmt.Errorf("reader %d: content at %d differs", i, off)
+		}
+	}
+	return nil
+}
+
 func TestServer_WriteAt(t *testing.T) {
 	fs, err := memfs.NewFS()
 	if err != nil {
@@ -479,6 +870,75 @@ func TestServer_WriteAt(t *testing.T) {
 	}
 }
 
+func TestServer_WriteAtGap(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+
+	data := make([]byte, 64*1024)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	half := int64(len(data) / 2)
+
+	// Each half is written over its own connection, as a resumed upload
+	// would after reconnecting
+	writeAt := func(p []byte, off int64) {
+		t.Helper()
+		sshClient, err := testDialSSH(listener.Addr().String(), "testuser", "testpass")
+		if err != nil {
+			t.Fatalf("Failed to connect SSH: %v", err)
+		}
+		defer sshClient.Close()
+		client, err := sftp.NewClient(sshClient)
+		if err != nil {
+			t.Fatalf("Failed to create SFTP client: %v", err)
+		}
+		defer client.Close()
+		f, err := client.OpenFile("/resumed.bin", os.O_WRONLY|os.O_CREATE)
+		if err != nil {
+			t.Fatalf("OpenFile failed: %v", err)
+		}
+		if _, err := f.WriteAt(p, off); err != nil {
+			f.Close()
+			t.Fatalf("WriteAt %d failed: %v", off, err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+	readBack := func() []byte {
+		t.Helper()
+		f, err := mfs.Open("/resumed.bin")
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer f.Close()
+		got, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+		return got
+	}
+
+	writeAt(data[half:], half)
+	got := readBack()
+	if int64(len(got)) != int64(len(data)) {
+		t.Fatalf("Size after writing the second half = %d, want %d", len(got), len(data))
+	}
+	if !bytes.Equal(got[:half], make([]byte, half)) {
+		t.Error("The gap before the second half is not zero-filled")
+	}
+
+	writeAt(data[:half], 0)
+	if got := readBack(); !bytes.Equal(got, data) {
+		t.Errorf("Assembled content differs (got %d bytes, want %d)", len(got), len(data))
+	}
+}
+
 func TestServer_AuthFailure(t *testing.T) {
 	fs, err := memfs.NewFS()
 	if err != nil {
@@ -733,3 +1193,2064 @@ func (fi *testFileInfo) Mode() os.FileMode  { return 0644 }
 func (fi *testFileInfo) ModTime() time.Time { return time.Time{} }
 func (fi *testFileInfo) IsDir() bool        { return false }
 func (fi *testFileInfo) Sys() interface{}   { return nil }
+
+func TestServer_RestrictToRoot(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	if err := fs.Mkdir("/data", 0755); err != nil {
+		t.Fatalf("Mkdir /data failed: %v", err)
+	}
+	if err := fs.Mkdir("/outside", 0755); err != nil {
+		t.Fatalf("Mkdir /outside failed: %v", err)
+	}
+	if err := fs.Symlink("/outside", "/data/escape"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if err := fs.Symlink("../outside/target.txt", "/data/evil.txt"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	h := newServerHandler(fs)
+	h.restrictToRoot = "/data"
+	for _, p := range []string{"/data/escape/pwned.txt", "/data/evil.txt", "/data/../outside/x"} {
+		if err := h.checkRoot(p); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("checkRoot(%q) = %v, want %v", p, err, os.ErrPermission)
+		}
+	}
+	if err := h.checkRoot("/data/new/file.txt"); err != nil {
+		t.Errorf("checkRoot inside root = %v, want nil", err)
+	}
+
+	_, client, cleanup := testServerSetupWithConfig(t, fs, &ServerConfig{RestrictToRoot: "/data"})
+	defer cleanup()
+
+	// Writing inside the root is allowed
+	f, err := client.Create("/data/ok.txt")
+	if err != nil {
+		t.Fatalf("Create inside root failed: %v", err)
+	}
+	f.Write([]byte("ok"))
+	f.Close()
+
+	// Writing through escaping symlinks is denied
+	for _, p := range []string{"/data/escape/pwned.txt", "/data/evil.txt", "/outside/direct.txt"} {
+		f, err := client.Create(p)
+		if err == nil {
+			f.Close()
+			t.Errorf("Create(%q) should have been denied", p)
+		}
+	}
+
+	if _, err := fs.Stat("/outside/pwned.txt"); err == nil {
+		t.Error("File was written outside the root")
+	}
+	if _, err := fs.Stat("/outside/target.txt"); err == nil {
+		t.Error("Symlink target outside the root was created")
+	}
+}
+
+func TestServer_RestrictToRootCommands(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	for _, dir := range []string{"/data", "/outside", "/outside/dir"} {
+		if err := fs.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("Mkdir %s failed: %v", dir, err)
+		}
+	}
+	for _, name := range []string{"/data/ok.txt", "/outside/victim.txt"} {
+		f, err := fs.Create(name)
+		if err != nil {
+			t.Fatalf("Create %s failed: %v", name, err)
+		}
+		f.Close()
+	}
+	if err := fs.Chmod("/outside/victim.txt", 0644); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	if err := fs.Symlink("/outside", "/data/escape"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	_, client, cleanup := testServerSetupWithConfig(t, fs, &ServerConfig{RestrictToRoot: "/data"})
+	defer cleanup()
+
+	tests := []struct {
+		name string
+		op   func() error
+	}{
+		{"Setstat", func() error { return client.Chmod("/data/escape/victim.txt", 0777) }},
+		{"Rename from", func() error { return client.Rename("/data/escape/victim.txt", "/data/stolen.txt") }},
+		{"Rename to", func() error { return client.Rename("/data/ok.txt", "/data/escape/planted.txt") }},
+		{"Rmdir", func() error { return client.RemoveDirectory("/data/escape/dir") }},
+		{"Mkdir", func() error { return client.Mkdir("/data/escape/newdir") }},
+		{"Remove", func() error { return client.Remove("/data/escape/victim.txt") }},
+		{"Symlink target", func() error { return client.Symlink("/outside/victim.txt", "/data/peek") }},
+		{"Symlink relative target", func() error { return client.Symlink("../outside/victim.txt", "/data/peek") }},
+		{"Symlink link", func() error { return client.Symlink("/data/ok.txt", "/data/escape/link") }},
+	}
+	for _, tt := range tests {
+		if err := tt.op(); err == nil {
+			t.Errorf("%s through escaping symlink should have been denied", tt.name)
+		}
+	}
+
+	// Nothing outside the root changed
+	info, err := fs.Stat("/outside/victim.txt")
+	if err != nil {
+		t.Fatalf("Stat victim failed: %v", err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("Victim mode = %v, want 0644", info.Mode().Perm())
+	}
+	if _, err := fs.Stat("/outside/dir"); err != nil {
+		t.Errorf("Directory outside the root was removed: %v", err)
+	}
+	for _, p := range []string{"/outside/planted.txt", "/outside/newdir", "/outside/link", "/data/stolen.txt", "/data/peek"} {
+		if _, err := fs.Lstat(p); err == nil {
+			t.Errorf("%s was created", p)
+		}
+	}
+
+	// The escaping link itself lies inside the root and can be removed
+	if err := client.Remove("/data/escape"); err != nil {
+		t.Errorf("Remove of the link itself failed: %v", err)
+	}
+	if _, err := fs.Stat("/outside/victim.txt"); err != nil {
+		t.Errorf("Removing the link removed its target: %v", err)
+	}
+}
+
+func TestPathWithin(t *testing.T) {
+	tests := []struct {
+		root, p string
+		want    bool
+	}{
+		{"/", "/anything", true},
+		{"/data", "/data", true},
+		{"/data", "/data/file", true},
+		{"/data", "/database", false},
+		{"/data", "/other", false},
+	}
+	for _, tt := range tests {
+		if got := pathWithin(tt.root, tt.p); got != tt.want {
+			t.Errorf("pathWithin(%q, %q) = %v, want %v", tt.root, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestServer_NameEncoding(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+
+	// The wire encoding prefixes every name with "wire-"
+	_, client, cleanup := testServerSetupWithConfig(t, fs, &ServerConfig{
+		NameDecoder: func(s string) string { return strings.TrimPrefix(s, "wire-") },
+		NameEncoder: func(s string) string { return "wire-" + s },
+	})
+	defer cleanup()
+
+	if err := client.Mkdir("/wire-docs"); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	f, err := client.Create("/wire-docs/wire-a.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Write([]byte("encoded"))
+	f.Close()
+
+	// The backing fs sees decoded names
+	if _, err := fs.Stat("/docs/a.txt"); err != nil {
+		t.Fatalf("Backing file not created under decoded name: %v", err)
+	}
+
+	// Listings report encoded names
+	entries, err := client.ReadDir("/wire-docs")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "wire-a.txt" {
+		t.Fatalf("ReadDir returned %v, want [wire-a.txt]", entries)
+	}
+
+	// And the encoded name opens correctly
+	f, err = client.Open("/wire-docs/" + entries[0].Name())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "encoded" {
+		t.Errorf("Content = %q, want %q", data, "encoded")
+	}
+
+	info, err := client.Stat("/wire-docs/wire-a.txt")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Name() != "wire-a.txt" {
+		t.Errorf("Stat name = %q, want %q", info.Name(), "wire-a.txt")
+	}
+}
+
+func TestServer_AtomicUploads(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+
+	_, client, cleanup := testServerSetupWithConfig(t, fs, &ServerConfig{AtomicUploads: true})
+	defer cleanup()
+
+	for _, content := range []string{"first upload", "second, longer upload"} {
+		f, err := client.Create("/upload.txt")
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+
+		// Mid-upload the target holds the previous content, if any
+		if data, err := fs.ReadFile("/upload.txt"); err == nil && string(data) == content {
+			t.Errorf("Partial upload visible under the final name")
+		}
+
+		if err := f.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		data, err := fs.ReadFile("/upload.txt")
+		if err != nil {
+			t.Fatalf("ReadFile after close failed: %v", err)
+		}
+		if string(data) != content {
+			t.Errorf("Content = %q, want %q", data, content)
+		}
+	}
+
+	// No temporary files are left behind
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the uploaded file, got %d entries", len(entries))
+	}
+}
+
+func TestServer_AtomicUploadsNotVisible(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+
+	_, client, cleanup := testServerSetupWithConfig(t, fs, &ServerConfig{AtomicUploads: true})
+	defer cleanup()
+
+	f, err := client.Create("/new.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Write([]byte("partial"))
+
+	if _, err := fs.Stat("/new.txt"); err == nil {
+		t.Error("Final path exists before the upload was closed")
+	}
+
+	f.Close()
+	if _, err := fs.Stat("/new.txt"); err != nil {
+		t.Errorf("Final path missing after close: %v", err)
+	}
+}
+
+func TestServer_AtomicUploadsCreateMode(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{AtomicUploads: true})
+	defer listener.Close()
+
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+
+	// OpenFile sets the mode on the open handle, before the target exists
+	f, err := fs.OpenFile("/private.txt", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	if _, err := f.Write([]byte("secret")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	info, err := mfs.Stat("/private.txt")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestServer_OpenFileCreateExistingKeepsMode(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+
+	// The first open creates the file and applies perm, the second finds
+	// it through the failed O_EXCL attempt and leaves its mode alone
+	for _, perm := range []os.FileMode{0600, 0644} {
+		f, err := fs.OpenFile("/private.txt", os.O_CREATE|os.O_WRONLY, perm)
+		if err != nil {
+			t.Fatalf("OpenFile(%v) failed: %v", perm, err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		info, err := mfs.Stat("/private.txt")
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("After OpenFile(%v): mode = %v, want 0600", perm, info.Mode().Perm())
+		}
+	}
+}
+
+func TestServer_AtomicUploadsExclusive(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	writeFile := func(name, data string) {
+		t.Helper()
+		f, err := fs.Create(name)
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		f.Write([]byte(data))
+		f.Close()
+	}
+	writeFile("/existing.txt", "original")
+
+	_, client, cleanup := testServerSetupWithConfig(t, fs, &ServerConfig{AtomicUploads: true})
+	defer cleanup()
+
+	// O_EXCL fails for an existing target, with or without O_TRUNC
+	for _, flag := range []int{os.O_EXCL, os.O_EXCL | os.O_TRUNC} {
+		f, err := client.OpenFile("/existing.txt", os.O_WRONLY|os.O_CREATE|flag)
+		if err == nil {
+			f.Write([]byte("replaced"))
+			f.Close()
+			t.Errorf("OpenFile with flags %#x succeeded on an existing file", flag)
+		}
+	}
+	data, err := fs.ReadFile("/existing.txt")
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if string(data) != "original" {
+		t.Errorf("Content = %q, want %q", data, "original")
+	}
+
+	// A target created while an O_EXCL upload is open is not overwritten
+	f, err := client.OpenFile("/racy.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_TRUNC)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	f.Write([]byte("upload"))
+	writeFile("/racy.txt", "winner")
+	if err := f.Close(); err == nil {
+		t.Error("Close succeeded although the target was created meanwhile")
+	}
+	data, err = fs.ReadFile("/racy.txt")
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if string(data) != "winner" {
+		t.Errorf("Content = %q, want %q", data, "winner")
+	}
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Expected no temporary files left, got %d entries", len(entries))
+	}
+}
+
+func TestServerFile_AtomicUploadAbort(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	h := newServerHandler(fs)
+
+	sf, err := h.openTempUpload(context.Background(), "/target.txt", false)
+	if err != nil {
+		t.Fatalf("openTempUpload failed: %v", err)
+	}
+	sf.WriteAt([]byte("data"), 0)
+	sf.failed = true
+	sf.Close()
+
+	if _, err := fs.Stat(sf.tmpPath); err == nil {
+		t.Error("Temporary file was not removed after a failed upload")
+	}
+	if _, err := fs.Stat("/target.txt"); err == nil {
+		t.Error("Failed upload was moved into place")
+	}
+}
+
+// temporaryError is a net.Error that reports itself as temporary.
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary accept failure" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// scriptedListener returns queued errors from Accept before handing out
+// connections sent on conns.
+type scriptedListener struct {
+	errs   []error
+	conns  chan net.Conn
+	closed chan struct{}
+}
+
+func newScriptedListener(errs ...error) *scriptedListener {
+	return &scriptedListener{errs: errs, conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *scriptedListener) Accept() (net.Conn, error) {
+	if len(l.errs) > 0 {
+		err := l.errs[0]
+		l.errs = l.errs[1:]
+		return nil, err
+	}
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *scriptedListener) Close() error   { close(l.closed); return nil }
+func (l *scriptedListener) Addr() net.Addr { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+
+func TestServer_ServeRetriesTemporaryErrors(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	server := NewServer(fs, &ServerConfig{
+		HostKeys:     []ssh.Signer{testHostKey(t)},
+		NoClientAuth: true,
+	})
+
+	listener := newScriptedListener(temporaryError{})
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(listener) }()
+
+	// After the temporary error the server still accepts and handles
+	// connections: it sends its version banner first.
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	select {
+	case listener.conns <- serverConn:
+	case err := <-done:
+		t.Fatalf("Serve returned after a temporary error: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not accept after a temporary error")
+	}
+
+	clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	banner := make([]byte, len("SSH-2.0-sftpfs"))
+	if _, err := io.ReadFull(clientConn, banner); err != nil {
+		t.Fatalf("Reading banner failed: %v", err)
+	}
+	if string(banner) != "SSH-2.0-sftpfs" {
+		t.Errorf("Banner = %q, want %q", banner, "SSH-2.0-sftpfs")
+	}
+
+	listener.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Serve returned %v, want %v", err, net.ErrClosed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after the listener closed")
+	}
+}
+
+func TestServer_MalformedSubsystem(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, fs, &ServerConfig{})
+	defer listener.Close()
+
+	sshClient, err := testDialSSH(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Failed to connect SSH: %v", err)
+	}
+	defer sshClient.Close()
+
+	channel, _, err := sshClient.OpenChannel("session", nil)
+	if err != nil {
+		t.Fatalf("Failed to open session: %v", err)
+	}
+	defer channel.Close()
+	for _, payload := range [][]byte{nil, {0, 0}, {0, 0, 0, 9, 's', 'f', 't', 'p'}} {
+		ok, err := channel.SendRequest("subsystem", true, payload)
+		if err != nil {
+			t.Fatalf("SendRequest(%v) failed: %v", payload, err)
+		}
+		if ok {
+			t.Errorf("Subsystem request with payload %v was accepted", payload)
+		}
+	}
+
+	// The server keeps serving the same connection
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		t.Fatalf("SFTP session after malformed requests failed: %v", err)
+	}
+	defer client.Close()
+	if _, err := client.Getwd(); err != nil {
+		t.Errorf("Getwd failed: %v", err)
+	}
+}
+
+func TestServer_ChannelHandler(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+
+	seen := make(chan string, 1)
+	_, listener := testServerListen(t, fs, &ServerConfig{
+		ChannelHandler: func(ch ssh.NewChannel) {
+			seen <- ch.ChannelType()
+			ch.Reject(ssh.Prohibited, "port forwarding disabled")
+		},
+	})
+	defer listener.Close()
+
+	sshClient, err := testDialSSH(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Failed to connect SSH: %v", err)
+	}
+	defer sshClient.Close()
+
+	_, _, err = sshClient.OpenChannel("direct-tcpip", nil)
+	var openErr *ssh.OpenChannelError
+	if !errors.As(err, &openErr) {
+		t.Fatalf("Expected OpenChannelError, got %v", err)
+	}
+	if openErr.Reason != ssh.Prohibited || openErr.Message != "port forwarding disabled" {
+		t.Errorf("Rejected with %v %q", openErr.Reason, openErr.Message)
+	}
+
+	select {
+	case typ := <-seen:
+		if typ != "direct-tcpip" {
+			t.Errorf("Handler saw channel type %q, want %q", typ, "direct-tcpip")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ChannelHandler was not called")
+	}
+
+	// Session channels still serve SFTP
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		t.Fatalf("SFTP session failed: %v", err)
+	}
+	client.Close()
+}
+
+func TestServer_UnknownChannelRejectedByDefault(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+
+	_, listener := testServerListen(t, fs, &ServerConfig{})
+	defer listener.Close()
+
+	sshClient, err := testDialSSH(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Failed to connect SSH: %v", err)
+	}
+	defer sshClient.Close()
+
+	_, _, err = sshClient.OpenChannel("direct-tcpip", nil)
+	var openErr *ssh.OpenChannelError
+	if !errors.As(err, &openErr) || openErr.Reason != ssh.UnknownChannelType {
+		t.Errorf("Expected UnknownChannelType rejection, got %v", err)
+	}
+}
+
+func TestServer_FilesystemForUser(t *testing.T) {
+	tenants := make(map[string]absfs.FileSystem)
+	for _, user := range []string{"alice", "bob"} {
+		fs, err := memfs.NewFS()
+		if err != nil {
+			t.Fatalf("Failed to create memfs: %v", err)
+		}
+		f, err := fs.Create("/" + user + ".txt")
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		f.Write([]byte(user))
+		f.Close()
+		tenants[user] = fs
+	}
+
+	_, listener := testServerListen(t, nil, &ServerConfig{
+		PasswordCallback: MultiUserPasswordAuth(map[string]string{
+			"alice":   "pw",
+			"bob":     "pw",
+			"mallory": "pw",
+		}),
+		FilesystemForUser: func(user string) (absfs.FileSystem, error) {
+			fs, ok := tenants[user]
+			if !ok {
+				return nil, os.ErrPermission
+			}
+			return fs, nil
+		},
+	})
+	defer listener.Close()
+
+	for user := range tenants {
+		sshClient, err := testDialSSH(listener.Addr().String(), user, "pw")
+		if err != nil {
+			t.Fatalf("%s: failed to connect SSH: %v", user, err)
+		}
+		client, err := sftp.NewClient(sshClient)
+		if err != nil {
+			sshClient.Close()
+			t.Fatalf("%s: failed to create SFTP client: %v", user, err)
+		}
+
+		entries, err := client.ReadDir("/")
+		if err != nil {
+			t.Fatalf("%s: ReadDir failed: %v", user, err)
+		}
+		if len(entries) != 1 || entries[0].Name() != user+".txt" {
+			t.Errorf("%s sees %v, want only %s.txt", user, entries, user)
+		}
+
+		client.Close()
+		sshClient.Close()
+	}
+
+	// A user without a filesystem cannot start a session
+	sshClient, err := testDialSSH(listener.Addr().String(), "mallory", "pw")
+	if err == nil {
+		if _, err := sftp.NewClient(sshClient); err == nil {
+			t.Error("Expected session for an unmapped user to fail")
+		}
+		sshClient.Close()
+	}
+}
+
+func TestServer_Sessions(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	server, listener := testServerListen(t, fs, &ServerConfig{
+		PasswordCallback: MultiUserPasswordAuth(map[string]string{
+			"alice": "pw",
+			"bob":   "pw",
+		}),
+	})
+	defer listener.Close()
+
+	var clients []*ssh.Client
+	for _, user := range []string{"alice", "bob"} {
+		client, err := testDialSSH(listener.Addr().String(), user, "pw")
+		if err != nil {
+			t.Fatalf("%s: failed to connect SSH: %v", user, err)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+	}
+
+	sessions := waitSessions(server, 2)
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d", len(sessions))
+	}
+	for i, want := range []string{"alice", "bob"} {
+		sess := sessions[i]
+		if sess.User != want {
+			t.Errorf("Session %d user = %q, want %q", i, sess.User, want)
+		}
+		if sess.ID == "" || sess.RemoteAddr == nil || sess.ConnectedAt.IsZero() {
+			t.Errorf("Session %d incomplete: %+v", i, sess)
+		}
+		if sess.BytesIn == 0 || sess.BytesOut == 0 {
+			t.Errorf("Session %d reports no traffic: %+v", i, sess)
+		}
+	}
+	if sessions[0].ID == sessions[1].ID {
+		t.Errorf("Sessions share ID %q", sessions[0].ID)
+	}
+
+	clients[0].Close()
+	sessions = waitSessions(server, 1)
+	if len(sessions) != 1 || sessions[0].User != "bob" {
+		t.Errorf("After alice disconnects, sessions = %+v", sessions)
+	}
+}
+
+// waitSessions polls until server reports n sessions or a timeout passes,
+// and returns the last snapshot.
+func waitSessions(server *Server, n int) []SessionInfo {
+	deadline := time.Now().Add(5 * time.Second)
+	sessions := server.Sessions()
+	for len(sessions) != n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		sessions = server.Sessions()
+	}
+	return sessions
+}
+
+func TestServer_Disconnect(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	server, client, cleanup := testServerSetup(t, fs)
+	defer cleanup()
+
+	if _, err := client.ReadDir("/"); err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+
+	sessions := waitSessions(server, 1)
+	if len(sessions) != 1 {
+		t.Fatalf("Expected 1 session, got %d", len(sessions))
+	}
+	if err := server.Disconnect("no-such-session"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+	if err := server.Disconnect(sessions[0].ID); err != nil {
+		t.Fatalf("Disconnect failed: %v", err)
+	}
+
+	if _, err := client.ReadDir("/"); err == nil {
+		t.Error("Expected ReadDir to fail after disconnect")
+	}
+	if n := len(waitSessions(server, 0)); n != 0 {
+		t.Errorf("Expected no sessions after disconnect, got %d", n)
+	}
+}
+
+func TestServer_RemoveDirNotEmpty(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/full", 0755)
+	f, err := mfs.Create("/full/file.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Close()
+
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+
+	if err := fs.Remove("/full"); !errors.Is(err, ErrDirNotEmpty) {
+		t.Fatalf("Expected ErrDirNotEmpty, got %v", err)
+	}
+	if err := fs.Remove("/full/file.txt"); err != nil {
+		t.Fatalf("Remove file failed: %v", err)
+	}
+	if err := fs.Remove("/full"); err != nil {
+		t.Errorf("Remove empty directory failed: %v", err)
+	}
+}
+
+func TestServer_Root(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	fs.Mkdir("/data", 0755)
+	for name, body := range map[string]string{"/data/x.txt": "inside", "/secret.txt": "outside"} {
+		f, err := fs.Create(name)
+		if err != nil {
+			t.Fatalf("Create %s failed: %v", name, err)
+		}
+		f.Write([]byte(body))
+		f.Close()
+	}
+	if err := fs.Symlink("/secret.txt", "/data/escape"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if err := fs.Symlink("/data/x.txt", "/data/link"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	_, client, cleanup := testServerSetupWithConfig(t, fs, &ServerConfig{Root: "/data"})
+	defer cleanup()
+
+	// Client paths map into the root
+	f, err := client.Open("/x.txt")
+	if err != nil {
+		t.Fatalf("Open /x.txt failed: %v", err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "inside" {
+		t.Errorf("Read %q, want %q", data, "inside")
+	}
+
+	f, err = client.Create("/new.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Write([]byte("new"))
+	f.Close()
+	if _, err := fs.Stat("/data/new.txt"); err != nil {
+		t.Errorf("Expected /data/new.txt in backing fs: %v", err)
+	}
+
+	// Climbing above the root stays inside it
+	for _, p := range []string{"/../secret.txt", "../secret.txt", "/a/../../secret.txt"} {
+		if f, err := client.Open(p); err == nil {
+			data, _ := io.ReadAll(f)
+			f.Close()
+			t.Errorf("Open(%q) read %q, want error", p, data)
+		}
+	}
+
+	// Symlinks leading outside the root are refused
+	if f, err := client.Open("/escape"); err == nil {
+		f.Close()
+		t.Error("Open through escaping symlink should fail")
+	}
+
+	// Symlinks inside the root still work
+	f, err = client.Open("/link")
+	if err != nil {
+		t.Fatalf("Open through symlink inside root failed: %v", err)
+	}
+	f.Close()
+}
+
+func TestServer_RootListings(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	fs.MkdirAll("/data", 0755)
+	fs.MkdirAll("/outside", 0755)
+	for _, name := range []string{"/data/x.txt", "/outside/secret.txt"} {
+		f, err := fs.Create(name)
+		if err != nil {
+			t.Fatalf("Create %s failed: %v", name, err)
+		}
+		f.Close()
+	}
+	if err := fs.Symlink("/outside", "/data/escape"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	_, client, cleanup := testServerSetupWithConfig(t, fs, &ServerConfig{Root: "/data"})
+	defer cleanup()
+
+	// Listing and stat through the escaping link are refused
+	if entries, err := client.ReadDir("/escape"); err == nil {
+		t.Errorf("ReadDir through escaping symlink returned %d entries, want error", len(entries))
+	}
+	for _, p := range []string{"/escape", "/escape/secret.txt"} {
+		if _, err := client.Stat(p); err == nil {
+			t.Errorf("Stat(%q) through escaping symlink should fail", p)
+		}
+	}
+	if _, err := client.Lstat("/escape/secret.txt"); err == nil {
+		t.Error("Lstat below an escaping symlink should fail")
+	}
+	if _, err := client.ReadLink("/escape/secret.txt"); err == nil {
+		t.Error("ReadLink below an escaping symlink should fail")
+	}
+
+	// The link itself and the rest of the root can still be inspected
+	if info, err := client.Lstat("/escape"); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Lstat of the link = %v, %v; want a symlink", info, err)
+	}
+	if _, err := client.ReadLink("/escape"); err != nil {
+		t.Errorf("ReadLink of the link failed: %v", err)
+	}
+	if _, err := client.Stat("/x.txt"); err != nil {
+		t.Errorf("Stat inside the root failed: %v", err)
+	}
+	if entries, err := client.ReadDir("/"); err != nil || len(entries) != 2 {
+		t.Errorf("ReadDir of the root = %d entries, %v; want 2", len(entries), err)
+	}
+}
+
+func TestServer_RootReadlink(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	fs.MkdirAll("/data/sub", 0755)
+	f, err := fs.Create("/data/x.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Close()
+	links := map[string]string{
+		"/data/abs":  "/data/x.txt",
+		"/data/top":  "/data",
+		"/data/rel":  "sub/../x.txt",
+		"/data/away": "/elsewhere/y.txt",
+	}
+	for link, target := range links {
+		if err := fs.Symlink(target, link); err != nil {
+			t.Fatalf("Symlink %s failed: %v", link, err)
+		}
+	}
+
+	_, client, cleanup := testServerSetupWithConfig(t, fs, &ServerConfig{Root: "/data"})
+	defer cleanup()
+
+	// Absolute targets inside the root are reported as clients see them
+	for link, want := range map[string]string{
+		"/abs":  "/x.txt",
+		"/top":  "/",
+		"/rel":  "sub/../x.txt",
+		"/away": "/elsewhere/y.txt",
+	} {
+		got, err := client.ReadLink(link)
+		if err != nil {
+			t.Errorf("ReadLink(%q) failed: %v", link, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ReadLink(%q) = %q, want %q", link, got, want)
+		}
+	}
+}
+
+func TestServer_HomeDir(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	fs.MkdirAll("/srv/home/testuser", 0755)
+
+	_, client, cleanup := testServerSetupWithConfig(t, fs, &ServerConfig{
+		Root:    "/srv",
+		HomeDir: func(user string) string { return "/home/" + user },
+	})
+	defer cleanup()
+
+	home, err := client.RealPath(".")
+	if err != nil {
+		t.Fatalf("RealPath failed: %v", err)
+	}
+	if home != "/home/testuser" {
+		t.Errorf("RealPath(\".\") = %q, want %q", home, "/home/testuser")
+	}
+
+	// Relative paths resolve against the home directory inside the root
+	f, err := client.Create("notes.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Close()
+	if _, err := fs.Stat("/srv/home/testuser/notes.txt"); err != nil {
+		t.Errorf("Expected /srv/home/testuser/notes.txt in backing fs: %v", err)
+	}
+}
+
+func TestNewServerHandlerSub(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	fs.Mkdir("/data", 0755)
+	f, _ := fs.Create("/data/x.txt")
+	f.Write([]byte("inside"))
+	f.Close()
+
+	handlers := NewServerHandlerSub(fs, "/data")
+	r, err := handlers.FileGet.Fileread(sftp.NewRequest("Get", "/x.txt"))
+	if err != nil {
+		t.Fatalf("Fileread failed: %v", err)
+	}
+	buf := make([]byte, 6)
+	if _, err := r.ReadAt(buf, 0); err != nil && err != io.EOF {
+		t.Fatalf("ReadAt failed: %v", err)
+	}
+	if string(buf) != "inside" {
+		t.Errorf("Read %q, want %q", buf, "inside")
+	}
+	r.(io.Closer).Close()
+
+	if _, err := handlers.FileGet.Fileread(sftp.NewRequest("Get", "/../data/../x.txt")); err != nil {
+		t.Errorf("Escaping path should resolve inside the root: %v", err)
+	}
+}
+
+func TestServer_ServeAll(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	server := NewServer(fs, &ServerConfig{
+		HostKeys:         []ssh.Signer{testHostKey(t)},
+		PasswordCallback: SimplePasswordAuth("testuser", "testpass"),
+	})
+
+	var listeners []net.Listener
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to create listener: %v", err)
+		}
+		listeners = append(listeners, l)
+	}
+	done := make(chan error, 1)
+	go func() { done <- server.ServeAll(listeners...) }()
+
+	for _, l := range listeners {
+		sshClient, err := testDialSSH(l.Addr().String(), "testuser", "testpass")
+		if err != nil {
+			t.Fatalf("%s: failed to connect SSH: %v", l.Addr(), err)
+		}
+		client, err := sftp.NewClient(sshClient)
+		if err != nil {
+			t.Fatalf("%s: failed to create SFTP client: %v", l.Addr(), err)
+		}
+		if _, err := client.Stat("/"); err != nil {
+			t.Errorf("%s: Stat failed: %v", l.Addr(), err)
+		}
+		client.Close()
+		sshClient.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != ErrServerClosed {
+			t.Errorf("ServeAll returned %v, want ErrServerClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeAll did not return after Shutdown")
+	}
+	for _, l := range listeners {
+		if _, err := testDialSSH(l.Addr().String(), "testuser", "testpass"); err == nil {
+			t.Errorf("%s: connection accepted after Shutdown", l.Addr())
+		}
+	}
+}
+
+func TestServer_ServeAllStopsOnError(t *testing.T) {
+	server := NewServer(nil, &ServerConfig{HostKeys: []ssh.Signer{testHostKey(t)}})
+
+	good, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	bad, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	bad.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- server.ServeAll(good, bad) }()
+	select {
+	case err := <-done:
+		if err == nil || err == ErrServerClosed {
+			t.Errorf("Expected the failed listener's error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeAll did not return after a listener failed")
+	}
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	server, client, cleanup := testServerSetup(t, fs)
+	defer cleanup()
+	waitSessions(server, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := client.Stat("/"); err == nil {
+		t.Error("Expected remaining session to be disconnected")
+	}
+}
+
+func TestServer_Run(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	var logs syncBuffer
+	server := NewServer(mfs, &ServerConfig{
+		HostKeys:         []ssh.Signer{testHostKey(t)},
+		PasswordCallback: SimplePasswordAuth("testuser", "testpass"),
+		Logger:           slog.New(slog.NewTextHandler(&logs, nil)),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx, "127.0.0.1:0")
+	}()
+
+	// The bound port is only known from the log
+	var addr string
+	for deadline := time.Now().Add(2 * time.Second); addr == "" && time.Now().Before(deadline); {
+		for _, field := range strings.Fields(logs.String()) {
+			if strings.HasPrefix(field, "addr=") {
+				addr = strings.TrimPrefix(field, "addr=")
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if addr == "" {
+		t.Fatalf("Run did not log its address: %q", logs.String())
+	}
+
+	client, err := Dial(addr, "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	if err := client.WriteFile("/hello.txt", []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	client.Close()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run = %v, want nil after cancellation", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+	if _, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		t.Error("Listener still accepts connections after Run returned")
+	}
+}
+
+// slowFS is a ContextFileSystem whose file reads block until the context
+// they were opened with is cancelled.
+type slowFS struct {
+	absfs.FileSystem
+	once      sync.Once
+	reading   chan struct{}
+	cancelled chan struct{}
+}
+
+func (fs *slowFS) OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (absfs.File, error) {
+	f, err := fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &slowFile{File: f, ctx: ctx, fs: fs}, nil
+}
+
+type slowFile struct {
+	absfs.File
+	ctx context.Context
+	fs  *slowFS
+}
+
+func (f *slowFile) Read(p []byte) (int, error) {
+	f.fs.once.Do(func() { close(f.fs.reading) })
+	<-f.ctx.Done()
+	select {
+	case <-f.fs.cancelled:
+	default:
+		close(f.fs.cancelled)
+	}
+	return 0, f.ctx.Err()
+}
+
+func TestServer_RequestContextCancelledOnDisconnect(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	f, _ := mfs.Create("/slow.txt")
+	f.Write([]byte("data"))
+	f.Close()
+	fs := &slowFS{FileSystem: mfs, reading: make(chan struct{}), cancelled: make(chan struct{})}
+
+	_, listener := testServerListen(t, fs, &ServerConfig{})
+	defer listener.Close()
+
+	sshClient, err := testDialSSH(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Failed to connect SSH: %v", err)
+	}
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		t.Fatalf("Failed to create SFTP client: %v", err)
+	}
+	rf, err := client.Open("/slow.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	go rf.Read(make([]byte, 4))
+
+	select {
+	case <-fs.reading:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Backing read never started")
+	}
+	sshClient.Close()
+
+	select {
+	case <-fs.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Backing read was not cancelled after the client disconnected")
+	}
+}
+
+func TestServer_ReportedCapacity(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, client, cleanup := testServerSetupWithConfig(t, fs, &ServerConfig{
+		ReportedCapacity: &StatVFSInfo{
+			TotalBytes: 10 << 30,
+			FreeBytes:  4 << 30,
+			TotalFiles: 1000,
+			FreeFiles:  900,
+		},
+	})
+	defer cleanup()
+
+	st, err := client.StatVFS("/")
+	if err != nil {
+		t.Fatalf("StatVFS failed: %v", err)
+	}
+	if st.Bsize != 4096 {
+		t.Errorf("Bsize = %d, want 4096", st.Bsize)
+	}
+	if st.TotalSpace() != 10<<30 {
+		t.Errorf("TotalSpace = %d, want %d", st.TotalSpace(), uint64(10<<30))
+	}
+	if st.FreeSpace() != 4<<30 {
+		t.Errorf("FreeSpace = %d, want %d", st.FreeSpace(), uint64(4<<30))
+	}
+	if st.Files != 1000 || st.Ffree != 900 {
+		t.Errorf("Files = %d/%d, want 1000/900", st.Ffree, st.Files)
+	}
+	if st.Namemax != 255 {
+		t.Errorf("Namemax = %d, want 255", st.Namemax)
+	}
+}
+
+func TestServer_StatVFSUnsupportedByDefault(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, client, cleanup := testServerSetup(t, fs)
+	defer cleanup()
+
+	if _, err := client.StatVFS("/"); err == nil {
+		t.Error("Expected StatVFS to fail without a reported capacity")
+	}
+}
+
+// slowStatFS delays every Stat by delay.
+type slowStatFS struct {
+	absfs.FileSystem
+	delay time.Duration
+}
+
+func (fs *slowStatFS) Stat(name string) (os.FileInfo, error) {
+	time.Sleep(fs.delay)
+	return fs.FileSystem.Stat(name)
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestServer_SlowThreshold(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	f, _ := mfs.Create("/slow.txt")
+	f.Close()
+
+	var logs syncBuffer
+	_, client, cleanup := testServerSetupWithConfig(t, &slowStatFS{FileSystem: mfs, delay: 50 * time.Millisecond}, &ServerConfig{
+		Logger:        slog.New(slog.NewTextHandler(&logs, nil)),
+		SlowThreshold: 10 * time.Millisecond,
+	})
+	defer cleanup()
+
+	if _, err := client.Stat("/slow.txt"); err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+
+	out := logs.String()
+	for _, want := range []string{"slow sftp operation", "method=Stat", "path=/slow.txt", "duration="} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Log %q does not contain %q", out, want)
+		}
+	}
+}
+
+func TestServer_SlowThresholdFastOpsNotLogged(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	var logs syncBuffer
+	_, client, cleanup := testServerSetupWithConfig(t, fs, &ServerConfig{
+		Logger:        slog.New(slog.NewTextHandler(&logs, nil)),
+		SlowThreshold: time.Minute,
+	})
+	defer cleanup()
+
+	if _, err := client.ReadDir("/"); err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if out := logs.String(); out != "" {
+		t.Errorf("Unexpected log output: %q", out)
+	}
+}
+
+func TestServer_NoFilesystem(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	for _, tc := range []struct {
+		name    string
+		fs      absfs.FileSystem
+		forUser func(string) (absfs.FileSystem, error)
+	}{
+		{"nil fs", nil, nil},
+		{"nil for user", mfs, func(string) (absfs.FileSystem, error) { return nil, nil }},
+	} {
+		name := tc.name
+		var logs syncBuffer
+		config := &ServerConfig{Logger: slog.New(slog.NewTextHandler(&logs, nil)), FilesystemForUser: tc.forUser}
+		_, listener := testServerListen(t, tc.fs, config)
+
+		if client, err := Dial(listener.Addr().String(), "testuser", "testpass"); err == nil {
+			client.Close()
+			t.Errorf("%s: Dial succeeded without a filesystem", name)
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for !strings.Contains(logs.String(), errNoFilesystem.Error()) && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if out := logs.String(); !strings.Contains(out, "sftp session setup failed") || !strings.Contains(out, "user=testuser") {
+			t.Errorf("%s: log %q does not report the failed session", name, out)
+		}
+		listener.Close()
+	}
+}
+
+func TestServer_PathLimits(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, client, cleanup := testServerSetupWithConfig(t, mfs, &ServerConfig{MaxPathDepth: 3, MaxNameLength: 16})
+	defer cleanup()
+
+	if err := client.MkdirAll("/a/b"); err != nil {
+		t.Fatalf("MkdirAll within the limits failed: %v", err)
+	}
+	f, err := client.Create("/a/b/ok.txt")
+	if err != nil {
+		t.Fatalf("Create within the limits failed: %v", err)
+	}
+	f.Close()
+
+	long := strings.Repeat("x", 17)
+	for name, op := range map[string]func() error{
+		"deep stat":   func() error { _, err := client.Stat("/a/b/c/d"); return err },
+		"deep mkdir":  func() error { return client.Mkdir("/a/b/c/d") },
+		"long create": func() error { _, err := client.Create("/a/" + long); return err },
+		"long rename": func() error { return client.Rename("/a/b/ok.txt", "/a/"+long) },
+		"long list":   func() error { _, err := client.ReadDir("/" + long); return err },
+	} {
+		err := op()
+		var status *sftp.StatusError
+		if !errors.As(err, &status) || status.FxCode() != sftp.ErrSSHFxFailure {
+			t.Errorf("%s: got %v, want a failure status", name, err)
+		}
+	}
+	if _, err := mfs.Stat("/a/" + long); err == nil {
+		t.Error("Over-length name reached the backing filesystem")
+	}
+	if _, err := mfs.Stat("/a/b/ok.txt"); err != nil {
+		t.Errorf("Rejected rename moved the file: %v", err)
+	}
+}
+
+// linkFS adds hard links to a filesystem by resolving each link name to the
+// path it was linked from.
+type linkFS struct {
+	absfs.FileSystem
+	mu    sync.Mutex
+	links map[string]string
+}
+
+func (fs *linkFS) Link(oldname, newname string) error {
+	if _, err := fs.Stat(oldname); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.links == nil {
+		fs.links = make(map[string]string)
+	}
+	fs.links[newname] = fs.resolveLocked(oldname)
+	return nil
+}
+
+func (fs *linkFS) resolveLocked(name string) string {
+	if target, ok := fs.links[name]; ok {
+		return target
+	}
+	return name
+}
+
+func (fs *linkFS) resolve(name string) string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.resolveLocked(name)
+}
+
+func (fs *linkFS) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	return fs.FileSystem.OpenFile(fs.resolve(name), flag, perm)
+}
+
+func (fs *linkFS) Stat(name string) (os.FileInfo, error) {
+	return fs.FileSystem.Stat(fs.resolve(name))
+}
+
+func TestServer_Link(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, &linkFS{FileSystem: mfs}, &ServerConfig{})
+	defer listener.Close()
+
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+
+	if _, err := fs.CreateWith("/original.txt", []byte("shared"), 0644); err != nil {
+		t.Fatalf("CreateWith failed: %v", err)
+	}
+	if err := fs.Link("/original.txt", "/hardlink.txt"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+
+	// Writes through one name are visible through the other
+	if _, err := fs.CreateWith("/hardlink.txt", []byte("updated"), 0644); err != nil {
+		t.Fatalf("CreateWith through link failed: %v", err)
+	}
+	data, err := fs.ReadFile("/original.txt")
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if string(data) != "updated" {
+		t.Errorf("Read %q through original name, want %q", data, "updated")
+	}
+}
+
+func TestServer_LinkUnsupported(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+
+	fs.CreateWith("/original.txt", []byte("data"), 0644)
+	if err := fs.Link("/original.txt", "/hardlink.txt"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected Link to fail with ErrUnsupported on a filesystem without hard links, got %v", err)
+	}
+}
+
+func TestServer_Symlink(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/data", 0755)
+
+	_, client, cleanup := testServerSetup(t, mfs)
+	defer cleanup()
+
+	if err := client.Symlink("/data/target.txt", "/data/abs"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if err := client.Symlink("target.txt", "/data/rel"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	for link, want := range map[string]string{"/data/abs": "/data/target.txt", "/data/rel": "target.txt"} {
+		got, err := mfs.Readlink(link)
+		if err != nil {
+			t.Fatalf("Readlink(%q) failed: %v", link, err)
+		}
+		if got != want {
+			t.Errorf("Readlink(%q) = %q, want %q", link, got, want)
+		}
+	}
+}
+
+func TestServer_StatSymlink(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/data", 0755)
+	f, err := mfs.Create("/data/target.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Write(bytes.Repeat([]byte("x"), 1000))
+	f.Close()
+	if err := mfs.Symlink("/data/target.txt", "/data/link"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	_, client, cleanup := testServerSetup(t, mfs)
+	defer cleanup()
+
+	info, err := client.Stat("/data/link")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size() != 1000 || info.Mode()&os.ModeSymlink != 0 {
+		t.Errorf("Stat of a symlink = size %d, mode %v; want the target's size 1000", info.Size(), info.Mode())
+	}
+
+	info, err = client.Lstat("/data/link")
+	if err != nil {
+		t.Fatalf("Lstat failed: %v", err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Lstat of a symlink reported mode %v", info.Mode())
+	}
+	if info, err := client.Lstat("/data/target.txt"); err != nil || info.Size() != 1000 {
+		t.Errorf("Lstat of a regular file = %v, %v", info, err)
+	}
+}
+
+// followingFS is a memfs whose directory listings follow symlinks, as some
+// backing filesystems' Readdir does.
+type followingFS struct {
+	*memfs.FileSystem
+}
+
+func (fs *followingFS) Open(name string) (absfs.File, error) {
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &followingDir{File: f, fs: fs.FileSystem, name: name}, nil
+}
+
+type followingDir struct {
+	absfs.File
+	fs   *memfs.FileSystem
+	name string
+}
+
+func (d *followingDir) Readdir(n int) ([]os.FileInfo, error) {
+	entries, err := d.File.Readdir(n)
+	for i, info := range entries {
+		if target, err := d.fs.Stat(path.Join(d.name, info.Name())); err == nil {
+			entries[i] = target
+		}
+	}
+	return entries, err
+}
+
+func TestServer_ListSymlinkEntries(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/data", 0755)
+	f, err := mfs.Create("/data/target.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Write([]byte("hello"))
+	f.Close()
+	if err := mfs.Symlink("/data/target.txt", "/data/link"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	_, client, cleanup := testServerSetup(t, &followingFS{FileSystem: mfs})
+	defer cleanup()
+
+	entries, err := client.ReadDir("/data")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	modes := make(map[string]os.FileMode)
+	for _, info := range entries {
+		modes[info.Name()] = info.Mode()
+	}
+	if modes["link"]&os.ModeSymlink == 0 {
+		t.Errorf("Listed symlink has mode %v, want a symlink", modes["link"])
+	}
+	if !modes["target.txt"].IsRegular() {
+		t.Errorf("Listed file has mode %v, want a regular file", modes["target.txt"])
+	}
+
+	// Stat of the link itself still follows it
+	if info, err := client.Stat("/data/link"); err != nil || !info.Mode().IsRegular() || info.Size() != 5 {
+		t.Errorf("Stat of the link = %v, %v; want the target", info, err)
+	}
+}
+
+// readdirSizeFS records the largest count passed to Readdir on its
+// directories, with -1 for an unbounded read.
+type readdirSizeFS struct {
+	*memfs.FileSystem
+	mu  sync.Mutex
+	max int
+}
+
+func (fs *readdirSizeFS) Open(name string) (absfs.File, error) {
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &readdirSizeDir{File: f, fs: fs}, nil
+}
+
+type readdirSizeDir struct {
+	absfs.File
+	fs *readdirSizeFS
+}
+
+func (d *readdirSizeDir) Readdir(n int) ([]os.FileInfo, error) {
+	d.fs.mu.Lock()
+	if n <= 0 {
+		d.fs.max = -1
+	} else if d.fs.max >= 0 && n > d.fs.max {
+		d.fs.max = n
+	}
+	d.fs.mu.Unlock()
+	return d.File.Readdir(n)
+}
+
+func TestServer_MaxInMemoryListing(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	mfs.Mkdir("/big", 0755)
+	const count = 250
+	for i := 0; i < count; i++ {
+		f, err := mfs.Create(fmt.Sprintf("/big/f%03d", i))
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		f.Close()
+	}
+	fs := &readdirSizeFS{FileSystem: mfs}
+	_, client, cleanup := testServerSetupWithConfig(t, fs, &ServerConfig{MaxInMemoryListing: 10})
+	defer cleanup()
+
+	entries, err := client.ReadDir("/big")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	seen := make(map[string]bool)
+	for _, info := range entries {
+		seen[info.Name()] = true
+	}
+	if len(entries) != count || len(seen) != count {
+		t.Errorf("ReadDir returned %d entries (%d distinct), want %d", len(entries), len(seen), count)
+	}
+
+	// Entries were read a packet's worth at a time, never all at once
+	fs.mu.Lock()
+	max := fs.max
+	fs.mu.Unlock()
+	if max < 0 || int64(max) > sftp.MaxFilelist {
+		t.Errorf("Largest Readdir count = %d, want at most %d", max, sftp.MaxFilelist)
+	}
+}
+
+func TestServer_FstatAfterRename(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+
+	_, client, cleanup := testServerSetup(t, fs)
+	defer cleanup()
+
+	f, err := client.Create("/before.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := client.Rename("/before.txt", "/after.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if _, err := f.Write([]byte(" world")); err != nil {
+		t.Fatalf("Write after rename failed: %v", err)
+	}
+
+	// Stat on the handle reports the open file, not the old path
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatalf("Fstat after rename failed: %v", err)
+	}
+	if info.Size() != 11 {
+		t.Errorf("Fstat size = %d, want 11", info.Size())
+	}
+
+	// A path Stat of the old name still fails
+	if _, err := client.Stat("/before.txt"); err == nil {
+		t.Error("Stat of the old name should fail after rename")
+	}
+}
+
+func TestServer_FstatPerHandle(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, fs, &ServerConfig{AtomicUploads: true})
+	defer listener.Close()
+
+	// Two sessions upload the same path at once, each to its own temp file
+	var files []*sftp.File
+	for i, data := range []string{"first", "second upload"} {
+		conn, err := testDialSSH(listener.Addr().String(), "testuser", "testpass")
+		if err != nil {
+			t.Fatalf("Dial %d failed: %v", i, err)
+		}
+		defer conn.Close()
+		client, err := sftp.NewClient(conn)
+		if err != nil {
+			t.Fatalf("NewClient %d failed: %v", i, err)
+		}
+		defer client.Close()
+
+		f, err := client.Create("/shared.txt")
+		if err != nil {
+			t.Fatalf("Create %d failed: %v", i, err)
+		}
+		defer f.Close()
+		if _, err := f.Write([]byte(data)); err != nil {
+			t.Fatalf("Write %d failed: %v", i, err)
+		}
+		files = append(files, f)
+	}
+
+	// Each handle reports its own file
+	for i, want := range []int64{5, 13} {
+		info, err := files[i].Stat()
+		if err != nil {
+			t.Fatalf("Fstat %d failed: %v", i, err)
+		}
+		if info.Size() != want {
+			t.Errorf("Fstat %d size = %d, want %d", i, info.Size(), want)
+		}
+	}
+}
+
+func TestServerHandler_HandleTag(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	h := newServerHandler(fs)
+	open := sftp.NewRequest("Put", "/tagged.txt")
+	w, err := h.Filewrite(open)
+	if err != nil {
+		t.Fatalf("Filewrite failed: %v", err)
+	}
+	defer w.(io.Closer).Close()
+
+	// A request carrying the open request's path finds the file, and the
+	// tag is removed from it
+	r := &sftp.Request{Method: "Stat", Filepath: open.Filepath}
+	if p, f := h.openHandle(r); f == nil || p != "/tagged.txt" || r.Filepath != "/tagged.txt" {
+		t.Errorf("openHandle = %q, %v; request path %q", p, f, r.Filepath)
+	}
+
+	// A path from the wire that only looks like a tag does not
+	for _, p := range []string{"/tagged.txt\x001", "/tagged.txt\x00"} {
+		r := sftp.NewRequest("Stat", p)
+		if _, f := h.openHandle(r); f != nil || r.Filepath != p {
+			t.Errorf("openHandle(%q) found an open file", p)
+		}
+	}
+}
+
+func TestServer_MkdirAllPerm(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+
+	if err := fs.MkdirAll("/a/b/c", 0700); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	for _, dir := range []string{"/a", "/a/b", "/a/b/c"} {
+		info, err := mfs.Stat(dir)
+		if err != nil {
+			t.Fatalf("Stat(%q) failed: %v", dir, err)
+		}
+		if !info.IsDir() || info.Mode().Perm() != 0700 {
+			t.Errorf("%s: mode %v, want drwx------", dir, info.Mode())
+		}
+	}
+	if err := fs.MkdirAll("/a/b/c", 0700); err != nil {
+		t.Errorf("MkdirAll on an existing directory failed: %v", err)
+	}
+
+	fs.CreateWith("/a/file", nil, 0644)
+	if err := fs.MkdirAll("/a/file/d", 0700); err == nil {
+		t.Error("MkdirAll through a file should fail")
+	}
+}
+
+func TestServer_MkdirUmask(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+
+	fs, err := New(&Config{
+		Host:     listener.Addr().String(),
+		User:     "testuser",
+		Password: "testpass",
+		Umask:    0027,
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer fs.Close()
+
+	if err := fs.MkdirAll("/x/y", 0777); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	for _, dir := range []string{"/x", "/x/y"} {
+		info, err := mfs.Stat(dir)
+		if err != nil {
+			t.Fatalf("Stat(%q) failed: %v", dir, err)
+		}
+		if info.Mode().Perm() != 0750 {
+			t.Errorf("%s: mode %v, want 0750", dir, info.Mode().Perm())
+		}
+	}
+	if _, err := fs.CreateWith("/x/f.txt", nil, 0666); err != nil {
+		t.Fatalf("CreateWith failed: %v", err)
+	}
+	if info, _ := mfs.Stat("/x/f.txt"); info.Mode().Perm() != 0640 {
+		t.Errorf("file mode %v, want 0640", info.Mode().Perm())
+	}
+}
+
+func TestServer_MkdirMode(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, client, cleanup := testServerSetupWithConfig(t, mfs, &ServerConfig{})
+	defer cleanup()
+
+	// pkg/sftp's RequestServer drops the attributes of mkdir requests,
+	// whichever client sent them, so a new directory is 0755 until the
+	// client's follow-up Setstat applies the mode it wants
+	if err := client.Mkdir("/private"); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if info, err := mfs.Stat("/private"); err != nil || !info.IsDir() || info.Mode().Perm() != 0755 {
+		t.Fatalf("/private after mkdir: %v, %v, want mode 0755", info, err)
+	}
+	if err := client.Chmod("/private", 0700); err != nil {
+		t.Fatalf("Setstat failed: %v", err)
+	}
+	if info, err := mfs.Stat("/private"); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("/private after setstat: %v, %v, want mode 0700", info, err)
+	}
+}
+
+func TestServer_MaxOpenFiles(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	for _, name := range []string{"/a.txt", "/b.txt", "/c.txt"} {
+		f, _ := fs.Create(name)
+		f.Close()
+	}
+
+	_, client, cleanup := testServerSetupWithConfig(t, fs, &ServerConfig{MaxOpenFiles: 2})
+	defer cleanup()
+
+	a, err := client.Open("/a.txt")
+	if err != nil {
+		t.Fatalf("Open a failed: %v", err)
+	}
+	b, err := client.Create("/b.txt")
+	if err != nil {
+		t.Fatalf("Create b failed: %v", err)
+	}
+	defer b.Close()
+
+	if f, err := client.Open("/c.txt"); err == nil {
+		f.Close()
+		t.Fatal("Open beyond MaxOpenFiles should fail")
+	}
+	if f, err := client.Create("/d.txt"); err == nil {
+		f.Close()
+		t.Fatal("Create beyond MaxOpenFiles should fail")
+	}
+
+	a.Close()
+	c, err := client.Open("/c.txt")
+	if err != nil {
+		t.Fatalf("Open after a close failed: %v", err)
+	}
+	c.Close()
+
+	// Directory listings do not count against the limit
+	if _, err := client.ReadDir("/"); err != nil {
+		t.Errorf("ReadDir failed: %v", err)
+	}
+}
+
+func TestServer_HandshakeTimeout(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{HandshakeTimeout: 200 * time.Millisecond})
+	defer listener.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.Copy(io.Discard, conn); err != nil {
+		t.Fatalf("Expected the server to close the silent connection, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Silent connection was dropped after %v", elapsed)
+	}
+
+	// The deadline is lifted once the handshake completes
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+	time.Sleep(300 * time.Millisecond)
+	if err := fs.Ping(); err != nil {
+		t.Errorf("Ping after the handshake timeout elapsed failed: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package sftpfs
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SessionInfo describes a connected client session.
+type SessionInfo struct {
+	ID          string    // Server-assigned identifier, unique for the server's lifetime
+	User        string    // Authenticated user name
+	RemoteAddr  net.Addr  // Client network address
+	ConnectedAt time.Time // Time the SSH handshake completed
+	BytesIn     int64     // Bytes received from the client, including SSH framing
+	BytesOut    int64     // Bytes sent to the client, including SSH framing
+}
+
+// session is the server's record of a connected client.
+type session struct {
+	id          string
+	conn        *ssh.ServerConn
+	counter     *countingConn
+	connectedAt time.Time
+}
+
+func (sess *session) info() SessionInfo {
+	return SessionInfo{
+		ID:          sess.id,
+		User:        sess.conn.User(),
+		RemoteAddr:  sess.conn.RemoteAddr(),
+		ConnectedAt: sess.connectedAt,
+		BytesIn:     sess.counter.in.Load(),
+		BytesOut:    sess.counter.out.Load(),
+	}
+}
+
+// countingConn counts the bytes read from and written to a net.Conn.
+type countingConn struct {
+	net.Conn
+	in, out atomic.Int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.in.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.out.Add(int64(n))
+	return n, err
+}
+
+// Sessions returns a snapshot of the currently connected sessions, ordered
+// by connection time.
+func (s *Server) Sessions() []SessionInfo {
+	s.mu.Lock()
+	infos := make([]SessionInfo, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		infos = append(infos, sess.info())
+	}
+	s.mu.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ConnectedAt.Before(infos[j].ConnectedAt)
+	})
+	return infos
+}
+
+// ErrSessionNotFound is returned by Disconnect when no connected session has
+// the given ID.
+var ErrSessionNotFound = errors.New("session not found")
+
+// Disconnect forcibly closes the SSH connection of the session with the given
+// ID, as reported by Sessions. Open files and transfers on the session are
+// aborted.
+func (s *Server) Disconnect(sessionID string) error {
+	s.mu.Lock()
+	sess, ok := s.sessions[sessionID]
+	s.mu.Unlock()
+	if !ok {
+		return ErrSessionNotFound
+	}
+	return sess.conn.Close()
+}
+
+// addSession records an authenticated connection and returns its session.
+func (s *Server) addSession(conn *ssh.ServerConn, counter *countingConn) *session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextSessionID++
+	sess := &session{
+		id:          strconv.FormatUint(s.nextSessionID, 10),
+		conn:        conn,
+		counter:     counter,
+		connectedAt: time.Now(),
+	}
+	if s.sessions == nil {
+		s.sessions = make(map[string]*session)
+	}
+	s.sessions[sess.id] = sess
+	return sess
+}
+
+// removeSession forgets a session once its connection has ended.
+func (s *Server) removeSession(sess *session) {
+	s.mu.Lock()
+	delete(s.sessions, sess.id)
+	s.mu.Unlock()
+}
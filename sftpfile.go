@@ -1,98 +1,419 @@
 package sftpfs
 
 import (
+	"bufio"
+	"errors"
+	"io"
 	iofs "io/fs"
 	"os"
+	"path"
+	"runtime"
+	"sync"
+	"time"
 )
 
 // File wraps an sftp.File to implement absfs.File interface.
 type File struct {
 	file   sftpFileInterface
 	name   string
+	flag   int // flags passed to OpenFile
 	client sftpClientInterface
+	wbuf   *bufio.Writer // nil unless the file was opened with write buffering
+	rbuf   *bufio.Reader // read-ahead buffer of OpenSequential, or nil
+	stats  *fsStats      // counters of the opening FileSystem, or nil
+	ops    *opTracker    // in-flight tracking of the opening FileSystem, or nil
+	handle *openHandle   // registration with ops, or nil
+
+	noReadAhead bool   // set by OpenRandom
+	lockName    string // path of the lock file held by Lock, or ""
+
+	// statMu guards the info cached by Stat. statGen counts invalidations,
+	// so a fetch racing a write does not cache what it saw before it.
+	statMu   sync.Mutex
+	statInfo os.FileInfo
+	statGen  uint64
 }
 
-// Name returns the name of the file.
+// Name returns the name of the file as passed to OpenFile, which for SFTP
+// is normally the full remote path. Use BaseName for the last element only.
 func (f *File) Name() string {
 	return f.name
 }
 
+// BaseName returns the last element of the file's path, like the name
+// reported by Stat.
+func (f *File) BaseName() string {
+	return path.Base(f.name)
+}
+
 // Read reads from the SFTP file.
 func (f *File) Read(b []byte) (int, error) {
-	return f.file.Read(b)
+	defer f.ops.begin()()
+	if err := f.Flush(); err != nil {
+		return 0, err
+	}
+	if f.rbuf != nil {
+		n, err := f.rbuf.Read(b)
+		return n, f.canceledErr("read", err)
+	}
+	n, err := f.file.Read(b)
+	f.stats.read(n)
+	return n, f.canceledErr("read", err)
 }
 
 // ReadAt reads from the SFTP file at a specific offset.
 func (f *File) ReadAt(b []byte, off int64) (int, error) {
-	return f.file.ReadAt(b, off)
+	defer f.ops.begin()()
+	if err := f.Flush(); err != nil {
+		return 0, err
+	}
+	n, err := f.file.ReadAt(b, off)
+	f.stats.read(n)
+	return n, f.canceledErr("read", err)
+}
+
+// ReadFullAt reads exactly len(b) bytes starting at off, issuing further
+// reads if the server returns fewer bytes than requested. Like io.ReadFull,
+// it returns io.EOF only if no bytes were read, and io.ErrUnexpectedEOF if
+// EOF was reached after a partial read.
+func (f *File) ReadFullAt(b []byte, off int64) (int, error) {
+	n := 0
+	for n < len(b) {
+		m, err := f.ReadAt(b[n:], off+int64(n))
+		n += m
+		if n == len(b) {
+			return n, nil
+		}
+		switch {
+		case err == io.EOF && n == 0:
+			return 0, io.EOF
+		case err == io.EOF:
+			return n, io.ErrUnexpectedEOF
+		case err != nil:
+			return n, err
+		case m == 0:
+			return n, io.ErrNoProgress
+		}
+	}
+	return n, nil
+}
+
+// Peek returns the first n bytes of the file without moving the read
+// position, so a following Read still starts where it would have. It is
+// meant for sniffing magic bytes. If the file is shorter than n, Peek
+// returns its whole content and io.EOF.
+func (f *File) Peek(n int) ([]byte, error) {
+	b := make([]byte, n)
+	m, err := f.ReadFullAt(b, 0)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return b[:m], err
 }
 
 // Write writes to the SFTP file.
-func (f *File) Write(b []byte) (int, error) {
-	return f.file.Write(b)
+func (f *File) Write(b []byte) (n int, err error) {
+	defer f.ops.begin()()
+	defer f.invalidateStat()
+	if f.wbuf != nil {
+		n, err = f.wbuf.Write(b)
+	} else {
+		n, err = f.file.Write(b)
+	}
+	f.stats.wrote(n)
+	return n, f.canceledErr("write", err)
+}
+
+// WriteTo writes the rest of the file to w, implementing io.WriterTo so
+// io.Copy uses it. For files on a github.com/pkg/sftp connection the data
+// is fetched with concurrent reads, unless the file was opened with
+// OpenRandom; otherwise it is read sequentially.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	if err := f.Flush(); err != nil {
+		return 0, err
+	}
+	if f.rbuf != nil {
+		defer f.ops.begin()()
+		n, err := f.rbuf.WriteTo(w)
+		return n, f.canceledErr("read", err)
+	}
+	if wt, ok := f.file.(io.WriterTo); ok && !f.noReadAhead {
+		defer f.ops.begin()()
+		n, err := wt.WriteTo(w)
+		f.stats.read(int(n))
+		return n, f.canceledErr("read", err)
+	}
+	return io.CopyBuffer(struct{ io.Writer }{w}, struct{ io.Reader }{f}, make([]byte, 32*1024))
 }
 
 // WriteAt writes to the SFTP file at a specific offset.
 func (f *File) WriteAt(b []byte, off int64) (int, error) {
-	return f.file.WriteAt(b, off)
+	defer f.ops.begin()()
+	defer f.invalidateStat()
+	if err := f.Flush(); err != nil {
+		return 0, err
+	}
+	n, err := f.file.WriteAt(b, off)
+	f.stats.wrote(n)
+	return n, f.canceledErr("write", err)
 }
 
+// WriteAtFill writes b at off like WriteAt, but first writes explicit zero
+// bytes over any gap between the current end of the file and off. Use it
+// with servers or filesystems that leave unspecified data in holes.
+func (f *File) WriteAtFill(b []byte, off int64) (int, error) {
+	defer f.ops.begin()()
+	defer f.invalidateStat()
+	info, err := f.RefreshStat()
+	if err != nil {
+		return 0, err
+	}
+	if gap := off - info.Size(); gap > 0 {
+		zeros := make([]byte, min(gap, zeroFillChunk))
+		for pos := info.Size(); pos < off; {
+			chunk := zeros[:min(off-pos, int64(len(zeros)))]
+			n, err := f.file.WriteAt(chunk, pos)
+			f.stats.wrote(n)
+			if err != nil {
+				return 0, f.canceledErr("write", err)
+			}
+			pos += int64(n)
+		}
+	}
+	n, err := f.file.WriteAt(b, off)
+	f.stats.wrote(n)
+	return n, f.canceledErr("write", err)
+}
+
+// zeroFillChunk is the largest zero buffer WriteAtFill writes at once.
+const zeroFillChunk = 32 * 1024
+
 // WriteString writes a string to the SFTP file.
 func (f *File) WriteString(s string) (int, error) {
-	return f.file.Write([]byte(s))
+	return f.Write([]byte(s))
 }
 
-// Close closes the SFTP file.
+// Flush sends any client-side buffered writes to the server. Unlike Sync,
+// it does not ask the server to commit data to stable storage. For files
+// opened without write buffering it is a no-op.
+func (f *File) Flush() error {
+	if f.wbuf == nil {
+		return nil
+	}
+	return f.wbuf.Flush()
+}
+
+// Close flushes any buffered writes, releases any lock taken with Lock,
+// and closes the SFTP file. For a file aborted by CancelAll, whose handle
+// is already closed, buffered writes are discarded.
 func (f *File) Close() error {
-	return f.file.Close()
+	runtime.SetFinalizer(f, nil)
+	f.ops.remove(f.handle)
+	if f.handle.isCanceled() {
+		return f.Unlock()
+	}
+	flushErr := f.Flush()
+	unlockErr := f.Unlock()
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if flushErr != nil {
+		return flushErr
+	}
+	return unlockErr
 }
 
 // Seek seeks within the SFTP file.
 func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if err := f.Flush(); err != nil {
+		return 0, err
+	}
+	if buffered := f.discardReadAhead(); whence == io.SeekCurrent {
+		// The handle is ahead of the caller by the buffered bytes
+		offset -= buffered
+	}
 	return f.file.Seek(offset, whence)
 }
 
-// Stat returns file info for the SFTP file.
+// SeekEnd moves the file offset to the end of the file and returns it,
+// which is where the next Write will land. If the client cannot seek
+// relative to the end, the size is fetched with Stat and the offset set
+// from the start instead.
+func (f *File) SeekEnd() (int64, error) {
+	if err := f.Flush(); err != nil {
+		return 0, err
+	}
+	f.discardReadAhead()
+	if off, err := f.file.Seek(0, io.SeekEnd); err == nil {
+		return off, nil
+	}
+	info, err := f.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return f.file.Seek(info.Size(), io.SeekStart)
+}
+
+// Stat returns file info for the SFTP file. The info is fetched on the
+// first call and cached until the file is written, truncated or has its
+// attributes set through this File; changes made by other handles or
+// clients are not seen until RefreshStat is called.
 func (f *File) Stat() (os.FileInfo, error) {
-	return f.file.Stat()
+	f.statMu.Lock()
+	info := f.statInfo
+	f.statMu.Unlock()
+	if info != nil {
+		return info, nil
+	}
+	return f.RefreshStat()
+}
+
+// RefreshStat fetches the file's info from the server, as Stat does on its
+// first call, and caches it for later calls to Stat. Use it to observe a
+// file growing while it is open.
+func (f *File) RefreshStat() (os.FileInfo, error) {
+	if err := f.Flush(); err != nil {
+		return nil, err
+	}
+	f.statMu.Lock()
+	gen := f.statGen
+	f.statMu.Unlock()
+
+	info, err := f.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	f.statMu.Lock()
+	if f.statGen == gen {
+		f.statInfo = info
+	}
+	f.statMu.Unlock()
+	return info, nil
+}
+
+// invalidateStat drops the info cached by Stat.
+func (f *File) invalidateStat() {
+	f.statMu.Lock()
+	f.statInfo = nil
+	f.statGen++
+	f.statMu.Unlock()
 }
 
-// Sync commits the current contents of the file to stable storage.
+// Sync commits the current contents of the file to stable storage. It
+// flushes buffered writes and, if the server offers the fsync@openssh.com
+// extension, calls Fsync. Writes are otherwise synchronous over the network
+// once sent, so a server without the extension is not an error here; use
+// Fsync to require it.
 func (f *File) Sync() error {
-	// SFTP doesn't have a direct sync operation, but we can implement it as a no-op
-	// since writes are typically synchronous over the network
+	if err := f.Fsync(); err != nil && !errors.Is(err, ErrUnsupported) {
+		return err
+	}
 	return nil
 }
 
 // Truncate changes the size of the file.
 func (f *File) Truncate(size int64) error {
-	return f.file.Truncate(size)
+	defer f.ops.begin()()
+	defer f.invalidateStat()
+	if err := f.Flush(); err != nil {
+		return err
+	}
+	return f.canceledErr("truncate", f.file.Truncate(size))
 }
 
-// Readdir reads directory entries.
+// SetAttrs changes the attributes of the open file, applying only the
+// non-nil arguments. Mode and ownership are set on the open handle
+// (fsetstat). github.com/pkg/sftp has no handle-based call for times, so
+// atime and mtime are set by path. If only one of a pair (uid/gid,
+// atime/mtime) is given, the other keeps its current value.
+func (f *File) SetAttrs(mode *os.FileMode, atime, mtime *time.Time, uid, gid *int) error {
+	if err := f.Flush(); err != nil {
+		return err
+	}
+	defer f.invalidateStat()
+
+	var cur *FileStatExtended
+	current := func() (*FileStatExtended, error) {
+		if cur == nil {
+			info, err := f.file.Stat()
+			if err != nil {
+				return nil, err
+			}
+			cur = statExtended(info)
+		}
+		return cur, nil
+	}
+
+	if mode != nil {
+		if err := f.file.Chmod(*mode); err != nil {
+			return err
+		}
+	}
+	if uid != nil || gid != nil {
+		if uid == nil || gid == nil {
+			st, err := current()
+			if err != nil {
+				return err
+			}
+			if uid == nil {
+				uid = intPtr(int(st.UID))
+			}
+			if gid == nil {
+				gid = intPtr(int(st.GID))
+			}
+		}
+		if err := f.file.Chown(*uid, *gid); err != nil {
+			return err
+		}
+	}
+	if atime != nil || mtime != nil {
+		if atime == nil || mtime == nil {
+			st, err := current()
+			if err != nil {
+				return err
+			}
+			if atime == nil {
+				atime = &st.Atime
+			}
+			if mtime == nil {
+				mtime = &st.Mtime
+			}
+		}
+		if err := f.client.Chtimes(f.name, *atime, *mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func intPtr(v int) *int { return &v }
+
+// Readdir reads directory entries. As with os.File.Readdir, if the listing
+// fails partway, the entries read before the error are returned with it.
 func (f *File) Readdir(n int) ([]os.FileInfo, error) {
 	// Use the client's ReadDir to get directory entries
 	entries, err := f.client.ReadDir(f.name)
-	if err != nil {
+	if err != nil && len(entries) == 0 {
 		return nil, err
 	}
 
 	// If n <= 0, return all entries
 	if n <= 0 {
-		return entries, nil
+		return entries, err
 	}
 
 	// Otherwise return up to n entries
 	if n > len(entries) {
 		n = len(entries)
 	}
-	return entries[:n], nil
+	return entries[:n], err
 }
 
-// Readdirnames reads directory entry names.
+// Readdirnames reads directory entry names. Like Readdir, it returns any
+// names read before an error along with the error.
 func (f *File) Readdirnames(n int) ([]string, error) {
 	infos, err := f.Readdir(n)
-	if err != nil {
+	if err != nil && len(infos) == 0 {
 		return nil, err
 	}
 
@@ -100,13 +421,14 @@ func (f *File) Readdirnames(n int) ([]string, error) {
 	for i, info := range infos {
 		names[i] = info.Name()
 	}
-	return names, nil
+	return names, err
 }
 
-// ReadDir reads the directory and returns fs.DirEntry values.
+// ReadDir reads the directory and returns fs.DirEntry values. Like Readdir,
+// it returns any entries read before an error along with the error.
 func (f *File) ReadDir(n int) ([]iofs.DirEntry, error) {
 	infos, err := f.Readdir(n)
-	if err != nil {
+	if err != nil && len(infos) == 0 {
 		return nil, err
 	}
 
@@ -114,5 +436,5 @@ func (f *File) ReadDir(n int) ([]iofs.DirEntry, error) {
 	for i, info := range infos {
 		entries[i] = &dirEntry{info: info}
 	}
-	return entries, nil
+	return entries, err
 }
@@ -3,10 +3,18 @@
 package sftpfs
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
 	"io"
 	iofs "io/fs"
+	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/absfs/absfs"
@@ -18,6 +26,35 @@ import (
 type FileSystem struct {
 	client    sftpClientInterface
 	sshClient sshClientInterface
+	config    Config
+	stats     fsStats
+	ops       opTracker
+	cwd       string                 // directory set by Chdir, or "" for the server's
+	home      atomic.Pointer[string] // cached by UserHomeDir
+
+	// redial, set by New, opens a new connection with the same settings
+	// for DownloadTo to resume on.
+	redial func() (sftpClientInterface, sshClientInterface, error)
+
+	// share holds the state fs has in common with its clones; use shared.
+	share atomic.Pointer[sharedState]
+}
+
+// sharedState is the per-connection state that a FileSystem and every
+// Clone of it use together.
+type sharedState struct {
+	closed      atomic.Bool // set by the first Close of any clone
+	appendLocks sync.Map    // a *sync.Mutex per path, for AppendLocked
+}
+
+// shared returns the state fs shares with its clones, creating it on first
+// use so FileSystems built without New work too.
+func (fs *FileSystem) shared() *sharedState {
+	if s := fs.share.Load(); s != nil {
+		return s
+	}
+	fs.share.CompareAndSwap(nil, &sharedState{})
+	return fs.share.Load()
 }
 
 // Config contains the configuration for connecting to an SFTP server.
@@ -27,6 +64,129 @@ type Config struct {
 	Password string        // Password for authentication (if using password auth)
 	Key      []byte        // Private key for authentication (if using key auth)
 	Timeout  time.Duration // Connection timeout
+
+	// Certificate is an SSH user certificate for Key, in the authorized_keys
+	// format written by ssh-keygen -s. When set, the certificate is offered
+	// instead of the bare key, for servers that trust a certificate
+	// authority. New fails if it does not certify Key or is not currently
+	// valid.
+	Certificate []byte
+
+	// WriteBufferSize enables client-side buffering of Write calls on files
+	// opened for writing. Buffered bytes are sent when the buffer fills, on
+	// Flush, and before any other operation on the file. If 0, writes are
+	// sent to the server immediately.
+	WriteBufferSize int
+
+	// Retries is the number of additional attempts made when dialing fails,
+	// or when an idempotent operation (Stat, ReadDir, Chmod, Chtimes, Chown)
+	// fails, with an error accepted by RetryableError. Operations are
+	// retried over the same connection, so not after it has been lost. It
+	// also limits the reconnects DownloadTo makes to resume. If 0, nothing
+	// is retried.
+	Retries int
+
+	// RetryDelay is the pause between retry attempts.
+	// If 0 and Retries is set, defaults to 1 second.
+	RetryDelay time.Duration
+
+	// RetryableError reports whether an error is transient and the failed
+	// step should be retried. If nil, DefaultRetryableError is used.
+	RetryableError func(error) bool
+
+	// ValidateNames rejects paths that are not valid UTF-8 or that contain
+	// control characters such as newlines, returning an *os.PathError
+	// wrapping os.ErrInvalid without contacting the server.
+	ValidateNames bool
+
+	// OnStateChange, if set, is called as the connection moves between
+	// states: Connecting, Reconnecting before each retried dial, Connected,
+	// Disconnected if the connection fails or drops, and Closed.
+	OnStateChange func(state ConnState)
+
+	// LegacyAlgorithms additionally enables older ciphers, key exchanges,
+	// MACs and host key types (such as aes128-cbc, diffie-hellman-group1-sha1,
+	// hmac-sha1 and ssh-rsa) for servers that support nothing newer.
+	// WARNING: this reduces the security of the connection; only set it for
+	// servers that cannot be upgraded.
+	LegacyAlgorithms bool
+
+	// TransferChunkSize is the number of bytes moved per read and write by
+	// WriteFileFrom and CopyFile. Larger chunks mean fewer round trips on
+	// high-latency links. If 0, DefaultTransferChunkSize is used; smaller
+	// values than MinTransferChunkSize are rejected by New.
+	TransferChunkSize int
+
+	// VerifyUploadSize makes WriteFile, WriteFileFrom and CopyFile stat the
+	// destination after closing it and compare its size with the number of
+	// bytes written. On a mismatch the file is removed and an error
+	// wrapping ErrSizeMismatch is returned, catching transfers the server
+	// truncated without reporting an error.
+	VerifyUploadSize bool
+
+	// FsyncInterval, if positive, makes WriteFileFrom, CopyFile and
+	// CopyReader call Sync on the destination whenever this much time has
+	// passed since the last sync, bounding the data lost if the server
+	// crashes during a long upload. If 0, the file is only closed at the
+	// end.
+	FsyncInterval time.Duration
+
+	// Logger receives the client's diagnostic messages.
+	// If nil, slog.Default is used.
+	Logger *slog.Logger
+
+	// WarnOnLeak logs a warning on Logger, with the file's path, when a
+	// *File is garbage collected without having been closed. Leaked
+	// handles stay open on the server until the connection ends and can
+	// exhaust its open-file limit. Detection relies on finalizers, so
+	// warnings appear only after a garbage collection.
+	WarnOnLeak bool
+
+	// HostKeyFingerprint, if set, is the SHA256 fingerprint the server's host
+	// key must have, as printed by ssh-keygen -l (for example
+	// "SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s"). A server
+	// presenting any other key is rejected with ErrHostKeyMismatch. If
+	// empty, host keys are not verified.
+	HostKeyFingerprint string
+
+	// Compression asks for zlib@openssh.com compression of the SSH
+	// transport. golang.org/x/crypto/ssh implements no compression, so New
+	// fails with an error wrapping ErrUnsupported instead of silently
+	// sending uncompressed data. WriteFileGzip and OpenGzip compress file
+	// contents at the application level instead.
+	Compression bool
+
+	// TCPKeepAlive, if positive, enables TCP keepalive probes on the
+	// connection's socket at this interval, so the operating system notices
+	// a dead peer; if negative, it disables them. This is independent of SSH
+	// keepalive messages. If 0, the platform default is kept.
+	TCPKeepAlive time.Duration
+
+	// NoDelay sets TCP_NODELAY on the connection's socket, sending small
+	// packets without waiting to coalesce them. Go usually enables it
+	// already; setting it guarantees it for latency-sensitive workloads.
+	NoDelay bool
+
+	// Umask is cleared from the permission bits given to Mkdir, MkdirAll
+	// and OpenFile when they create a file or directory, as the process
+	// umask is for local files. The server may apply its own umask as well.
+	Umask os.FileMode
+
+	// TempNameFunc, if set, returns the base name CreateTemp and MkdirTemp
+	// try next for pattern. It is called again when the name is taken, so
+	// it should not return the same name forever. If nil, the last "*" in
+	// pattern, or its end, is replaced by a crypto-random hex string.
+	TempNameFunc func(pattern string) string
+
+	// OwnerResolver maps user and group names to the numeric ids used by
+	// ChownByName. SFTP carries only numeric ids, and the names must be
+	// resolved as the server sees them. If nil, ChownByName fails.
+	OwnerResolver OwnerResolver
+}
+
+// applyUmask returns perm with the bits in config.Umask cleared.
+func (config *Config) applyUmask(perm os.FileMode) os.FileMode {
+	return perm &^ config.Umask
 }
 
 // New creates a new SFTP filesystem with the given configuration.
@@ -35,6 +195,15 @@ func New(config *Config) (*FileSystem, error) {
 	if config.Timeout == 0 {
 		config.Timeout = 30 * time.Second
 	}
+	if config.Retries > 0 && config.RetryDelay == 0 {
+		config.RetryDelay = time.Second
+	}
+	if config.Compression {
+		return nil, errCompression
+	}
+	if config.TransferChunkSize != 0 && config.TransferChunkSize < MinTransferChunkSize {
+		return nil, fmt.Errorf("sftpfs: TransferChunkSize %d is below the minimum of %d", config.TransferChunkSize, MinTransferChunkSize)
+	}
 
 	// Build SSH client config
 	sshConfig := &ssh.ClientConfig{
@@ -42,6 +211,12 @@ func New(config *Config) (*FileSystem, error) {
 		Timeout:         config.Timeout,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // WARNING: This skips host key verification
 	}
+	if config.HostKeyFingerprint != "" {
+		sshConfig.HostKeyCallback = hostKeyFingerprintCallback(config.HostKeyFingerprint)
+	}
+	if config.LegacyAlgorithms {
+		applyLegacyAlgorithms(sshConfig)
+	}
 
 	// Add authentication method
 	if len(config.Key) > 0 {
@@ -50,29 +225,76 @@ func New(config *Config) (*FileSystem, error) {
 		if err != nil {
 			return nil, err
 		}
+		if len(config.Certificate) > 0 {
+			if signer, err = certSigner(config.Certificate, signer); err != nil {
+				return nil, err
+			}
+		}
 		sshConfig.Auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+	} else if len(config.Certificate) > 0 {
+		return nil, errors.New("sftpfs: Certificate requires Key")
 	} else {
 		// Use password authentication
 		sshConfig.Auth = []ssh.AuthMethod{ssh.Password(config.Password)}
 	}
 
 	// Connect to SSH server
-	sshClient, err := ssh.Dial("tcp", config.Host, sshConfig)
+	var sshClient *ssh.Client
+	attempts := 0
+	config.setState(Connecting)
+	err := config.withRetryDial(func() (err error) {
+		if attempts++; attempts > 1 {
+			config.setState(Reconnecting)
+		}
+		sshClient, err = config.dialSSH("tcp", config.Host, sshConfig)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		config.setState(Disconnected)
+		return nil, config.algorithmError(err)
 	}
 
 	// Create SFTP client
 	client, err := sftp.NewClient(sshClient)
 	if err != nil {
 		sshClient.Close()
+		config.setState(Disconnected)
 		return nil, err
 	}
 
-	return &FileSystem{
+	fs := &FileSystem{
 		client:    &sftpClientWrapper{client: client},
 		sshClient: sshClient,
-	}, nil
+		config:    *config,
+	}
+	fs.redial = func() (sftpClientInterface, sshClientInterface, error) {
+		sshClient, err := fs.config.dialSSH("tcp", fs.config.Host, sshConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		client, err := sftp.NewClient(sshClient)
+		if err != nil {
+			sshClient.Close()
+			return nil, nil, err
+		}
+		return &sftpClientWrapper{client: client}, sshClient, nil
+	}
+	fs.config.setState(Connected)
+	go fs.watchConnection(sshClient)
+	return fs, nil
+}
+
+// NewFromSSHClient creates an SFTP filesystem over an established SSH
+// connection, opening an SFTP session on it without dialing again, for
+// callers that also use the connection for other sessions or port
+// forwarding. The connection is borrowed: Close ends only the SFTP
+// session, and the caller remains responsible for closing sshClient.
+func NewFromSSHClient(sshClient *ssh.Client) (*FileSystem, error) {
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSystem{client: &sftpClientWrapper{client: client}}, nil
 }
 
 // newWithClients creates a FileSystem with injected clients for testing.
@@ -83,8 +305,12 @@ func newWithClients(sftpClient sftpClientInterface, sshClient sshClientInterface
 	}
 }
 
-// Close closes the SFTP connection.
+// Close closes the SFTP connection. For a FileSystem created with
+// NewFromSSHClient only the SFTP session is closed.
 func (fs *FileSystem) Close() error {
+	if !fs.shared().closed.Swap(true) {
+		defer fs.config.setState(Closed)
+	}
 	if fs.client != nil {
 		fs.client.Close()
 	}
@@ -94,54 +320,424 @@ func (fs *FileSystem) Close() error {
 	return nil
 }
 
+// ErrSpecialFile is returned by OpenFile when the server reports the path as
+// a device, named pipe, socket or other non-regular file. Reading or
+// writing one over SFTP can block indefinitely.
+var ErrSpecialFile = errors.New("special file")
+
+// specialModes are the mode bits OpenFile refuses to open.
+const specialModes = os.ModeDevice | os.ModeCharDevice | os.ModeNamedPipe | os.ModeSocket | os.ModeIrregular
+
 // OpenFile opens a file on the SFTP server.
+//
+// Unless the call may create the file, the path is stat'ed first, and
+// opening a special file fails with an *os.PathError wrapping
+// ErrSpecialFile.
+//
+// If the call creates the file, perm is applied to the new handle before
+// OpenFile returns, so no data is written while the file has the server's
+// default mode. The SFTP open request itself carries no attributes in
+// github.com/pkg/sftp, which is why this takes a second round-trip. An
+// existing file keeps its mode.
 func (fs *FileSystem) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
-	file, err := fs.client.OpenFile(name, flag)
+	name = fs.abs(name)
+	if err := fs.checkName("open", name); err != nil {
+		return nil, err
+	}
+	return fs.openFileOn(fs.client, name, flag, perm)
+}
+
+// openFileOn does the work of OpenFile for the absolute path name over
+// client, which is fs.client except for DownloadTo's own connections.
+func (fs *FileSystem) openFileOn(client sftpClientInterface, name string, flag int, perm os.FileMode) (absfs.File, error) {
+	file, created, err := openTracked(client, name, flag)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		if err := file.Chmod(fs.config.applyUmask(perm)); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	fs.stats.opens.Add(1)
+	f := &File{file: file, name: name, flag: flag, client: client, stats: &fs.stats, ops: &fs.ops, handle: fs.ops.add(file)}
+	if fs.config.WriteBufferSize > 0 && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		f.wbuf = bufio.NewWriterSize(file, fs.config.WriteBufferSize)
+	}
+	if fs.config.WarnOnLeak {
+		fs.config.watchLeak(f)
+	}
+	return f, nil
+}
+
+// openTracked opens name with flag over client and reports whether the
+// open created the file, which the SFTP open reply does not say. An
+// O_CREATE open without O_EXCL is tried with O_EXCL first; if that fails
+// and a Stat finds the file, it is opened again without O_CREATE, starting
+// over a couple of times if the file is removed in between. A Stat made
+// before the open could not tell, as another client may create the file
+// between the two. Servers report an existing file as a generic failure,
+// hence the Stat.
+func openTracked(client sftpClientInterface, name string, flag int) (sftpFileInterface, bool, error) {
+	if flag&os.O_CREATE == 0 {
+		file, err := openExisting(client, name, flag, nil)
+		return file, false, err
+	}
+	if flag&os.O_EXCL != 0 {
+		file, err := client.OpenFile(name, flag)
+		return file, err == nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		file, err := client.OpenFile(name, flag|os.O_EXCL)
+		if err == nil {
+			return file, true, nil
+		}
+		info, statErr := client.Stat(name)
+		if statErr != nil {
+			return nil, false, err
+		}
+		file, err = openExisting(client, name, flag&^os.O_CREATE, info)
+		if err != nil && errors.Is(err, os.ErrNotExist) && attempt < 2 {
+			continue
+		}
+		return file, false, err
+	}
+}
+
+// openExisting opens name with flag, refusing special files. info is the
+// file's Stat, or nil for openExisting to make it.
+func openExisting(client sftpClientInterface, name string, flag int, info os.FileInfo) (sftpFileInterface, error) {
+	if info == nil {
+		info, _ = client.Stat(name)
+	}
+	if info != nil && info.Mode()&specialModes != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrSpecialFile}
+	}
+	return client.OpenFile(name, flag)
+}
+
+// OpenFileNoFollow is like OpenFile but fails with an *os.PathError
+// wrapping syscall.ELOOP if name is a symbolic link, as os.O_NOFOLLOW does
+// locally; SFTP open requests have no such flag. The check is an Lstat made
+// just before the open, so it guards against links planted in advance but
+// not against one swapped in between the two requests. Servers that cannot
+// Lstat are refused with ErrUnsupported rather than trusted.
+func (fs *FileSystem) OpenFileNoFollow(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	name = fs.abs(name)
+	if err := fs.checkName("open", name); err != nil {
+		return nil, err
+	}
+	links, ok := fs.client.(sftpSymlinkReader)
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrUnsupported}
+	}
+	info, err := links.Lstat(name)
+	switch {
+	case err == nil && info.Mode()&os.ModeSymlink != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ELOOP}
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return fs.OpenFile(name, flag, perm)
+}
+
+// Reopen syncs and closes f, then opens its path again with the same flags,
+// less O_CREATE, O_EXCL and O_TRUNC, and read strategy. Servers backed by
+// caching or clustered storage may keep serving a handle's view of the file
+// after other handles change it; reading through the fresh handle sees
+// their writes. The new File starts at offset 0 and takes over any lock
+// held by f. f must not be used afterwards, even if Reopen fails.
+func (fs *FileSystem) Reopen(f *File) (*File, error) {
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	lock := f.lockName
+	f.lockName = ""
+	if err := f.Close(); err != nil {
+		if lock != "" {
+			fs.client.Remove(lock)
+		}
+		return nil, err
+	}
+	nf, err := fs.OpenFile(f.name, f.flag&^(os.O_CREATE|os.O_EXCL|os.O_TRUNC), 0)
+	if err != nil {
+		if lock != "" {
+			fs.client.Remove(lock)
+		}
+		return nil, err
+	}
+	file := nf.(*File)
+	file.lockName = lock
+	file.noReadAhead = f.noReadAhead
+	if f.rbuf != nil {
+		file.rbuf = bufio.NewReaderSize(fileReader{file}, f.rbuf.Size())
+	}
+	return file, nil
+}
+
+// CreateMode creates or truncates the named file and opens it for reading
+// and writing, like os.Create but with mode perm (less Config.Umask)
+// instead of 0666. A new file has perm before CreateMode returns, so
+// secrets written to it are never readable under a wider mode; an
+// existing file keeps its mode, as with OpenFile.
+func (fs *FileSystem) CreateMode(name string, perm os.FileMode) (*File, error) {
+	f, err := fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f.(*File), nil
+}
+
+// CreateWith creates or truncates the named file, writes content to it,
+// closes it, and returns the file's info. perm is applied only if the file
+// is newly created, as with OpenFile.
+func (fs *FileSystem) CreateWith(name string, content []byte, perm os.FileMode) (os.FileInfo, error) {
+	name = fs.abs(name)
+	f, err := fs.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
 	if err != nil {
 		return nil, err
 	}
-	return &File{file: file, name: name, client: fs.client}, nil
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return fs.Stat(name)
+}
+
+// ReadRange reads up to length bytes of the named file starting at off,
+// without fetching the rest of the file. If the range extends past the end
+// of the file the available bytes are returned with a nil error; if off is
+// at or beyond the end, ReadRange returns io.EOF.
+func (fs *FileSystem) ReadRange(name string, off, length int64) ([]byte, error) {
+	name = fs.abs(name)
+	if off < 0 || length < 0 {
+		return nil, &os.PathError{Op: "readrange", Path: name, Err: os.ErrInvalid}
+	}
+	f, err := fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := make([]byte, length)
+	n, err := f.(*File).ReadFullAt(b, off)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return b[:n], nil
+}
+
+// AppendLocked appends data to the named file, creating it with mode 0644 if
+// needed. Appends to the same path through this FileSystem and its clones
+// are serialized, and each writes at the file's current end rather than
+// relying on the server's append support, so concurrent appenders in one
+// process never interleave. Writers in other processes are not coordinated.
+func (fs *FileSystem) AppendLocked(name string, data []byte) error {
+	name = fs.abs(name)
+	mu, _ := fs.shared().appendLocks.LoadOrStore(name, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err == nil {
+		_, err = f.WriteAt(data, info.Size())
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
 // Mkdir creates a directory on the SFTP server.
+//
+// SFTP mkdir requests made by github.com/pkg/sftp carry no attributes, so
+// the directory is created with the server's default mode, which is then
+// stat'ed and changed to perm, less Config.Umask, only if it differs.
 func (fs *FileSystem) Mkdir(name string, perm os.FileMode) error {
-	return fs.client.Mkdir(name)
+	name = fs.abs(name)
+	if err := fs.checkName("mkdir", name); err != nil {
+		return err
+	}
+	if err := fs.client.Mkdir(name); err != nil {
+		return err
+	}
+	mode := fs.config.applyUmask(perm)
+	if info, err := fs.client.Stat(name); err == nil && info.Mode().Perm() == mode.Perm() {
+		return nil
+	}
+	return fs.client.Chmod(name, mode)
+}
+
+// MkdirAll creates the named directory along with any missing parents,
+// giving every directory it creates the mode perm, less Config.Umask.
+// Existing directories are left unchanged. If name is already a directory,
+// MkdirAll does nothing.
+func (fs *FileSystem) MkdirAll(name string, perm os.FileMode) error {
+	name = fs.abs(name)
+	if err := fs.checkName("mkdir", name); err != nil {
+		return err
+	}
+	if info, err := fs.client.Stat(name); err == nil {
+		if info.IsDir() {
+			return nil
+		}
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	if parent := path.Dir(name); parent != name && parent != "." {
+		if err := fs.MkdirAll(parent, perm); err != nil {
+			return err
+		}
+	}
+	if err := fs.Mkdir(name, perm); err != nil {
+		// Lost a race with another creator
+		if info, statErr := fs.client.Stat(name); statErr == nil && info.IsDir() {
+			return nil
+		}
+		return err
+	}
+	return nil
 }
 
 // Remove removes a file or empty directory from the SFTP server.
+//
+// Servers report a non-empty directory in different ways, so when removal
+// fails on a directory that still has entries the error is replaced by an
+// *os.PathError wrapping ErrDirNotEmpty.
 func (fs *FileSystem) Remove(name string) error {
-	return fs.client.Remove(name)
+	name = fs.abs(name)
+	if err := fs.checkName("remove", name); err != nil {
+		return err
+	}
+	fs.stats.removes.Add(1)
+	err := fs.client.Remove(name)
+	if err != nil && fs.isNonEmptyDir(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: ErrDirNotEmpty}
+	}
+	return err
+}
+
+// isNonEmptyDir reports whether name is a directory with at least one entry.
+func (fs *FileSystem) isNonEmptyDir(name string) bool {
+	info, err := fs.client.Stat(name)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+	entries, err := fs.client.ReadDir(name)
+	return err == nil && len(entries) > 0
 }
 
 // Rename renames a file on the SFTP server.
 func (fs *FileSystem) Rename(oldpath, newpath string) error {
+	oldpath, newpath = fs.abs(oldpath), fs.abs(newpath)
+	for _, name := range []string{oldpath, newpath} {
+		if err := fs.checkName("rename", name); err != nil {
+			return err
+		}
+	}
 	return fs.client.Rename(oldpath, newpath)
 }
 
+// Ping checks that the connection is still usable by making a cheap
+// request to the server.
+func (fs *FileSystem) Ping() error {
+	_, err := fs.client.Stat(".")
+	return err
+}
+
+// Link creates newname as a hard link to oldname using the
+// hardlink@openssh.com extension. If the server lacks it, or its filesystem
+// cannot link, the error wraps ErrUnsupported.
+func (fs *FileSystem) Link(oldname, newname string) error {
+	oldname, newname = fs.abs(oldname), fs.abs(newname)
+	for _, name := range []string{oldname, newname} {
+		if err := fs.checkName("link", name); err != nil {
+			return err
+		}
+	}
+	err := checkExtension(fs.client, extHardlink)
+	if err == nil {
+		if linker, ok := fs.client.(sftpLinker); ok {
+			err = unsupportedErr(linker.Link(oldname, newname))
+		} else {
+			err = ErrUnsupported
+		}
+	}
+	if err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
 // Stat returns file info for a file on the SFTP server.
-func (fs *FileSystem) Stat(name string) (os.FileInfo, error) {
-	return fs.client.Stat(name)
+func (fs *FileSystem) Stat(name string) (info os.FileInfo, err error) {
+	name = fs.abs(name)
+	if err := fs.checkName("stat", name); err != nil {
+		return nil, err
+	}
+	fs.stats.stats.Add(1)
+	err = fs.config.withRetry(func() error {
+		info, err = fs.client.Stat(name)
+		return err
+	})
+	return info, err
 }
 
 // Chmod changes the mode of a file on the SFTP server.
 func (fs *FileSystem) Chmod(name string, mode os.FileMode) error {
-	return fs.client.Chmod(name, mode)
+	name = fs.abs(name)
+	if err := fs.checkName("chmod", name); err != nil {
+		return err
+	}
+	return fs.config.withRetry(func() error {
+		return fs.client.Chmod(name, mode)
+	})
 }
 
 // Chtimes changes the access and modification times of a file on the SFTP server.
 func (fs *FileSystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	return fs.client.Chtimes(name, atime, mtime)
+	name = fs.abs(name)
+	if err := fs.checkName("chtimes", name); err != nil {
+		return err
+	}
+	return fs.config.withRetry(func() error {
+		return fs.client.Chtimes(name, atime, mtime)
+	})
 }
 
 // Chown changes the owner and group of a file on the SFTP server.
 func (fs *FileSystem) Chown(name string, uid, gid int) error {
-	return fs.client.Chown(name, uid, gid)
+	name = fs.abs(name)
+	if err := fs.checkName("chown", name); err != nil {
+		return err
+	}
+	return fs.config.withRetry(func() error {
+		return fs.client.Chown(name, uid, gid)
+	})
 }
 
-// ReadDir reads the directory named by name and returns a list of directory entries.
+// ReadDir reads the directory named by name and returns a list of directory
+// entries. If the listing fails partway, the entries received before the
+// error are returned along with it.
 func (fs *FileSystem) ReadDir(name string) (entries []iofs.DirEntry, err error) {
-	infos, err := fs.client.ReadDir(name)
-	if err != nil {
+	name = fs.abs(name)
+	if err := fs.checkName("readdir", name); err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	err = fs.config.withRetry(func() error {
+		infos, err = fs.client.ReadDir(name)
+		return err
+	})
+	if err != nil && len(infos) == 0 {
 		return nil, err
 	}
 
@@ -149,11 +745,43 @@ func (fs *FileSystem) ReadDir(name string) (entries []iofs.DirEntry, err error)
 	for i, info := range infos {
 		entries[i] = &dirEntry{info: info}
 	}
-	return entries, nil
+	return entries, err
+}
+
+// ReadDirFilter lists the named directory and returns only the entries for
+// which keep returns true, for example only directories or only names
+// ending in ".log". Filtering happens on the client as entries arrive, so
+// the full listing is never returned to the caller. As with ReadDir, entries
+// read before a mid-listing error are filtered and returned with it.
+func (fs *FileSystem) ReadDirFilter(name string, keep func(os.FileInfo) bool) (kept []os.FileInfo, err error) {
+	name = fs.abs(name)
+	if err := fs.checkName("readdir", name); err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	err = fs.config.withRetry(func() error {
+		infos, err = fs.client.ReadDir(name)
+		return err
+	})
+	for _, info := range infos {
+		if keep(info) {
+			kept = append(kept, info)
+		}
+	}
+	return kept, err
+}
+
+// ReadDirsOnly returns the subdirectories of the named directory, as a
+// tree view needs when expanding a node. SFTP cannot filter listings on the
+// server, so the full listing is still transferred and is filtered with
+// ReadDirFilter. Symlinks are left out even if they point to directories.
+func (fs *FileSystem) ReadDirsOnly(name string) ([]os.FileInfo, error) {
+	return fs.ReadDirFilter(name, os.FileInfo.IsDir)
 }
 
 // ReadFile reads the file named by name and returns the contents.
 func (fs *FileSystem) ReadFile(name string) ([]byte, error) {
+	name = fs.abs(name)
 	f, err := fs.OpenFile(name, os.O_RDONLY, 0)
 	if err != nil {
 		return nil, err
@@ -163,6 +791,31 @@ func (fs *FileSystem) ReadFile(name string) ([]byte, error) {
 	return io.ReadAll(f)
 }
 
+// WriteFile writes data to the named file, creating it with perm if needed
+// and truncating it otherwise, like os.WriteFile.
+func (fs *FileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	name = fs.abs(name)
+	f, err := fs.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	n, err := f.Write(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return fs.verifyUpload(name, int64(n))
+}
+
+// WriteString is like WriteFile but takes the content as a string, for
+// small text files such as configuration and markers.
+func (fs *FileSystem) WriteString(name, content string, perm os.FileMode) error {
+	return fs.WriteFile(name, []byte(content), perm)
+}
+
 // Sub returns an fs.FS corresponding to the subtree rooted at dir.
 func (fs *FileSystem) Sub(dir string) (iofs.FS, error) {
 	return absfs.FilerToFS(fs, dir)
@@ -171,6 +824,9 @@ func (fs *FileSystem) Sub(dir string) (iofs.FS, error) {
 // ErrNotDir is returned when a path is expected to be a directory but is not.
 var ErrNotDir = os.ErrInvalid
 
+// ErrDirNotEmpty is returned by Remove when the directory still has entries.
+var ErrDirNotEmpty = errors.New("directory not empty")
+
 // Dial creates a new SFTP filesystem by dialing the specified host.
 // This is a convenience function for simple password-based authentication.
 func Dial(host, user, password string) (*FileSystem, error) {
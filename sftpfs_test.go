@@ -2,13 +2,18 @@ package sftpfs
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/absfs/memfs"
 	"github.com/absfs/sftpfs/internal/mocks"
+	"github.com/pkg/sftp"
 )
 
 // mockSFTPClient is a test double for sftpClientInterface.
@@ -59,6 +64,9 @@ func (c *mockSFTPClient) OpenFile(path string, f int) (sftpFileInterface, error)
 	// Reset position for new open
 	file.Position = 0
 	file.Closed = false
+	if f&os.O_TRUNC != 0 {
+		file.Data = file.Data[:0]
+	}
 	return file, nil
 }
 
@@ -108,10 +116,14 @@ func (c *mockSFTPClient) Stat(path string) (os.FileInfo, error) {
 		return info, nil
 	}
 	if file, ok := c.files[path]; ok {
+		mode := file.Perm
+		if mode == 0 {
+			mode = 0644
+		}
 		return &mocks.MockFileInfo{
 			FileName: path,
 			FileSize: int64(len(file.Data)),
-			FileMode: 0644,
+			FileMode: mode,
 		}, nil
 	}
 	if _, ok := c.dirs[path]; ok {
@@ -359,6 +371,244 @@ func TestOpenFileCreate(t *testing.T) {
 	}
 }
 
+func TestOpenFileCreateMode(t *testing.T) {
+	mockClient := newMockSFTPClient()
+	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
+
+	file, err := fs.OpenFile("/secret.txt", os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer file.Close()
+
+	if got := mockClient.files["/secret.txt"].Perm; got != 0600 {
+		t.Errorf("Expected mode 0600 on the new handle, got %v", got)
+	}
+}
+
+func TestOpenFileCreateExistingKeepsMode(t *testing.T) {
+	mockClient := newMockSFTPClient()
+	mockClient.files["/existing.txt"] = &mocks.MockSFTPFile{Data: []byte("hello"), Perm: 0640}
+	fs := newWithClients(&exclClient{mockSFTPClient: mockClient}, &mocks.MockSSHClient{})
+
+	file, err := fs.OpenFile("/existing.txt", os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer file.Close()
+
+	if got := mockClient.files["/existing.txt"].Perm; got != 0640 {
+		t.Errorf("Opening an existing file changed its mode to %v", got)
+	}
+}
+
+// statCountingClient is an exclClient that counts Stat calls.
+type statCountingClient struct {
+	*exclClient
+	stats int
+}
+
+func (c *statCountingClient) Stat(path string) (os.FileInfo, error) {
+	c.stats++
+	return c.exclClient.Stat(path)
+}
+
+func TestOpenFileCreateNoStat(t *testing.T) {
+	mockClient := newMockSFTPClient()
+	mockClient.files["/fifo"] = &mocks.MockSFTPFile{}
+	mockClient.fileInfos["/fifo"] = &mocks.MockFileInfo{FileName: "fifo", FileMode: os.ModeNamedPipe | 0644}
+	client := &statCountingClient{exclClient: &exclClient{mockSFTPClient: mockClient}}
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+
+	file, err := fs.OpenFile("/new.txt", os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	file.Close()
+	if client.stats != 0 {
+		t.Errorf("Creating a new file made %d Stat calls, want 0", client.stats)
+	}
+	if got := mockClient.files["/new.txt"].Perm; got != 0600 {
+		t.Errorf("Expected mode 0600 on the new file, got %v", got)
+	}
+
+	// An existing special file is still refused when O_CREATE is set
+	_, err = fs.OpenFile("/fifo", os.O_CREATE|os.O_WRONLY, 0600)
+	if !errors.Is(err, ErrSpecialFile) {
+		t.Errorf("Expected ErrSpecialFile, got %v", err)
+	}
+}
+
+func TestCreateWith(t *testing.T) {
+	mockClient := newMockSFTPClient()
+	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
+
+	info, err := fs.CreateWith("/new.txt", []byte("hello world"), 0600)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info.Size() != 11 {
+		t.Errorf("Expected size 11, got %d", info.Size())
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	file := mockClient.files["/new.txt"]
+	if string(file.Data) != "hello world" {
+		t.Errorf("Expected content %q, got %q", "hello world", file.Data)
+	}
+	if !file.Closed {
+		t.Error("Expected file to be closed")
+	}
+}
+
+func TestCreateWithTruncates(t *testing.T) {
+	mockClient := newMockSFTPClient()
+	mockClient.files["/old.txt"] = &mocks.MockSFTPFile{Data: []byte("a much longer body")}
+	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
+
+	info, err := fs.CreateWith("/old.txt", []byte("short"), 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info.Size() != 5 {
+		t.Errorf("Expected size 5, got %d", info.Size())
+	}
+}
+
+func TestCreateWithError(t *testing.T) {
+	mockClient := newMockSFTPClient()
+	mockClient.openFileErr = errors.New("open error")
+	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
+
+	if _, err := fs.CreateWith("/new.txt", []byte("x"), 0644); err != mockClient.openFileErr {
+		t.Errorf("Expected open error, got %v", err)
+	}
+}
+
+func TestReadRange(t *testing.T) {
+	mockClient := newMockSFTPClient()
+	mockClient.files["/data.bin"] = &mocks.MockSFTPFile{Data: []byte("header:record:trailer")}
+	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
+
+	got, err := fs.ReadRange("/data.bin", 7, 6)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(got) != "record" {
+		t.Errorf("Expected %q, got %q", "record", got)
+	}
+	if !mockClient.files["/data.bin"].Closed {
+		t.Error("Expected file to be closed")
+	}
+}
+
+func TestReadRangeHitsEOF(t *testing.T) {
+	mockClient := newMockSFTPClient()
+	mockClient.files["/data.bin"] = &mocks.MockSFTPFile{Data: []byte("header:record:trailer")}
+	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
+
+	got, err := fs.ReadRange("/data.bin", 14, 100)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(got) != "trailer" {
+		t.Errorf("Expected %q, got %q", "trailer", got)
+	}
+}
+
+func TestReadRangeOutOfBounds(t *testing.T) {
+	mockClient := newMockSFTPClient()
+	mockClient.files["/data.bin"] = &mocks.MockSFTPFile{Data: []byte("short")}
+	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
+
+	if got, err := fs.ReadRange("/data.bin", 100, 10); err != io.EOF || len(got) != 0 {
+		t.Errorf("ReadRange past EOF = (%q, %v), want (\"\", io.EOF)", got, err)
+	}
+	if _, err := fs.ReadRange("/data.bin", -1, 10); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("Expected os.ErrInvalid for negative offset, got %v", err)
+	}
+	if _, err := fs.ReadRange("/missing.bin", 0, 10); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, got %v", err)
+	}
+}
+
+func TestAppendLocked(t *testing.T) {
+	mockClient := newMockSFTPClient()
+	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
+
+	const writers = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- fs.AppendLocked("/shared.log", []byte(fmt.Sprintf("line %02d from writer\n", i)))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("AppendLocked failed: %v", err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(mockClient.files["/shared.log"].Data), "\n"), "\n")
+	if len(lines) != writers {
+		t.Fatalf("Expected %d lines, got %d", writers, len(lines))
+	}
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		var n int
+		if _, err := fmt.Sscanf(line, "line %02d from writer", &n); err != nil || seen[line] {
+			t.Errorf("Corrupted or duplicate line %q", line)
+		}
+		seen[line] = true
+	}
+}
+
+func TestOpenFileCreateChmodError(t *testing.T) {
+	mockClient := newMockSFTPClient()
+	mockClient.files["/new.txt"] = &mocks.MockSFTPFile{ChmodErr: os.ErrPermission}
+	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
+
+	_, err := fs.OpenFile("/new.txt", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Expected permission error, got %v", err)
+	}
+	if !mockClient.files["/new.txt"].Closed {
+		t.Error("Handle was not closed after the failed chmod")
+	}
+}
+
+func TestOpenFileSpecialFile(t *testing.T) {
+	mockClient := newMockSFTPClient()
+	mockClient.files["/dev/tty"] = &mocks.MockSFTPFile{}
+	mockClient.fileInfos["/dev/tty"] = &mocks.MockFileInfo{FileName: "tty", FileMode: os.ModeDevice | os.ModeCharDevice | 0620}
+	mockClient.files["/fifo"] = &mocks.MockSFTPFile{}
+	mockClient.fileInfos["/fifo"] = &mocks.MockFileInfo{FileName: "fifo", FileMode: os.ModeNamedPipe | 0644}
+	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
+
+	for _, name := range []string{"/dev/tty", "/fifo"} {
+		_, err := fs.OpenFile(name, os.O_RDONLY, 0)
+		var pathErr *os.PathError
+		if !errors.Is(err, ErrSpecialFile) || !errors.As(err, &pathErr) || pathErr.Path != name {
+			t.Errorf("OpenFile(%q): expected *os.PathError wrapping ErrSpecialFile, got %v", name, err)
+		}
+	}
+
+	mockClient.files["/regular"] = &mocks.MockSFTPFile{}
+	mockClient.fileInfos["/regular"] = &mocks.MockFileInfo{FileName: "regular", FileMode: 0644}
+	f, err := fs.OpenFile("/regular", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile on a regular file failed: %v", err)
+	}
+	f.Close()
+}
+
 func TestOpenFileError(t *testing.T) {
 	mockClient := newMockSFTPClient()
 	mockClient.openFileErr = errors.New("open error")
@@ -482,6 +732,29 @@ func TestRemoveError(t *testing.T) {
 	}
 }
 
+func TestRemoveDirNotEmpty(t *testing.T) {
+	mockClient := newMockSFTPClient()
+	mockClient.dirs["/full"] = []os.FileInfo{&mocks.MockFileInfo{FileName: "a.txt"}}
+	mockClient.dirs["/empty"] = []os.FileInfo{}
+	mockClient.removeErr = &sftp.StatusError{Code: 4}
+
+	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
+
+	err := fs.Remove("/full")
+	if !errors.Is(err, ErrDirNotEmpty) {
+		t.Fatalf("Expected ErrDirNotEmpty, got %v", err)
+	}
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "/full" {
+		t.Errorf("Expected *os.PathError for /full, got %#v", err)
+	}
+
+	// A failure on an empty directory keeps the server's error
+	if err := fs.Remove("/empty"); errors.Is(err, ErrDirNotEmpty) || err != mockClient.removeErr {
+		t.Errorf("Expected server error for empty directory, got %v", err)
+	}
+}
+
 func TestRemoveNotExist(t *testing.T) {
 	mockClient := newMockSFTPClient()
 	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
@@ -690,6 +963,36 @@ func TestMkdir(t *testing.T) {
 	}
 }
 
+// chmodCountingClient counts the Chmod calls that reach the server.
+type chmodCountingClient struct {
+	*mockSFTPClient
+	chmods int
+}
+
+func (c *chmodCountingClient) Chmod(path string, mode os.FileMode) error {
+	c.chmods++
+	return c.mockSFTPClient.Chmod(path, mode)
+}
+
+func TestMkdirChmodsOnlyIfNeeded(t *testing.T) {
+	client := &chmodCountingClient{mockSFTPClient: newMockSFTPClient()}
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+
+	// The mock creates directories with mode 0755
+	if err := fs.Mkdir("/default", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if client.chmods != 0 {
+		t.Errorf("Mkdir with the server's mode made %d Chmod calls, want 0", client.chmods)
+	}
+	if err := fs.Mkdir("/private", 0700); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if client.chmods != 1 {
+		t.Errorf("Mkdir with a different mode made %d Chmod calls, want 1", client.chmods)
+	}
+}
+
 func TestMkdirAlreadyExists(t *testing.T) {
 	mockClient := newMockSFTPClient()
 	mockClient.dirs["/existingdir"] = []os.FileInfo{}
@@ -727,6 +1030,17 @@ func TestFileName(t *testing.T) {
 	}
 }
 
+func TestFileBaseName(t *testing.T) {
+	file := &File{file: &mocks.MockSFTPFile{}, name: "/home/user/docs/report.txt"}
+
+	if got := file.Name(); got != "/home/user/docs/report.txt" {
+		t.Errorf("Name() = %q, want full path", got)
+	}
+	if got := file.BaseName(); got != "report.txt" {
+		t.Errorf("BaseName() = %q, want %q", got, "report.txt")
+	}
+}
+
 func TestFileRead(t *testing.T) {
 	mockFile := &mocks.MockSFTPFile{Data: []byte("hello world")}
 	file := &File{file: mockFile, name: "/test.txt"}
@@ -817,6 +1131,95 @@ func TestFileReadAtEOF(t *testing.T) {
 	}
 }
 
+// partialReadFile returns at most chunk bytes per ReadAt call.
+type partialReadFile struct {
+	*mocks.MockSFTPFile
+	chunk int
+	calls int
+}
+
+func (f *partialReadFile) ReadAt(b []byte, off int64) (int, error) {
+	f.calls++
+	if len(b) > f.chunk {
+		b = b[:f.chunk]
+	}
+	n, err := f.MockSFTPFile.ReadAt(b, off)
+	if err == io.EOF && off+int64(n) < int64(len(f.Data)) {
+		err = nil
+	}
+	return n, err
+}
+
+func TestFileReadFullAt(t *testing.T) {
+	mockFile := &partialReadFile{MockSFTPFile: &mocks.MockSFTPFile{Data: []byte("hello world")}, chunk: 3}
+	file := &File{file: mockFile, name: "/test.txt"}
+
+	buf := make([]byte, 8)
+	n, err := file.ReadFullAt(buf, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != 8 || string(buf) != "llo worl" {
+		t.Errorf("Got %d bytes %q, want 8 bytes %q", n, buf[:n], "llo worl")
+	}
+	if mockFile.calls != 3 {
+		t.Errorf("Expected 3 partial reads, got %d", mockFile.calls)
+	}
+}
+
+func TestFileReadFullAtShort(t *testing.T) {
+	mockFile := &partialReadFile{MockSFTPFile: &mocks.MockSFTPFile{Data: []byte("hello world")}, chunk: 3}
+	file := &File{file: mockFile, name: "/test.txt"}
+
+	buf := make([]byte, 10)
+	n, err := file.ReadFullAt(buf, 6)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if n != 5 || string(buf[:n]) != "world" {
+		t.Errorf("Got %d bytes %q, want 5 bytes %q", n, buf[:n], "world")
+	}
+
+	n, err = file.ReadFullAt(buf, 20)
+	if err != io.EOF || n != 0 {
+		t.Errorf("Read past EOF = (%d, %v), want (0, io.EOF)", n, err)
+	}
+}
+
+func TestFilePeek(t *testing.T) {
+	mockFile := &mocks.MockSFTPFile{Data: []byte("\x89PNG\r\n\x1a\nimage data")}
+	file := &File{file: mockFile, name: "/image.png"}
+
+	header, err := file.Peek(8)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(header) != "\x89PNG\r\n\x1a\n" {
+		t.Errorf("Peek = %q, want PNG signature", header)
+	}
+
+	buf := make([]byte, 4)
+	if _, err := file.Read(buf); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(buf) != "\x89PNG" {
+		t.Errorf("Read after Peek = %q, want it to start at offset 0", buf)
+	}
+}
+
+func TestFilePeekShortFile(t *testing.T) {
+	mockFile := &mocks.MockSFTPFile{Data: []byte("abc")}
+	file := &File{file: mockFile, name: "/short.txt"}
+
+	got, err := file.Peek(8)
+	if err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+	if string(got) != "abc" {
+		t.Errorf("Peek = %q, want %q", got, "abc")
+	}
+}
+
 func TestFileWrite(t *testing.T) {
 	mockFile := &mocks.MockSFTPFile{Data: []byte{}}
 	file := &File{file: mockFile, name: "/test.txt"}
@@ -866,6 +1269,56 @@ func TestFileWriteAtError(t *testing.T) {
 	}
 }
 
+// holeyFile simulates a server that leaves garbage in the gap when a write
+// lands past the end of the file.
+type holeyFile struct {
+	*mocks.MockSFTPFile
+}
+
+func (f *holeyFile) WriteAt(b []byte, off int64) (int, error) {
+	for int64(len(f.Data)) < off {
+		f.Data = append(f.Data, 0xAA)
+	}
+	return f.MockSFTPFile.WriteAt(b, off)
+}
+
+func TestFileWriteAtFill(t *testing.T) {
+	mockFile := &holeyFile{&mocks.MockSFTPFile{}}
+	file := &File{file: mockFile, name: "/test.txt"}
+
+	const off = 100000
+	n, err := file.WriteAtFill([]byte("tail"), off)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("Expected 4 bytes written, got %d", n)
+	}
+	if len(mockFile.Data) != off+4 {
+		t.Fatalf("Expected size %d, got %d", off+4, len(mockFile.Data))
+	}
+	for i, b := range mockFile.Data[:off] {
+		if b != 0 {
+			t.Fatalf("Byte %d = %#x, want 0", i, b)
+		}
+	}
+	if string(mockFile.Data[off:]) != "tail" {
+		t.Errorf("Tail = %q, want %q", mockFile.Data[off:], "tail")
+	}
+}
+
+func TestFileWriteAtFillWithinFile(t *testing.T) {
+	mockFile := &holeyFile{&mocks.MockSFTPFile{Data: []byte("hello world")}}
+	file := &File{file: mockFile, name: "/test.txt"}
+
+	if _, err := file.WriteAtFill([]byte("WORLD"), 6); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(mockFile.Data) != "hello WORLD" {
+		t.Errorf("Got %q, want %q", mockFile.Data, "hello WORLD")
+	}
+}
+
 func TestFileWriteString(t *testing.T) {
 	mockFile := &mocks.MockSFTPFile{Data: []byte{}}
 	file := &File{file: mockFile, name: "/test.txt"}
@@ -1010,6 +1463,67 @@ func TestFileStatError(t *testing.T) {
 	}
 }
 
+// statCountingFile counts the Stat calls that reach the file.
+type statCountingFile struct {
+	*mocks.MockSFTPFile
+	stats int
+}
+
+func (f *statCountingFile) Stat() (os.FileInfo, error) {
+	f.stats++
+	return f.MockSFTPFile.Stat()
+}
+
+func TestFileStatCached(t *testing.T) {
+	mockFile := &statCountingFile{MockSFTPFile: &mocks.MockSFTPFile{Data: []byte("hello")}}
+	file := &File{file: mockFile, name: "/test.txt"}
+
+	for i := 0; i < 3; i++ {
+		if info, err := file.Stat(); err != nil || info.Size() != 5 {
+			t.Fatalf("Stat = %v, %v; want size 5", info, err)
+		}
+	}
+	if mockFile.stats != 1 {
+		t.Errorf("3 Stat calls reached the server %d times, want 1", mockFile.stats)
+	}
+
+	// Growth through another handle shows only after a refresh
+	mockFile.Data = append(mockFile.Data, " world"...)
+	if info, _ := file.Stat(); info.Size() != 5 {
+		t.Errorf("Stat size = %d, want the cached 5", info.Size())
+	}
+	if info, err := file.RefreshStat(); err != nil || info.Size() != 11 {
+		t.Errorf("RefreshStat = %v, %v; want size 11", info, err)
+	}
+	if info, _ := file.Stat(); info.Size() != 11 || mockFile.stats != 2 {
+		t.Errorf("Stat after refresh = size %d after %d fetches, want 11 after 2", info.Size(), mockFile.stats)
+	}
+}
+
+func TestFileStatInvalidatedByWrites(t *testing.T) {
+	mockFile := &statCountingFile{MockSFTPFile: &mocks.MockSFTPFile{}}
+	file := &File{file: mockFile, name: "/test.txt"}
+
+	file.Stat()
+	file.Write([]byte("hello"))
+	if info, err := file.Stat(); err != nil || info.Size() != 5 {
+		t.Errorf("Stat after Write = %v, %v; want size 5", info, err)
+	}
+	file.WriteAt([]byte("!"), 9)
+	if info, err := file.Stat(); err != nil || info.Size() != 10 {
+		t.Errorf("Stat after WriteAt = %v, %v; want size 10", info, err)
+	}
+	if err := file.Truncate(2); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	if info, err := file.Stat(); err != nil || info.Size() != 2 {
+		t.Errorf("Stat after Truncate = %v, %v; want size 2", info, err)
+	}
+	if mockFile.stats != 4 {
+		t.Errorf("Stat reached the server %d times, want 4", mockFile.stats)
+	}
+}
+
 func TestFileSync(t *testing.T) {
 	mockFile := &mocks.MockSFTPFile{}
 	file := &File{file: mockFile, name: "/test.txt"}
@@ -1021,6 +1535,58 @@ func TestFileSync(t *testing.T) {
 	}
 }
 
+func TestFileFlushNoBuffer(t *testing.T) {
+	mockFile := &mocks.MockSFTPFile{}
+	file := &File{file: mockFile, name: "/test.txt"}
+
+	file.Write([]byte("hello"))
+	if string(mockFile.Data) != "hello" {
+		t.Fatalf("Unbuffered write not sent immediately, got %q", mockFile.Data)
+	}
+
+	// Flush should be a no-op
+	if err := file.Flush(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(mockFile.Data) != "hello" {
+		t.Errorf("Flush changed data: got %q", mockFile.Data)
+	}
+}
+
+func TestFileFlushBuffered(t *testing.T) {
+	client := newMockSFTPClient()
+	fs := newWithClients(client, nil)
+	fs.config.WriteBufferSize = 64
+
+	f, err := fs.OpenFile("/test.txt", os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	file := f.(*File)
+
+	file.Write([]byte("hello "))
+	file.WriteString("world")
+	if len(client.files["/test.txt"].Data) != 0 {
+		t.Fatalf("Buffered bytes sent before Flush: %q", client.files["/test.txt"].Data)
+	}
+
+	if err := file.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if got := string(client.files["/test.txt"].Data); got != "hello world" {
+		t.Errorf("After Flush got %q, want %q", got, "hello world")
+	}
+
+	// Close flushes whatever is left
+	file.Write([]byte("!"))
+	if err := file.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if got := string(client.files["/test.txt"].Data); got != "hello world!" {
+		t.Errorf("After Close got %q, want %q", got, "hello world!")
+	}
+}
+
 func TestFileTruncate(t *testing.T) {
 	mockFile := &mocks.MockSFTPFile{Data: []byte("hello world")}
 	file := &File{file: mockFile, name: "/test.txt"}
@@ -1057,6 +1623,108 @@ func TestFileTruncateError(t *testing.T) {
 	}
 }
 
+// chtimesRecorder records the times passed to Chtimes.
+type chtimesRecorder struct {
+	*mockSFTPClient
+	calls        int
+	atime, mtime time.Time
+}
+
+func (c *chtimesRecorder) Chtimes(path string, atime, mtime time.Time) error {
+	c.calls++
+	c.atime, c.mtime = atime, mtime
+	return c.mockSFTPClient.Chtimes(path, atime, mtime)
+}
+
+func newSetAttrsFile() (*File, *mocks.MockSFTPFile, *chtimesRecorder) {
+	orig := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	mockFile := &mocks.MockSFTPFile{
+		Perm: 0644,
+		UID:  1000,
+		GID:  1000,
+		StatInfo: &mocks.MockFileInfo{
+			FileMode:    0644,
+			FileModTime: orig,
+			FileSys: &sftp.FileStat{
+				UID:   1000,
+				GID:   1000,
+				Atime: uint32(orig.Unix()),
+				Mtime: uint32(orig.Unix()),
+			},
+		},
+	}
+	client := &chtimesRecorder{mockSFTPClient: newMockSFTPClient()}
+	client.files["/test.txt"] = mockFile
+	return &File{file: mockFile, name: "/test.txt", client: client}, mockFile, client
+}
+
+func TestFileSetAttrs(t *testing.T) {
+	file, mockFile, client := newSetAttrsFile()
+
+	mode := os.FileMode(0600)
+	atime := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	mtime := time.Date(2022, 2, 3, 4, 5, 6, 0, time.UTC)
+	uid, gid := 1, 2
+	if err := file.SetAttrs(&mode, &atime, &mtime, &uid, &gid); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if mockFile.Perm != 0600 {
+		t.Errorf("Expected mode 0600, got %v", mockFile.Perm)
+	}
+	if mockFile.UID != 1 || mockFile.GID != 2 {
+		t.Errorf("Expected owner 1:2, got %d:%d", mockFile.UID, mockFile.GID)
+	}
+	if !client.atime.Equal(atime) || !client.mtime.Equal(mtime) {
+		t.Errorf("Expected times %v/%v, got %v/%v", atime, mtime, client.atime, client.mtime)
+	}
+}
+
+func TestFileSetAttrsPartial(t *testing.T) {
+	file, mockFile, client := newSetAttrsFile()
+	orig := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Only the mode
+	mode := os.FileMode(0600)
+	if err := file.SetAttrs(&mode, nil, nil, nil, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if mockFile.Perm != 0600 {
+		t.Errorf("Expected mode 0600, got %v", mockFile.Perm)
+	}
+	if mockFile.UID != 1000 || mockFile.GID != 1000 {
+		t.Errorf("Owner changed to %d:%d", mockFile.UID, mockFile.GID)
+	}
+	if client.calls != 0 {
+		t.Errorf("Expected no Chtimes call, got %d", client.calls)
+	}
+
+	// Only the gid and mtime; uid and atime keep their current values
+	gid := 50
+	mtime := time.Date(2022, 2, 3, 4, 5, 6, 0, time.UTC)
+	if err := file.SetAttrs(nil, nil, &mtime, nil, &gid); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if mockFile.UID != 1000 || mockFile.GID != 50 {
+		t.Errorf("Expected owner 1000:50, got %d:%d", mockFile.UID, mockFile.GID)
+	}
+	if !client.atime.Equal(orig) || !client.mtime.Equal(mtime) {
+		t.Errorf("Expected times %v/%v, got %v/%v", orig, mtime, client.atime, client.mtime)
+	}
+	if mockFile.Perm != 0600 {
+		t.Errorf("Mode changed to %v", mockFile.Perm)
+	}
+}
+
+func TestFileSetAttrsError(t *testing.T) {
+	file, mockFile, _ := newSetAttrsFile()
+	mockFile.ChownErr = errors.New("chown error")
+
+	uid := 1
+	if err := file.SetAttrs(nil, nil, nil, &uid, nil); err != mockFile.ChownErr {
+		t.Errorf("Expected chown error, got %v", err)
+	}
+}
+
 func TestFileReaddir(t *testing.T) {
 	mockClient := newMockSFTPClient()
 	mockClient.dirs["/testdir"] = []os.FileInfo{
@@ -1163,6 +1831,140 @@ func TestFileReaddirError(t *testing.T) {
 	}
 }
 
+// partialDirClient returns its entries together with err from ReadDir, like
+// a server that fails partway through a listing.
+type partialDirClient struct {
+	*mockSFTPClient
+	entries []os.FileInfo
+	err     error
+}
+
+func (c *partialDirClient) ReadDir(path string) ([]os.FileInfo, error) {
+	return c.entries, c.err
+}
+
+func TestReadDirPartialResults(t *testing.T) {
+	listErr := errors.New("connection lost")
+	client := &partialDirClient{
+		mockSFTPClient: newMockSFTPClient(),
+		entries: []os.FileInfo{
+			&mocks.MockFileInfo{FileName: "a.txt"},
+			&mocks.MockFileInfo{FileName: "b.txt"},
+		},
+		err: listErr,
+	}
+	fs := &FileSystem{client: client}
+
+	entries, err := fs.ReadDir("/dir")
+	if !errors.Is(err, listErr) {
+		t.Errorf("Expected %v, got %v", listErr, err)
+	}
+	if len(entries) != 2 || entries[0].Name() != "a.txt" || entries[1].Name() != "b.txt" {
+		t.Errorf("Expected the two entries read before the error, got %v", entries)
+	}
+
+	file := &File{file: &mocks.MockSFTPFile{}, name: "/dir", client: client}
+	infos, err := file.Readdir(-1)
+	if !errors.Is(err, listErr) || len(infos) != 2 {
+		t.Errorf("Readdir: expected 2 entries and %v, got %d and %v", listErr, len(infos), err)
+	}
+	names, err := file.Readdirnames(1)
+	if !errors.Is(err, listErr) || len(names) != 1 || names[0] != "a.txt" {
+		t.Errorf("Readdirnames: expected [a.txt] and %v, got %v and %v", listErr, names, err)
+	}
+	dirEntries, err := file.ReadDir(0)
+	if !errors.Is(err, listErr) || len(dirEntries) != 2 {
+		t.Errorf("File.ReadDir: expected 2 entries and %v, got %d and %v", listErr, len(dirEntries), err)
+	}
+}
+
+func TestReadDirFilter(t *testing.T) {
+	client := newMockSFTPClient()
+	client.dirs["/var/log"] = []os.FileInfo{
+		&mocks.MockFileInfo{FileName: "app.log"},
+		&mocks.MockFileInfo{FileName: "archive", FileIsDir: true},
+		&mocks.MockFileInfo{FileName: "notes.txt"},
+		&mocks.MockFileInfo{FileName: "db.log"},
+	}
+	fs := &FileSystem{client: client}
+
+	logs, err := fs.ReadDirFilter("/var/log", func(info os.FileInfo) bool {
+		return strings.HasSuffix(info.Name(), ".log")
+	})
+	if err != nil {
+		t.Fatalf("ReadDirFilter failed: %v", err)
+	}
+	if len(logs) != 2 || logs[0].Name() != "app.log" || logs[1].Name() != "db.log" {
+		t.Errorf("Expected [app.log db.log], got %v", logs)
+	}
+
+	dirs, err := fs.ReadDirFilter("/var/log", os.FileInfo.IsDir)
+	if err != nil {
+		t.Fatalf("ReadDirFilter failed: %v", err)
+	}
+	if len(dirs) != 1 || dirs[0].Name() != "archive" {
+		t.Errorf("Expected [archive], got %v", dirs)
+	}
+
+	client.readDirErr = errors.New("readdir error")
+	if _, err := fs.ReadDirFilter("/var/log", os.FileInfo.IsDir); err == nil {
+		t.Error("Expected error")
+	}
+}
+
+func TestReadDirsOnly(t *testing.T) {
+	client := newMockSFTPClient()
+	client.dirs["/srv"] = []os.FileInfo{
+		&mocks.MockFileInfo{FileName: "index.html"},
+		&mocks.MockFileInfo{FileName: "assets", FileIsDir: true, FileMode: os.ModeDir | 0755},
+		&mocks.MockFileInfo{FileName: "current", FileMode: os.ModeSymlink | 0777},
+		&mocks.MockFileInfo{FileName: "logs", FileIsDir: true, FileMode: os.ModeDir | 0755},
+		&mocks.MockFileInfo{FileName: "robots.txt"},
+	}
+	fs := &FileSystem{client: client}
+
+	dirs, err := fs.ReadDirsOnly("/srv")
+	if err != nil {
+		t.Fatalf("ReadDirsOnly failed: %v", err)
+	}
+	if len(dirs) != 2 || dirs[0].Name() != "assets" || dirs[1].Name() != "logs" {
+		t.Errorf("Expected [assets logs], got %v", dirs)
+	}
+}
+
+// noSeekEndFile rejects seeks relative to the end of the file.
+type noSeekEndFile struct {
+	*mocks.MockSFTPFile
+}
+
+func (f *noSeekEndFile) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekEnd {
+		return 0, errors.New("SeekEnd not supported")
+	}
+	return f.MockSFTPFile.Seek(offset, whence)
+}
+
+func TestFileSeekEndMethod(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		file func(*mocks.MockSFTPFile) sftpFileInterface
+	}{
+		{"seek", func(m *mocks.MockSFTPFile) sftpFileInterface { return m }},
+		{"stat fallback", func(m *mocks.MockSFTPFile) sftpFileInterface { return &noSeekEndFile{m} }},
+	} {
+		mockFile := &mocks.MockSFTPFile{Data: []byte("existing data")}
+		file := &File{file: tc.file(mockFile), name: "/log.txt"}
+
+		off, err := file.SeekEnd()
+		if err != nil {
+			t.Fatalf("%s: SeekEnd failed: %v", tc.name, err)
+		}
+		if off != int64(len("existing data")) || mockFile.Position != off {
+			t.Errorf("%s: SeekEnd = %d (position %d), want %d", tc.name, off, mockFile.Position, len("existing data"))
+		}
+	}
+}
+
 func TestFileReaddirnames(t *testing.T) {
 	mockClient := newMockSFTPClient()
 	mockClient.dirs["/testdir"] = []os.FileInfo{
@@ -1283,3 +2085,152 @@ func TestNewWithExplicitTimeout(t *testing.T) {
 		t.Errorf("Expected timeout to remain 5s, got %v", config.Timeout)
 	}
 }
+
+func TestReopen(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+
+	if err := fs.WriteFile("/data.txt", []byte("old content"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	f, err := fs.OpenSequential("/data.txt")
+	if err != nil {
+		t.Fatalf("OpenSequential failed: %v", err)
+	}
+	// Fill the read-ahead buffer with the old content
+	if _, err := f.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	w, err := fs.OpenFile("/data.txt", os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	if _, err := w.Write([]byte("new content")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	f, err = fs.Reopen(f)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	if f.rbuf == nil {
+		t.Error("Reopen dropped the sequential read strategy")
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if string(data) != "new content" {
+		t.Errorf("Read after Reopen = %q, want %q", data, "new content")
+	}
+
+	if err := f.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
+
+func TestWriteString(t *testing.T) {
+	client := newMockSFTPClient()
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+
+	if err := fs.WriteString("/VERSION", "1.4.2\n", 0640); err != nil {
+		t.Fatalf("WriteString failed: %v", err)
+	}
+	file := client.files["/VERSION"]
+	if string(file.Data) != "1.4.2\n" {
+		t.Errorf("Content = %q, want %q", file.Data, "1.4.2\n")
+	}
+	if file.Perm != 0640 {
+		t.Errorf("Mode = %v, want 0640", file.Perm)
+	}
+
+	// An existing file is truncated
+	if err := fs.WriteString("/VERSION", "2\n", 0640); err != nil {
+		t.Fatalf("WriteString failed: %v", err)
+	}
+	if string(file.Data) != "2\n" {
+		t.Errorf("Content after rewrite = %q, want %q", file.Data, "2\n")
+	}
+}
+
+func TestNewFromSSHClient(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+
+	sshClient, err := testDialSSH(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("SSH dial failed: %v", err)
+	}
+	defer sshClient.Close()
+
+	fs, err := NewFromSSHClient(sshClient)
+	if err != nil {
+		t.Fatalf("NewFromSSHClient failed: %v", err)
+	}
+	if err := fs.WriteFile("/hello.txt", []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// The borrowed connection is still usable after Close
+	again, err := NewFromSSHClient(sshClient)
+	if err != nil {
+		t.Fatalf("SSH connection closed with the FileSystem: %v", err)
+	}
+	defer again.Close()
+	if data, err := again.ReadFile("/hello.txt"); err != nil || string(data) != "hello" {
+		t.Errorf("ReadFile = %q, %v", data, err)
+	}
+}
+
+func TestCreateMode(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+
+	f, err := fs.CreateMode("/secret.txt", 0600)
+	if err != nil {
+		t.Fatalf("CreateMode failed: %v", err)
+	}
+	// The backing file has the mode before any data is written
+	if info, err := mfs.Stat("/secret.txt"); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Backing file before writing = %v, %v; want mode 0600", info, err)
+	}
+	if _, err := f.WriteString("token"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if data, err := fs.ReadFile("/secret.txt"); err != nil || string(data) != "token" {
+		t.Errorf("ReadFile = %q, %v", data, err)
+	}
+}
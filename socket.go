@@ -0,0 +1,69 @@
+package sftpfs
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// netDial opens the TCP connection that New runs SSH over when
+// Config.TCPKeepAlive or Config.NoDelay is set. Tests replace it to observe
+// the socket options applied.
+var netDial = net.DialTimeout
+
+// tcpSocket is the part of *net.TCPConn that socket options are set through.
+type tcpSocket interface {
+	SetKeepAlive(keepalive bool) error
+	SetKeepAlivePeriod(d time.Duration) error
+	SetNoDelay(noDelay bool) error
+}
+
+// dialSSH connects to addr with sshDial, or, when socket options are
+// configured, dials the TCP connection itself so they can be applied before
+// the SSH handshake.
+func (config *Config) dialSSH(network, addr string, sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	if config.TCPKeepAlive == 0 && !config.NoDelay {
+		return sshDial(network, addr, sshConfig)
+	}
+	conn, err := netDial(network, addr, sshConfig.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.applySocketOptions(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, sshConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// applySocketOptions sets TCPKeepAlive and NoDelay on conn. Connections
+// that are not TCP are left alone.
+func (config *Config) applySocketOptions(conn net.Conn) error {
+	sock, ok := conn.(tcpSocket)
+	if !ok {
+		return nil
+	}
+	switch {
+	case config.TCPKeepAlive > 0:
+		if err := sock.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := sock.SetKeepAlivePeriod(config.TCPKeepAlive); err != nil {
+			return err
+		}
+	case config.TCPKeepAlive < 0:
+		if err := sock.SetKeepAlive(false); err != nil {
+			return err
+		}
+	}
+	if config.NoDelay {
+		return sock.SetNoDelay(true)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package sftpfs
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+)
+
+// recordingConn records the socket options set on a TCP connection.
+type recordingConn struct {
+	*net.TCPConn
+	keepAlive       []bool
+	keepAlivePeriod time.Duration
+	noDelay         bool
+}
+
+func (c *recordingConn) SetKeepAlive(keepalive bool) error {
+	c.keepAlive = append(c.keepAlive, keepalive)
+	return c.TCPConn.SetKeepAlive(keepalive)
+}
+
+func (c *recordingConn) SetKeepAlivePeriod(d time.Duration) error {
+	c.keepAlivePeriod = d
+	return c.TCPConn.SetKeepAlivePeriod(d)
+}
+
+func (c *recordingConn) SetNoDelay(noDelay bool) error {
+	c.noDelay = noDelay
+	return c.TCPConn.SetNoDelay(noDelay)
+}
+
+func TestSocketOptions(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+
+	orig := netDial
+	defer func() { netDial = orig }()
+	var conn *recordingConn
+	netDial = func(network, addr string, timeout time.Duration) (net.Conn, error) {
+		c, err := orig(network, addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		conn = &recordingConn{TCPConn: c.(*net.TCPConn)}
+		return conn, nil
+	}
+
+	fs, err := New(&Config{
+		Host:         listener.Addr().String(),
+		User:         "testuser",
+		Password:     "testpass",
+		TCPKeepAlive: 20 * time.Second,
+		NoDelay:      true,
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := fs.Ping(); err != nil {
+		t.Errorf("Ping failed: %v", err)
+	}
+	fs.Close()
+	if conn == nil {
+		t.Fatal("The TCP connection was not dialed through netDial")
+	}
+	if len(conn.keepAlive) != 1 || !conn.keepAlive[0] || conn.keepAlivePeriod != 20*time.Second {
+		t.Errorf("Keepalive = %v with period %v, want enabled every 20s", conn.keepAlive, conn.keepAlivePeriod)
+	}
+	if !conn.noDelay {
+		t.Error("NoDelay was not set")
+	}
+
+	conn = nil
+	fs, err = New(&Config{Host: listener.Addr().String(), User: "testuser", Password: "testpass", TCPKeepAlive: -1})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	fs.Close()
+	if conn == nil || len(conn.keepAlive) != 1 || conn.keepAlive[0] || conn.noDelay {
+		t.Errorf("Negative TCPKeepAlive: got %+v, want keepalive disabled only", conn)
+	}
+
+	// Without socket options the usual dial path is used
+	conn = nil
+	fs, err = New(&Config{Host: listener.Addr().String(), User: "testuser", Password: "testpass"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	fs.Close()
+	if conn != nil {
+		t.Error("netDial was used without socket options")
+	}
+}
@@ -0,0 +1,139 @@
+package sftpfs
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// FileStatExtended holds the POSIX attributes reported by the SFTP server
+// for a file.
+type FileStatExtended struct {
+	Name  string
+	Size  int64
+	Mode  os.FileMode
+	UID   uint32
+	GID   uint32
+	Atime time.Time
+	Mtime time.Time
+}
+
+// StatExtended returns the SFTP attributes of the named file, saving callers
+// from type-asserting Stat's Sys() value to *sftp.FileStat. If the server
+// did not supply raw attributes, UID and GID are zero and Atime equals Mtime.
+func (fs *FileSystem) StatExtended(name string) (*FileStatExtended, error) {
+	name = fs.abs(name)
+	info, err := fs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return statExtended(info), nil
+}
+
+// statExtended builds a FileStatExtended from info.
+func statExtended(info os.FileInfo) *FileStatExtended {
+	st := &FileStatExtended{
+		Name:  info.Name(),
+		Size:  info.Size(),
+		Mode:  info.Mode(),
+		Atime: info.ModTime(),
+		Mtime: info.ModTime(),
+	}
+	if raw, ok := info.Sys().(*sftp.FileStat); ok {
+		st.UID = raw.UID
+		st.GID = raw.GID
+		st.Atime = time.Unix(int64(raw.Atime), 0)
+		st.Mtime = time.Unix(int64(raw.Mtime), 0)
+	}
+	return st
+}
+
+// StatN is like Stat but follows at most maxHops symbolic links, one at a
+// time, returning the info of the first path that is not a link together
+// with that path. If the chain is longer, it fails with an *os.PathError
+// wrapping syscall.ELOOP and returns the last link reached instead, so the
+// chain can be inspected step by step. Relative link targets are resolved
+// against the directory of the link.
+func (fs *FileSystem) StatN(name string, maxHops int) (os.FileInfo, string, error) {
+	name = fs.abs(name)
+	if err := fs.checkName("stat", name); err != nil {
+		return nil, "", err
+	}
+	links, ok := fs.client.(sftpSymlinkReader)
+	if !ok {
+		return nil, "", &os.PathError{Op: "stat", Path: name, Err: ErrUnsupported}
+	}
+	p := name
+	for hops := 0; ; hops++ {
+		fs.stats.stats.Add(1)
+		info, err := links.Lstat(p)
+		if err != nil {
+			return nil, "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return info, p, nil
+		}
+		if hops == maxHops {
+			return nil, p, &os.PathError{Op: "stat", Path: name, Err: syscall.ELOOP}
+		}
+		target, err := links.ReadLink(p)
+		if err != nil {
+			return nil, "", err
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+		p = target
+	}
+}
+
+// DeviceAttribute is the type of the SFTP extended attribute SameFilesystem
+// reads a file's device identifier from. SFTPv3 has no standard device
+// field, so only servers that add an extended attribute of this type, with
+// the decimal st_dev as its data, can be compared.
+const DeviceAttribute = "st_dev"
+
+// ErrDeviceUnknown is returned by SameFilesystem when the server does not
+// report device identifiers.
+var ErrDeviceUnknown = errors.New("device identifier not reported by server")
+
+// SameFilesystem reports whether a and b reside on the same device on the
+// server, which tells whether Rename can move a file between them or a copy
+// is needed. It compares the DeviceAttribute extended attributes of both
+// paths and returns ErrDeviceUnknown if either lacks one.
+func (fs *FileSystem) SameFilesystem(a, b string) (bool, error) {
+	a, b = fs.abs(a), fs.abs(b)
+	var devs [2]uint64
+	for i, name := range []string{a, b} {
+		info, err := fs.Stat(name)
+		if err != nil {
+			return false, err
+		}
+		dev, ok := deviceID(info)
+		if !ok {
+			return false, &os.PathError{Op: "samefilesystem", Path: name, Err: ErrDeviceUnknown}
+		}
+		devs[i] = dev
+	}
+	return devs[0] == devs[1], nil
+}
+
+// deviceID returns the device identifier in info's extended attributes.
+func deviceID(info os.FileInfo) (uint64, bool) {
+	raw, ok := info.Sys().(*sftp.FileStat)
+	if !ok {
+		return 0, false
+	}
+	for _, ext := range raw.Extended {
+		if ext.ExtType == DeviceAttribute {
+			dev, err := strconv.ParseUint(ext.ExtData, 10, 64)
+			return dev, err == nil
+		}
+	}
+	return 0, false
+}
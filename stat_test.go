@@ -0,0 +1,200 @@
+package sftpfs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+	"github.com/pkg/sftp"
+)
+
+func TestStatExtended(t *testing.T) {
+	atime := time.Unix(1700000000, 0)
+	mtime := time.Unix(1700001000, 0)
+
+	mockClient := newMockSFTPClient()
+	mockClient.fileInfos["/data.bin"] = &mocks.MockFileInfo{
+		FileName:    "data.bin",
+		FileSize:    42,
+		FileMode:    0640,
+		FileModTime: mtime,
+		FileSys: &sftp.FileStat{
+			Size:  42,
+			Mode:  0640,
+			UID:   1001,
+			GID:   2002,
+			Atime: uint32(atime.Unix()),
+			Mtime: uint32(mtime.Unix()),
+		},
+	}
+	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
+
+	st, err := fs.StatExtended("/data.bin")
+	if err != nil {
+		t.Fatalf("StatExtended failed: %v", err)
+	}
+	if st.UID != 1001 || st.GID != 2002 {
+		t.Errorf("UID/GID = %d/%d, want 1001/2002", st.UID, st.GID)
+	}
+	if !st.Atime.Equal(atime) {
+		t.Errorf("Atime = %v, want %v", st.Atime, atime)
+	}
+	if !st.Mtime.Equal(mtime) {
+		t.Errorf("Mtime = %v, want %v", st.Mtime, mtime)
+	}
+	if st.Size != 42 || st.Mode != 0640 || st.Name != "data.bin" {
+		t.Errorf("Got size %d mode %v name %q", st.Size, st.Mode, st.Name)
+	}
+}
+
+func TestStatExtendedWithoutRawAttrs(t *testing.T) {
+	mtime := time.Unix(1700001000, 0)
+
+	mockClient := newMockSFTPClient()
+	mockClient.fileInfos["/plain.txt"] = &mocks.MockFileInfo{
+		FileName:    "plain.txt",
+		FileSize:    7,
+		FileMode:    0644,
+		FileModTime: mtime,
+	}
+	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
+
+	st, err := fs.StatExtended("/plain.txt")
+	if err != nil {
+		t.Fatalf("StatExtended failed: %v", err)
+	}
+	if st.UID != 0 || st.GID != 0 {
+		t.Errorf("Expected zero UID/GID, got %d/%d", st.UID, st.GID)
+	}
+	if !st.Atime.Equal(mtime) {
+		t.Errorf("Atime = %v, want mtime %v", st.Atime, mtime)
+	}
+}
+
+func TestStatExtendedNotExist(t *testing.T) {
+	fs := newWithClients(newMockSFTPClient(), &mocks.MockSSHClient{})
+
+	if _, err := fs.StatExtended("/missing"); !os.IsNotExist(err) {
+		t.Errorf("Expected not-exist error, got %v", err)
+	}
+}
+
+func TestSameFilesystem(t *testing.T) {
+	withDev := func(name, dev string) os.FileInfo {
+		return &mocks.MockFileInfo{
+			FileName: name,
+			FileSys: &sftp.FileStat{
+				Extended: []sftp.StatExtended{{ExtType: DeviceAttribute, ExtData: dev}},
+			},
+		}
+	}
+	mockClient := newMockSFTPClient()
+	mockClient.fileInfos["/home/a"] = withDev("a", "2049")
+	mockClient.fileInfos["/home/b"] = withDev("b", "2049")
+	mockClient.fileInfos["/mnt/c"] = withDev("c", "2065")
+	mockClient.fileInfos["/plain"] = &mocks.MockFileInfo{FileName: "plain"}
+	fs := newWithClients(mockClient, &mocks.MockSSHClient{})
+
+	same, err := fs.SameFilesystem("/home/a", "/home/b")
+	if err != nil || !same {
+		t.Errorf("SameFilesystem(/home/a, /home/b) = %v, %v; want true", same, err)
+	}
+	same, err = fs.SameFilesystem("/home/a", "/mnt/c")
+	if err != nil || same {
+		t.Errorf("SameFilesystem(/home/a, /mnt/c) = %v, %v; want false", same, err)
+	}
+	if _, err := fs.SameFilesystem("/home/a", "/plain"); !errors.Is(err, ErrDeviceUnknown) {
+		t.Errorf("Expected ErrDeviceUnknown, got %v", err)
+	}
+}
+
+// symlinkClient adds symbolic links, from link path to target, to the mock.
+type symlinkClient struct {
+	*mockSFTPClient
+	links map[string]string
+}
+
+func (c *symlinkClient) Lstat(path string) (os.FileInfo, error) {
+	if _, ok := c.links[path]; ok {
+		return &mocks.MockFileInfo{FileName: path, FileMode: os.ModeSymlink | 0777}, nil
+	}
+	return c.mockSFTPClient.Stat(path)
+}
+
+func (c *symlinkClient) ReadLink(path string) (string, error) {
+	target, ok := c.links[path]
+	if !ok {
+		return "", os.ErrInvalid
+	}
+	return target, nil
+}
+
+func TestStatN(t *testing.T) {
+	client := &symlinkClient{mockSFTPClient: newMockSFTPClient(), links: map[string]string{
+		"/links/first":  "second",
+		"/links/second": "/data/target.txt",
+	}}
+	client.files["/data/target.txt"] = &mocks.MockSFTPFile{Data: []byte("target")}
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+
+	info, final, err := fs.StatN("/links/first", 3)
+	if err != nil {
+		t.Fatalf("StatN with 3 hops failed: %v", err)
+	}
+	if final != "/data/target.txt" || info.Size() != 6 {
+		t.Errorf("StatN = %q with size %d, want /data/target.txt with size 6", final, info.Size())
+	}
+
+	_, final, err = fs.StatN("/links/first", 1)
+	if !errors.Is(err, syscall.ELOOP) {
+		t.Fatalf("StatN with 1 hop: expected ELOOP, got %v", err)
+	}
+	if final != "/links/second" {
+		t.Errorf("StatN with 1 hop stopped at %q, want /links/second", final)
+	}
+
+	if _, final, err := fs.StatN("/data/target.txt", 0); err != nil || final != "/data/target.txt" {
+		t.Errorf("StatN of a regular file = %q, %v", final, err)
+	}
+}
+
+func TestStatNUnsupported(t *testing.T) {
+	fs := newWithClients(newMockSFTPClient(), &mocks.MockSSHClient{})
+	if _, _, err := fs.StatN("/anything", 1); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestOpenFileNoFollow(t *testing.T) {
+	client := &symlinkClient{mockSFTPClient: newMockSFTPClient(), links: map[string]string{
+		"/shared/report.txt": "/etc/passwd",
+	}}
+	client.files["/shared/data.txt"] = &mocks.MockSFTPFile{Data: []byte("data")}
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+
+	if _, err := fs.OpenFileNoFollow("/shared/report.txt", os.O_WRONLY|os.O_CREATE, 0644); !errors.Is(err, syscall.ELOOP) {
+		t.Errorf("Opening a symlink: expected ELOOP, got %v", err)
+	}
+	if _, ok := client.files["/etc/passwd"]; ok {
+		t.Error("The symlink target was opened")
+	}
+
+	f, err := fs.OpenFileNoFollow("/shared/data.txt", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("Opening a regular file failed: %v", err)
+	}
+	f.Close()
+	f, err = fs.OpenFileNoFollow("/shared/new.txt", os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("Creating a new file failed: %v", err)
+	}
+	f.Close()
+
+	plain := newWithClients(newMockSFTPClient(), &mocks.MockSSHClient{})
+	if _, err := plain.OpenFileNoFollow("/shared/data.txt", os.O_RDONLY, 0); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Without Lstat support: expected ErrUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package sftpfs
+
+import (
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statCache holds recent Stat and List results of a backing filesystem for
+// ServerConfig.StatCacheTTL. A nil *statCache caches nothing.
+type statCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu        sync.Mutex
+	stats     map[string]cachedStat
+	lists     map[string]cachedList
+	nextSweep time.Time
+}
+
+type cachedStat struct {
+	info    os.FileInfo
+	expires time.Time
+}
+
+type cachedList struct {
+	entries []os.FileInfo
+	expires time.Time
+}
+
+func newStatCache(ttl time.Duration) *statCache {
+	return &statCache{
+		ttl:   ttl,
+		now:   time.Now,
+		stats: make(map[string]cachedStat),
+		lists: make(map[string]cachedList),
+	}
+}
+
+// stat returns the cached info of name, if fresh.
+func (c *statCache) stat(name string) (os.FileInfo, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.stats[name]
+	if !ok || !c.now().Before(e.expires) {
+		return nil, false
+	}
+	return e.info, true
+}
+
+// putStat caches info for name.
+func (c *statCache) putStat(name string, info os.FileInfo) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep()
+	c.stats[name] = cachedStat{info: info, expires: c.now().Add(c.ttl)}
+}
+
+// list returns the cached entries of the directory name, if fresh. The
+// slice is shared and must not be modified.
+func (c *statCache) list(name string) ([]os.FileInfo, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.lists[name]
+	if !ok || !c.now().Before(e.expires) {
+		return nil, false
+	}
+	return e.entries, true
+}
+
+// putList caches the entries of the directory name.
+func (c *statCache) putList(name string, entries []os.FileInfo) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep()
+	c.lists[name] = cachedList{entries: entries, expires: c.now().Add(c.ttl)}
+}
+
+// invalidate forgets everything cached about name, the paths beneath it
+// and the listing of its parent, after name was changed, removed or
+// renamed.
+func (c *statCache) invalidate(name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	for p := range c.stats {
+		if p == name || strings.HasPrefix(p, prefix) {
+			delete(c.stats, p)
+		}
+	}
+	for p := range c.lists {
+		if p == name || strings.HasPrefix(p, prefix) {
+			delete(c.lists, p)
+		}
+	}
+	delete(c.lists, path.Dir(name))
+}
+
+// invalidateFile is like invalidate for a regular file being written,
+// without the scan for paths beneath it.
+func (c *statCache) invalidateFile(name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.stats, name)
+	delete(c.lists, path.Dir(name))
+}
+
+// sweep drops expired entries at most once per ttl, so paths that are
+// never looked up again do not accumulate. c.mu must be held.
+func (c *statCache) sweep() {
+	now := c.now()
+	if now.Before(c.nextSweep) {
+		return
+	}
+	c.nextSweep = now.Add(c.ttl)
+	for p, e := range c.stats {
+		if !now.Before(e.expires) {
+			delete(c.stats, p)
+		}
+	}
+	for p, e := range c.lists {
+		if !now.Before(e.expires) {
+			delete(c.lists, p)
+		}
+	}
+}
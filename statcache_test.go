@@ -0,0 +1,58 @@
+package sftpfs
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+)
+
+func TestStatCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := newStatCache(time.Second)
+	c.now = func() time.Time { return now }
+
+	info := &mocks.MockFileInfo{FileName: "a"}
+	c.putStat("/d/a", info)
+	c.putStat("/d/sub/b", info)
+	c.putList("/d", []os.FileInfo{info})
+	c.putList("/d/sub", []os.FileInfo{info})
+	if got, ok := c.stat("/d/a"); !ok || got != info {
+		t.Error("Fresh stat entry missed")
+	}
+	if _, ok := c.list("/d"); !ok {
+		t.Error("Fresh list entry missed")
+	}
+
+	c.invalidateFile("/d/a")
+	if _, ok := c.stat("/d/a"); ok {
+		t.Error("invalidateFile kept the file's entry")
+	}
+	if _, ok := c.list("/d"); ok {
+		t.Error("invalidateFile kept the parent listing")
+	}
+	if _, ok := c.list("/d/sub"); !ok {
+		t.Error("invalidateFile dropped an unrelated listing")
+	}
+
+	c.invalidate("/d/sub")
+	if _, ok := c.stat("/d/sub/b"); ok {
+		t.Error("invalidate kept an entry beneath the path")
+	}
+	if _, ok := c.list("/d/sub"); ok {
+		t.Error("invalidate kept the path's listing")
+	}
+
+	c.putStat("/d/a", info)
+	now = now.Add(time.Second)
+	if _, ok := c.stat("/d/a"); ok {
+		t.Error("Expired entry was returned")
+	}
+
+	var nilCache *statCache
+	nilCache.putStat("/x", info)
+	if _, ok := nilCache.stat("/x"); ok {
+		t.Error("Nil cache returned an entry")
+	}
+}
@@ -0,0 +1,54 @@
+package sftpfs
+
+import "strconv"
+
+// ConnState describes the state of a FileSystem's connection to the server.
+type ConnState int
+
+const (
+	// Connecting is reported before the first dial attempt.
+	Connecting ConnState = iota
+	// Connected is reported once the SSH and SFTP sessions are established.
+	Connected
+	// Reconnecting is reported before each retried dial attempt.
+	Reconnecting
+	// Disconnected is reported when the connection cannot be established,
+	// or when it drops without Close having been called.
+	Disconnected
+	// Closed is reported when Close is called.
+	Closed
+)
+
+// String returns the name of the state.
+func (s ConnState) String() string {
+	switch s {
+	case Connecting:
+		return "Connecting"
+	case Connected:
+		return "Connected"
+	case Reconnecting:
+		return "Reconnecting"
+	case Disconnected:
+		return "Disconnected"
+	case Closed:
+		return "Closed"
+	}
+	return "ConnState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// setState reports a state transition to Config.OnStateChange, if set.
+func (config *Config) setState(state ConnState) {
+	if config.OnStateChange != nil {
+		config.OnStateChange(state)
+	}
+}
+
+// watchConnection waits for the SSH connection to end and reports
+// Disconnected unless the end was caused by Close on fs or one of its
+// clones.
+func (fs *FileSystem) watchConnection(conn interface{ Wait() error }) {
+	conn.Wait()
+	if !fs.shared().closed.Load() {
+		fs.config.setState(Disconnected)
+	}
+}
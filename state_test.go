@@ -0,0 +1,132 @@
+package sftpfs
+
+import (
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestConnStateString(t *testing.T) {
+	if got := Reconnecting.String(); got != "Reconnecting" {
+		t.Errorf("Reconnecting.String() = %q", got)
+	}
+	if got := ConnState(99).String(); got != "ConnState(99)" {
+		t.Errorf("ConnState(99).String() = %q", got)
+	}
+}
+
+func TestOnStateChange(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+
+	// The first dial fails so New retries; the second keeps hold of the
+	// raw connection so the test can drop it.
+	orig := sshDial
+	defer func() { sshDial = orig }()
+	var conn net.Conn
+	dials := 0
+	sshDial = func(network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+		if dials++; dials == 1 {
+			return nil, errServerBusy
+		}
+		c, err := net.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		conn = c
+		sc, chans, reqs, err := ssh.NewClientConn(c, addr, config)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		return ssh.NewClient(sc, chans, reqs), nil
+	}
+
+	var mu sync.Mutex
+	var states []ConnState
+	dropped := make(chan struct{})
+	fs, err := New(&Config{
+		Host:           listener.Addr().String(),
+		User:           "testuser",
+		Password:       "testpass",
+		Retries:        1,
+		RetryDelay:     time.Millisecond,
+		RetryableError: func(error) bool { return true },
+		OnStateChange: func(state ConnState) {
+			mu.Lock()
+			states = append(states, state)
+			mu.Unlock()
+			if state == Disconnected {
+				close(dropped)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	conn.Close()
+	select {
+	case <-dropped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for Disconnected")
+	}
+	fs.Close()
+	fs.Close()
+
+	want := []ConnState{Connecting, Reconnecting, Connected, Disconnected, Closed}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(states, want) {
+		t.Errorf("States = %v, want %v", states, want)
+	}
+}
+
+func TestOnStateChangeCloseNotDisconnected(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+
+	// Closing a clone closes the shared connection, which is not a drop
+	for _, viaClone := range []bool{false, true} {
+		var mu sync.Mutex
+		var states []ConnState
+		fs, err := New(&Config{
+			Host:     listener.Addr().String(),
+			User:     "testuser",
+			Password: "testpass",
+			OnStateChange: func(state ConnState) {
+				mu.Lock()
+				states = append(states, state)
+				mu.Unlock()
+			},
+		})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		if viaClone {
+			fs.Clone().Close()
+		}
+		fs.Close()
+		time.Sleep(50 * time.Millisecond)
+
+		want := []ConnState{Connecting, Connected, Closed}
+		mu.Lock()
+		if !reflect.DeepEqual(states, want) {
+			t.Errorf("viaClone=%v: States = %v, want %v", viaClone, states, want)
+		}
+		mu.Unlock()
+	}
+}
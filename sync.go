@@ -0,0 +1,329 @@
+package sftpfs
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultSyncWorkers is the number of concurrent uploads used when
+// SyncOptions.Workers is 0.
+const DefaultSyncWorkers = 4
+
+// SyncPhase identifies what UploadDir or SyncDir is doing when it reports
+// progress.
+type SyncPhase int
+
+const (
+	SyncScanning  SyncPhase = iota // Walking the local tree
+	SyncUploading                  // Copying files to the server
+	SyncDeleting                   // Removing remote entries missing locally
+	SyncDone                       // Finished; the event carries the totals
+)
+
+func (p SyncPhase) String() string {
+	switch p {
+	case SyncScanning:
+		return "scanning"
+	case SyncUploading:
+		return "uploading"
+	case SyncDeleting:
+		return "deleting"
+	case SyncDone:
+		return "done"
+	}
+	return "unknown"
+}
+
+// ProgressEvent describes one step of an UploadDir or SyncDir run.
+type ProgressEvent struct {
+	Phase      SyncPhase
+	File       string // Local path scanned, or remote path uploaded or deleted; empty for SyncDone
+	BytesDone  int64  // Bytes uploaded so far
+	FilesDone  int    // Files uploaded so far
+	TotalFiles int    // Files found by the scan so far
+	Deleted    int    // Remote entries removed so far
+}
+
+// SyncStats summarizes a finished UploadDir or SyncDir run.
+type SyncStats struct {
+	Files   int   // Files uploaded
+	Bytes   int64 // Bytes uploaded
+	Deleted int   // Remote entries removed by SyncDir
+	Linked  int   // Files created as hard links by UploadDedup
+}
+
+// SyncOptions configures UploadDir and SyncDir. The zero value is valid.
+type SyncOptions struct {
+	// Workers is the number of files uploaded concurrently.
+	// Default: DefaultSyncWorkers
+	Workers int
+
+	// Progress, if set, is called for each file scanned, uploaded and
+	// deleted, and once more with Phase SyncDone when the run ends. Calls
+	// are made from a single goroutine, one at a time and in order.
+	Progress func(ProgressEvent)
+}
+
+// progressReporter serializes ProgressEvents from many goroutines onto a
+// single goroutine running the callback. A nil *progressReporter discards
+// events.
+type progressReporter struct {
+	events chan ProgressEvent
+	done   chan struct{}
+}
+
+func newProgressReporter(fn func(ProgressEvent)) *progressReporter {
+	if fn == nil {
+		return nil
+	}
+	p := &progressReporter{events: make(chan ProgressEvent), done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		for ev := range p.events {
+			fn(ev)
+		}
+	}()
+	return p
+}
+
+func (p *progressReporter) send(ev ProgressEvent) {
+	if p != nil {
+		p.events <- ev
+	}
+}
+
+// close waits for every sent event to be delivered.
+func (p *progressReporter) close() {
+	if p != nil {
+		close(p.events)
+		<-p.done
+	}
+}
+
+// UploadDir copies the local directory tree localRoot to remoteRoot,
+// creating remote directories as needed and overwriting existing files.
+// Only directories and regular files are copied. Files are uploaded
+// concurrently; a failed file does not stop the others, and all errors are
+// returned joined together.
+func (fs *FileSystem) UploadDir(localRoot, remoteRoot string, opts *SyncOptions) (SyncStats, error) {
+	return fs.syncDir(localRoot, remoteRoot, opts, false)
+}
+
+// SyncDir is like UploadDir, but afterwards also removes every remote entry
+// under remoteRoot that has no counterpart in localRoot.
+func (fs *FileSystem) SyncDir(localRoot, remoteRoot string, opts *SyncOptions) (SyncStats, error) {
+	return fs.syncDir(localRoot, remoteRoot, opts, true)
+}
+
+// syncEntry is a local file or directory and its remote destination.
+type syncEntry struct {
+	local, remote string
+	mode          os.FileMode
+}
+
+func (fs *FileSystem) syncDir(localRoot, remoteRoot string, opts *SyncOptions, prune bool) (SyncStats, error) {
+	remoteRoot = fs.abs(remoteRoot)
+	if opts == nil {
+		opts = &SyncOptions{}
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultSyncWorkers
+	}
+	progress := newProgressReporter(opts.Progress)
+	defer progress.close()
+
+	dirs, files, present, err := scanLocal(localRoot, remoteRoot, progress)
+	if err != nil {
+		return SyncStats{}, err
+	}
+	if err := fs.mkdirs(dirs); err != nil {
+		return SyncStats{}, err
+	}
+
+	var (
+		mu    sync.Mutex
+		stats SyncStats
+		errs  []error
+		wg    sync.WaitGroup
+	)
+	jobs := make(chan syncEntry)
+	for i := 0; i < min(workers, len(files)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range jobs {
+				n, err := fs.uploadLocal(e)
+				// Send while holding mu so the callback sees the
+				// totals in increasing order
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					stats.Files++
+					stats.Bytes += n
+				}
+				progress.send(ProgressEvent{Phase: SyncUploading, File: e.remote, BytesDone: stats.Bytes, FilesDone: stats.Files, TotalFiles: len(files)})
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, e := range files {
+		jobs <- e
+	}
+	close(jobs)
+	wg.Wait()
+
+	if prune {
+		var extra []string
+		fs.walk(remoteRoot, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				errs = append(errs, err)
+				return skipDir
+			}
+			if !present[name] {
+				extra = append(extra, name)
+			}
+			return nil
+		})
+		// The walk lists parents first, so remove in reverse to
+		// empty each directory before removing it
+		for i := len(extra) - 1; i >= 0; i-- {
+			if err := fs.Remove(extra[i]); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			stats.Deleted++
+			progress.send(ProgressEvent{Phase: SyncDeleting, File: extra[i], BytesDone: stats.Bytes, FilesDone: stats.Files, TotalFiles: len(files), Deleted: stats.Deleted})
+		}
+	}
+
+	progress.send(ProgressEvent{Phase: SyncDone, BytesDone: stats.Bytes, FilesDone: stats.Files, TotalFiles: len(files), Deleted: stats.Deleted})
+	return stats, errors.Join(errs...)
+}
+
+// uploadLocal copies the local file e.local to e.remote.
+func (fs *FileSystem) uploadLocal(e syncEntry) (int64, error) {
+	f, err := os.Open(e.local)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return fs.WriteFileFrom(e.remote, f, e.mode.Perm())
+}
+
+// scanLocal walks localRoot and returns its directories and regular files
+// mapped under remoteRoot, parents first, along with the set of remote paths
+// they cover. A scanning event is sent for each file found.
+func scanLocal(localRoot, remoteRoot string, progress *progressReporter) (dirs, files []syncEntry, present map[string]bool, err error) {
+	present = make(map[string]bool)
+	err = filepath.WalkDir(localRoot, func(name string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localRoot, name)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		e := syncEntry{local: name, remote: path.Join(remoteRoot, filepath.ToSlash(rel)), mode: info.Mode()}
+		switch {
+		case d.IsDir():
+			dirs = append(dirs, e)
+		case e.mode.IsRegular():
+			files = append(files, e)
+			progress.send(ProgressEvent{Phase: SyncScanning, File: name, TotalFiles: len(files)})
+		default:
+			return nil
+		}
+		present[e.remote] = true
+		return nil
+	})
+	return dirs, files, present, err
+}
+
+// mkdirs creates the remote directory for each entry in dirs.
+func (fs *FileSystem) mkdirs(dirs []syncEntry) error {
+	for _, d := range dirs {
+		if err := fs.MkdirAll(d.remote, d.mode.Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UploadDedup is like UploadDir, but uploads files with identical content
+// only once. Each later duplicate, detected by hashing the local files, is
+// created on the server as a hard link to the first copy with Link. If the
+// server cannot create a link, the duplicate is uploaded instead. Files are
+// uploaded one at a time.
+func (fs *FileSystem) UploadDedup(localRoot, remoteRoot string) (SyncStats, error) {
+	remoteRoot = fs.abs(remoteRoot)
+	dirs, files, _, err := scanLocal(localRoot, remoteRoot, nil)
+	if err != nil {
+		return SyncStats{}, err
+	}
+	if err := fs.mkdirs(dirs); err != nil {
+		return SyncStats{}, err
+	}
+
+	var (
+		stats   SyncStats
+		errs    []error
+		noLinks bool
+	)
+	uploaded := make(map[[sha256.Size]byte]string)
+	for _, e := range files {
+		sum, err := hashLocal(e.local)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if first, ok := uploaded[sum]; ok && !noLinks {
+			// Link fails if newname exists, so clear the way as an
+			// upload would by overwriting
+			fs.client.Remove(e.remote)
+			err := fs.Link(first, e.remote)
+			if err == nil {
+				stats.Linked++
+				continue
+			}
+			noLinks = errors.Is(err, ErrUnsupported)
+		}
+		n, err := fs.uploadLocal(e)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		stats.Files++
+		stats.Bytes += n
+		if _, ok := uploaded[sum]; !ok {
+			uploaded[sum] = e.remote
+		}
+	}
+	return stats, errors.Join(errs...)
+}
+
+// hashLocal returns the SHA-256 digest of the local file name.
+func hashLocal(name string) (sum [sha256.Size]byte, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
@@ -0,0 +1,202 @@
+package sftpfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+func TestSyncDirProgress(t *testing.T) {
+	local := t.TempDir()
+	files := map[string]string{
+		"a.txt":       "alpha",
+		"sub/b.txt":   "bravo!",
+		"sub/c/d.txt": "delta delta",
+	}
+	var total int64
+	for name, data := range files {
+		p := filepath.Join(local, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		total += int64(len(data))
+	}
+
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+
+	if err := fs.MkdirAll("/dst/stale/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	fs.CreateWith("/dst/stale/dir/old.txt", []byte("old"), 0644)
+	fs.CreateWith("/dst/gone.txt", []byte("gone"), 0644)
+
+	var events []ProgressEvent
+	stats, err := fs.SyncDir(local, "/dst", &SyncOptions{
+		Workers:  2,
+		Progress: func(ev ProgressEvent) { events = append(events, ev) },
+	})
+	if err != nil {
+		t.Fatalf("SyncDir failed: %v", err)
+	}
+	want := SyncStats{Files: len(files), Bytes: total, Deleted: 4}
+	if stats != want {
+		t.Errorf("SyncDir stats = %+v, want %+v", stats, want)
+	}
+
+	phases := make(map[SyncPhase]int)
+	var lastBytes int64
+	for i, ev := range events {
+		phases[ev.Phase]++
+		if ev.BytesDone < lastBytes {
+			t.Errorf("event %d: BytesDone went from %d to %d", i, lastBytes, ev.BytesDone)
+		}
+		lastBytes = ev.BytesDone
+	}
+	if phases[SyncScanning] != len(files) || phases[SyncUploading] != len(files) || phases[SyncDeleting] != 4 || phases[SyncDone] != 1 {
+		t.Errorf("Unexpected event counts by phase: %v", phases)
+	}
+	last := events[len(events)-1]
+	if last.Phase != SyncDone || last.FilesDone != len(files) || last.TotalFiles != len(files) || last.BytesDone != total || last.Deleted != 4 {
+		t.Errorf("Final event = %+v", last)
+	}
+
+	for name, data := range files {
+		got, err := fs.ReadFile("/dst/" + name)
+		if err != nil || string(got) != data {
+			t.Errorf("%s: got %q, %v; want %q", name, got, err, data)
+		}
+	}
+	for _, name := range []string{"/dst/gone.txt", "/dst/stale"} {
+		if _, err := mfs.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", name, err)
+		}
+	}
+}
+
+func TestUploadDirKeepsRemoteExtras(t *testing.T) {
+	local := t.TempDir()
+	os.WriteFile(filepath.Join(local, "new.txt"), []byte("new"), 0644)
+
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+
+	fs.Mkdir("/dst", 0755)
+	fs.CreateWith("/dst/extra.txt", []byte("extra"), 0644)
+
+	stats, err := fs.UploadDir(local, "/dst", nil)
+	if err != nil {
+		t.Fatalf("UploadDir failed: %v", err)
+	}
+	if stats != (SyncStats{Files: 1, Bytes: 3}) {
+		t.Errorf("UploadDir stats = %+v", stats)
+	}
+	if _, err := mfs.Stat("/dst/extra.txt"); err != nil {
+		t.Errorf("UploadDir should not remove remote extras: %v", err)
+	}
+}
+
+// linkingClient adds hard links to the mock client by sharing the
+// MockSFTPFile between both names.
+type linkingClient struct {
+	*mockSFTPClient
+	links int
+}
+
+func (c *linkingClient) Link(oldname, newname string) error {
+	f, ok := c.files[oldname]
+	if !ok {
+		return os.ErrNotExist
+	}
+	c.files[newname] = f
+	c.links++
+	return nil
+}
+
+func writeDedupTree(t *testing.T) string {
+	t.Helper()
+	local := t.TempDir()
+	os.Mkdir(filepath.Join(local, "copies"), 0755)
+	for name, data := range map[string]string{
+		"a.txt":        "same content",
+		"copies/b.txt": "same content",
+		"copies/c.txt": "same content",
+		"unique.txt":   "different",
+	} {
+		if err := os.WriteFile(filepath.Join(local, filepath.FromSlash(name)), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return local
+}
+
+func TestUploadDedup(t *testing.T) {
+	local := writeDedupTree(t)
+	client := &linkingClient{mockSFTPClient: newMockSFTPClient()}
+	fs := &FileSystem{client: client}
+
+	stats, err := fs.UploadDedup(local, "/dst")
+	if err != nil {
+		t.Fatalf("UploadDedup failed: %v", err)
+	}
+	want := SyncStats{Files: 2, Bytes: int64(len("same content") + len("different")), Linked: 2}
+	if stats != want {
+		t.Errorf("UploadDedup stats = %+v, want %+v", stats, want)
+	}
+	if client.links != 2 {
+		t.Errorf("Expected 2 links, got %d", client.links)
+	}
+	first := client.files["/dst/a.txt"]
+	for _, name := range []string{"/dst/copies/b.txt", "/dst/copies/c.txt"} {
+		if client.files[name] != first {
+			t.Errorf("%s was not linked to /dst/a.txt", name)
+		}
+	}
+	if string(client.files["/dst/unique.txt"].Data) != "different" {
+		t.Errorf("Unexpected unique.txt content %q", client.files["/dst/unique.txt"].Data)
+	}
+}
+
+func TestUploadDedupWithoutLinks(t *testing.T) {
+	local := writeDedupTree(t)
+	client := newMockSFTPClient()
+	fs := &FileSystem{client: client}
+
+	stats, err := fs.UploadDedup(local, "/dst")
+	if err != nil {
+		t.Fatalf("UploadDedup failed: %v", err)
+	}
+	if stats.Files != 4 || stats.Linked != 0 {
+		t.Errorf("Expected every file uploaded without links, got %+v", stats)
+	}
+	for _, name := range []string{"/dst/a.txt", "/dst/copies/b.txt", "/dst/copies/c.txt"} {
+		if f := client.files[name]; f == nil || string(f.Data) != "same content" {
+			t.Errorf("%s was not uploaded", name)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package sftpfs
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// tailInterval is how often Tail checks the file for growth.
+var tailInterval = time.Second
+
+// Tail follows the named file like tail -f: it sends the bytes from offset
+// from to the current end on out, then checks the file's size once a second
+// and sends whatever was appended, until ctx is cancelled, when it returns
+// ctx's error. If the file shrinks, because it was truncated or rotated, it
+// is followed again from its start. A file that is missing or unreadable
+// ends Tail with that error. Tail does not close out.
+func (fs *FileSystem) Tail(ctx context.Context, name string, from int64, out chan<- []byte) error {
+	name = fs.abs(name)
+	if err := fs.checkName("tail", name); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(tailInterval)
+	defer ticker.Stop()
+	off := from
+	for {
+		info, err := fs.Stat(name)
+		if err != nil {
+			return err
+		}
+		size := info.Size()
+		if size < off {
+			off = 0
+		}
+		if size > off {
+			if off, err = fs.tailRead(ctx, name, off, size, out); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// tailRead sends the bytes of name from off up to end on out, in transfer
+// chunks, and returns the offset reached. Bytes appended while it reads
+// are left for the next poll.
+func (fs *FileSystem) tailRead(ctx context.Context, name string, off, end int64, out chan<- []byte) (int64, error) {
+	f, err := fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return off, err
+	}
+	defer f.Close()
+	for off < end {
+		buf := make([]byte, min(int64(fs.transferChunkSize()), end-off))
+		n, err := f.ReadAt(buf, off)
+		if n > 0 {
+			select {
+			case out <- buf[:n]:
+			case <-ctx.Done():
+				return off, ctx.Err()
+			}
+			off += int64(n)
+		}
+		if err == io.EOF {
+			// Truncated since the Stat; the next poll starts over
+			return off, nil
+		}
+		if err != nil {
+			return off, err
+		}
+	}
+	return off, nil
+}
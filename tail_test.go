@@ -0,0 +1,88 @@
+package sftpfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+)
+
+func TestTail(t *testing.T) {
+	orig := tailInterval
+	defer func() { tailInterval = orig }()
+	tailInterval = 5 * time.Millisecond
+
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+
+	if err := fs.WriteFile("/app.log", []byte("skipped\nline 1\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan []byte)
+	done := make(chan error, 1)
+	go func() { done <- fs.Tail(ctx, "/app.log", int64(len("skipped\n")), out) }()
+
+	var got strings.Builder
+	expect := func(want string) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for got.String() != want {
+			select {
+			case b := <-out:
+				got.Write(b)
+			case err := <-done:
+				t.Fatalf("Tail ended early: %v", err)
+			case <-timeout:
+				t.Fatalf("Tail output = %q, want %q", got.String(), want)
+			}
+		}
+	}
+	expect("line 1\n")
+
+	appendLine := func(line string) {
+		t.Helper()
+		f, err := fs.OpenFile("/app.log", os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatalf("OpenFile failed: %v", err)
+		}
+		defer f.Close()
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	appendLine("line 2\n")
+	appendLine("line 3\n")
+	expect("line 1\nline 2\nline 3\n")
+
+	// A rotated file is followed from its start
+	got.Reset()
+	if err := fs.WriteFile("/app.log", []byte("new\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	expect("new\n")
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Tail returned %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Tail did not return after cancel")
+	}
+}
@@ -0,0 +1,92 @@
+package sftpfs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path"
+	"strings"
+)
+
+// tempAttempts is how many names CreateTemp and MkdirTemp try before
+// giving up on finding one that does not exist.
+const tempAttempts = 100
+
+// defaultTempDir is used by CreateTemp and MkdirTemp when dir is "".
+const defaultTempDir = "/tmp"
+
+// CreateTemp creates a new file in the directory dir, opened for reading and
+// writing with mode 0600, as os.CreateTemp does. The name is pattern with
+// its last "*" replaced by a random string, or with the string appended if
+// pattern has no "*". If dir is "", /tmp on the server is used. The caller
+// removes the file when it is no longer needed.
+func (fs *FileSystem) CreateTemp(dir, pattern string) (*File, error) {
+	var f *File
+	err := fs.tryTempNames("createtemp", dir, pattern, func(name string) error {
+		file, err := fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f = file.(*File)
+		}
+		return err
+	})
+	return f, err
+}
+
+// MkdirTemp creates a new directory in dir with mode 0700 and returns its
+// path, naming it from pattern as CreateTemp does.
+func (fs *FileSystem) MkdirTemp(dir, pattern string) (string, error) {
+	var created string
+	err := fs.tryTempNames("mkdirtemp", dir, pattern, func(name string) error {
+		err := fs.Mkdir(name, 0700)
+		if err == nil {
+			created = name
+		}
+		return err
+	})
+	return created, err
+}
+
+// tryTempNames calls create with candidate paths in dir until one does not
+// fail with os.ErrExist. Names come from Config.TempNameFunc, if set. A
+// candidate that already exists is skipped without calling create, since
+// an SFTP v3 server reports an exclusive create of an existing path only
+// as a generic failure.
+func (fs *FileSystem) tryTempNames(op, dir, pattern string, create func(name string) error) error {
+	if strings.Contains(pattern, "/") {
+		return &os.PathError{Op: op, Path: pattern, Err: errors.New("pattern contains path separator")}
+	}
+	if dir == "" {
+		dir = defaultTempDir
+	}
+	dir = fs.abs(dir)
+	next := fs.config.TempNameFunc
+	if next == nil {
+		next = randomTempName
+	}
+
+	var err error
+	for i := 0; i < tempAttempts; i++ {
+		name := path.Join(dir, next(pattern))
+		if _, statErr := fs.client.Stat(name); statErr == nil {
+			err = &os.PathError{Op: op, Path: name, Err: os.ErrExist}
+			continue
+		}
+		if err = create(name); !errors.Is(err, os.ErrExist) {
+			return err
+		}
+	}
+	return &os.PathError{Op: op, Path: path.Join(dir, pattern), Err: err}
+}
+
+// randomTempName returns pattern with its last "*", or its end, replaced by
+// a crypto-random hex string.
+func randomTempName(pattern string) string {
+	var b [8]byte
+	rand.Read(b[:])
+	suffix := hex.EncodeToString(b[:])
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		return pattern[:i] + suffix + pattern[i+1:]
+	}
+	return pattern + suffix
+}
@@ -0,0 +1,85 @@
+package sftpfs
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+func TestTempNameFunc(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+	if err := fs.Mkdir("/tmp", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+
+	// The generator repeats each name once, so every second call collides
+	var calls int
+	fs.config.TempNameFunc = func(pattern string) string {
+		calls++
+		return pattern + "-" + string(rune('a'+(calls-1)/2))
+	}
+
+	f, err := fs.CreateTemp("", "upload")
+	if err != nil {
+		t.Fatalf("CreateTemp failed: %v", err)
+	}
+	f.Close()
+	if f.Name() != "/tmp/upload-a" {
+		t.Errorf("CreateTemp name = %q, want /tmp/upload-a", f.Name())
+	}
+
+	// The next call returns upload-a again, which is taken
+	f, err = fs.CreateTemp("/tmp", "upload")
+	if err != nil {
+		t.Fatalf("CreateTemp after a collision failed: %v", err)
+	}
+	f.Close()
+	if f.Name() != "/tmp/upload-b" || calls != 3 {
+		t.Errorf("CreateTemp name = %q after %d calls, want /tmp/upload-b after 3", f.Name(), calls)
+	}
+
+	dir, err := fs.MkdirTemp("/tmp", "upload")
+	if err != nil {
+		t.Fatalf("MkdirTemp failed: %v", err)
+	}
+	if dir != "/tmp/upload-c" || calls != 5 {
+		t.Errorf("MkdirTemp name = %q after %d calls, want /tmp/upload-c after 5", dir, calls)
+	}
+	if info, err := fs.Stat(dir); err != nil || !info.IsDir() {
+		t.Errorf("MkdirTemp did not create a directory: %v", err)
+	}
+
+	// A generator stuck on a taken name gives up
+	fs.config.TempNameFunc = func(string) string { return "upload-a" }
+	if _, err := fs.CreateTemp("/tmp", "upload"); !errors.Is(err, os.ErrExist) {
+		t.Errorf("CreateTemp with an exhausted generator: %v, want os.ErrExist", err)
+	}
+
+	if _, err := fs.CreateTemp("/tmp", "a/b"); err == nil {
+		t.Error("CreateTemp accepted a pattern with a separator")
+	}
+
+	fs.config.TempNameFunc = nil
+	f, err = fs.CreateTemp("/tmp", "part-*.bin")
+	if err != nil {
+		t.Fatalf("CreateTemp with random names failed: %v", err)
+	}
+	f.Close()
+	if !regexp.MustCompile(`^/tmp/part-[0-9a-f]{16}\.bin$`).MatchString(f.Name()) {
+		t.Errorf("Random temp name %q does not match the pattern", f.Name())
+	}
+}
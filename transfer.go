@@ -0,0 +1,179 @@
+package sftpfs
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// DefaultTransferChunkSize is the chunk size used when
+	// Config.TransferChunkSize is 0.
+	DefaultTransferChunkSize = 128 * 1024
+
+	// MinTransferChunkSize is the smallest accepted Config.TransferChunkSize.
+	MinTransferChunkSize = 4 * 1024
+)
+
+// transferChunkSize returns the configured chunk size or the default.
+func (fs *FileSystem) transferChunkSize() int {
+	if fs.config.TransferChunkSize > 0 {
+		return fs.config.TransferChunkSize
+	}
+	return DefaultTransferChunkSize
+}
+
+// WriteFileFrom creates or truncates the named file and fills it with the
+// contents of r, reading and writing Config.TransferChunkSize bytes at a
+// time. perm is applied only if the file is newly created. With
+// Config.FsyncInterval the file is synced periodically during the copy. It
+// returns the number of bytes written.
+func (fs *FileSystem) WriteFileFrom(name string, r io.Reader, perm os.FileMode) (int64, error) {
+	n, _, err := fs.writeFileFrom(fs.abs(name), r, perm)
+	return n, err
+}
+
+// writeFileFrom does the work of WriteFileFrom for the absolute path name,
+// also reporting whether name was opened, and so created or truncated.
+func (fs *FileSystem) writeFileFrom(name string, r io.Reader, perm os.FileMode) (n int64, opened bool, err error) {
+	f, err := fs.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return 0, false, err
+	}
+	var w io.Writer = f
+	if fs.config.FsyncInterval > 0 {
+		w = &syncWriter{f: f.(*File), interval: fs.config.FsyncInterval, last: time.Now()}
+	}
+	n, err = copyChunks(w, r, fs.transferChunkSize())
+	if err != nil {
+		f.Close()
+		return n, true, err
+	}
+	if err := f.Close(); err != nil {
+		return n, true, err
+	}
+	return n, true, fs.verifyUpload(name, n)
+}
+
+// syncWriter writes to f, calling Sync whenever interval has passed since
+// the previous sync.
+type syncWriter struct {
+	f        *File
+	interval time.Duration
+	last     time.Time
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if now := time.Now(); now.Sub(w.last) >= w.interval {
+		w.last = now
+		if err := w.f.Sync(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// CopyReader is like WriteFileFrom but removes dst again if the copy
+// fails, whether reading r or writing dst, so an interrupted copy never
+// leaves a truncated file behind. If dst cannot be opened it is left as it
+// is. End of input is recognized with errors.Is(err, io.EOF), also when r
+// returns it together with data.
+func (fs *FileSystem) CopyReader(dst string, r io.Reader, perm os.FileMode) (int64, error) {
+	dst = fs.abs(dst)
+	n, opened, err := fs.writeFileFrom(dst, r, perm)
+	if opened && err != nil && !errors.Is(err, ErrSizeMismatch) {
+		// verifyUpload already removed a mismatched file
+		fs.client.Remove(dst)
+	}
+	return n, err
+}
+
+// ErrSizeMismatch is returned when Config.VerifyUploadSize is set and an
+// uploaded file's size on the server differs from the bytes written.
+var ErrSizeMismatch = errors.New("uploaded size does not match bytes written")
+
+// verifyUpload checks, if Config.VerifyUploadSize is set, that name has
+// size written on the server, removing it if not.
+func (fs *FileSystem) verifyUpload(name string, written int64) error {
+	if !fs.config.VerifyUploadSize {
+		return nil
+	}
+	info, err := fs.client.Stat(name)
+	if err != nil {
+		return err
+	}
+	if info.Size() != written {
+		fs.client.Remove(name)
+		return &os.PathError{Op: "write", Path: name, Err: fmt.Errorf("%w: wrote %d bytes, server reports %d", ErrSizeMismatch, written, info.Size())}
+	}
+	return nil
+}
+
+// CopyFile copies the remote file src to dst through the client, creating or
+// truncating dst with the mode of src. Data is moved in chunks of
+// Config.TransferChunkSize bytes. It returns the number of bytes copied.
+func (fs *FileSystem) CopyFile(src, dst string) (int64, error) {
+	src, dst = fs.abs(src), fs.abs(dst)
+	in, err := fs.OpenFile(src, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fs.WriteFileFrom(dst, in, info.Mode().Perm())
+}
+
+// copyChunks copies r to w, filling a chunkSize buffer before each write.
+// Unlike io.CopyBuffer it never hands off to ReaderFrom or WriterTo, so the
+// chunk size is always honored. Short writes are retried for the rest of the
+// chunk; a write that makes no progress fails with io.ErrShortWrite.
+func copyChunks(w io.Writer, r io.Reader, chunkSize int) (int64, error) {
+	buf := make([]byte, chunkSize)
+	var written int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		for p := buf[:n]; len(p) > 0; {
+			m, werr := w.Write(p)
+			written += int64(m)
+			if werr == nil && m == 0 {
+				werr = io.ErrShortWrite
+			}
+			if werr != nil {
+				return written, werr
+			}
+			p = p[m:]
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			return written, nil
+		default:
+			return written, err
+		}
+	}
+}
+
+// HashFile streams the named file through h without buffering it in
+// memory. The caller reads the digest with h.Sum(nil) afterwards. With a
+// github.com/pkg/sftp connection the file is fetched with concurrent reads,
+// as by io.Copy from an *sftp.File.
+func (fs *FileSystem) HashFile(name string, h hash.Hash) error {
+	name = fs.abs(name)
+	f, err := fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.(*File).WriteTo(h)
+	return err
+}
@@ -0,0 +1,334 @@
+package sftpfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+	"github.com/absfs/sftpfs/internal/mocks"
+)
+
+// countingReader records the size of each Read request.
+type countingReader struct {
+	r     *bytes.Reader
+	sizes []int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	c.sizes = append(c.sizes, len(p))
+	return c.r.Read(p)
+}
+
+func TestWriteFileFromChunkSize(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 20000) // 320000 bytes
+
+	for _, tc := range []struct {
+		configured, want int
+	}{
+		{0, DefaultTransferChunkSize},
+		{8 * 1024, 8 * 1024},
+	} {
+		client := newMockSFTPClient()
+		fs := &FileSystem{client: client, config: Config{TransferChunkSize: tc.configured}}
+		r := &countingReader{r: bytes.NewReader(data)}
+
+		n, err := fs.WriteFileFrom("/out.bin", r, 0644)
+		if err != nil {
+			t.Fatalf("WriteFileFrom failed: %v", err)
+		}
+		if n != int64(len(data)) || !bytes.Equal(client.files["/out.bin"].Data, data) {
+			t.Fatalf("Expected %d bytes written, got %d", len(data), n)
+		}
+		if r.sizes[0] != tc.want {
+			t.Errorf("TransferChunkSize %d: first read request of %d bytes, want %d", tc.configured, r.sizes[0], tc.want)
+		}
+		full := 0
+		for _, size := range r.sizes {
+			if size > tc.want {
+				t.Errorf("TransferChunkSize %d: read request of %d bytes exceeds chunk size", tc.configured, size)
+			}
+			if size == tc.want {
+				full++
+			}
+		}
+		if want := len(data)/tc.want + 1; full != want {
+			t.Errorf("TransferChunkSize %d: %d chunks, want %d", tc.configured, full, want)
+		}
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	client := newMockSFTPClient()
+	client.files["/src.txt"] = &mocks.MockSFTPFile{Data: []byte("copy me")}
+	fs := &FileSystem{client: client}
+
+	n, err := fs.CopyFile("/src.txt", "/dst.txt")
+	if err != nil {
+		t.Fatalf("CopyFile failed: %v", err)
+	}
+	if n != 7 || string(client.files["/dst.txt"].Data) != "copy me" {
+		t.Errorf("Expected copied content, got %d bytes %q", n, client.files["/dst.txt"].Data)
+	}
+
+	if _, err := fs.CopyFile("/missing.txt", "/dst.txt"); !os.IsNotExist(err) {
+		t.Errorf("Expected not-exist error, got %v", err)
+	}
+}
+
+func TestTransferChunkSizeMinimum(t *testing.T) {
+	_, err := New(&Config{Host: "localhost:22", TransferChunkSize: 512})
+	if err == nil || !strings.Contains(err.Error(), "TransferChunkSize") {
+		t.Errorf("Expected TransferChunkSize validation error, got %v", err)
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	content := bytes.Repeat([]byte("checksum me\n"), 10000)
+	want := sha256.Sum256(content)
+
+	client := newMockSFTPClient()
+	client.files["/data.bin"] = &mocks.MockSFTPFile{Data: content}
+	fs := &FileSystem{client: client}
+
+	h := sha256.New()
+	if err := fs.HashFile("/data.bin", h); err != nil {
+		t.Fatalf("HashFile failed: %v", err)
+	}
+	if !bytes.Equal(h.Sum(nil), want[:]) {
+		t.Errorf("Digest mismatch: got %x, want %x", h.Sum(nil), want)
+	}
+	if got := fs.Stats().BytesRead; got != int64(len(content)) {
+		t.Errorf("BytesRead = %d, want %d", got, len(content))
+	}
+
+	if err := fs.HashFile("/missing.bin", sha256.New()); !os.IsNotExist(err) {
+		t.Errorf("Expected not-exist error, got %v", err)
+	}
+}
+
+func TestHashFileOverSFTP(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("Failed to create memfs: %v", err)
+	}
+	_, listener := testServerListen(t, mfs, &ServerConfig{})
+	defer listener.Close()
+
+	fs, err := Dial(listener.Addr().String(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer fs.Close()
+
+	content := bytes.Repeat([]byte("0123456789"), 100000) // several concurrent reads
+	if _, err := fs.CreateWith("/big.bin", content, 0644); err != nil {
+		t.Fatalf("CreateWith failed: %v", err)
+	}
+	want := sha256.Sum256(content)
+	h := sha256.New()
+	if err := fs.HashFile("/big.bin", h); err != nil {
+		t.Fatalf("HashFile failed: %v", err)
+	}
+	if !bytes.Equal(h.Sum(nil), want[:]) {
+		t.Errorf("Digest mismatch: got %x, want %x", h.Sum(nil), want)
+	}
+}
+
+// truncatingClient reports every file as shorter than what was written,
+// like a server that silently dropped the end of an upload.
+type truncatingClient struct {
+	*mockSFTPClient
+}
+
+func (c *truncatingClient) Stat(path string) (os.FileInfo, error) {
+	info, err := c.mockSFTPClient.Stat(path)
+	if err != nil || info.IsDir() {
+		return info, err
+	}
+	return &mocks.MockFileInfo{FileName: info.Name(), FileSize: info.Size() - 1}, nil
+}
+
+func TestVerifyUploadSize(t *testing.T) {
+	upload := map[string]func(fs *FileSystem) error{
+		"WriteFile": func(fs *FileSystem) error {
+			return fs.WriteFile("/dst.txt", []byte("payload"), 0644)
+		},
+		"WriteFileFrom": func(fs *FileSystem) error {
+			_, err := fs.WriteFileFrom("/dst.txt", strings.NewReader("payload"), 0644)
+			return err
+		},
+		"CopyFile": func(fs *FileSystem) error {
+			_, err := fs.CopyFile("/src.txt", "/dst.txt")
+			return err
+		},
+	}
+	for name, op := range upload {
+		client := &truncatingClient{mockSFTPClient: newMockSFTPClient()}
+		client.files["/src.txt"] = &mocks.MockSFTPFile{Data: []byte("payload")}
+
+		fs := &FileSystem{client: client, config: Config{VerifyUploadSize: true}}
+		if err := op(fs); !errors.Is(err, ErrSizeMismatch) {
+			t.Errorf("%s: expected ErrSizeMismatch, got %v", name, err)
+		}
+		if _, ok := client.files["/dst.txt"]; ok {
+			t.Errorf("%s: expected the short upload to be removed", name)
+		}
+
+		// Without the option the mismatch goes unnoticed
+		fs.config.VerifyUploadSize = false
+		if err := op(fs); err != nil {
+			t.Errorf("%s without VerifyUploadSize failed: %v", name, err)
+		}
+	}
+}
+
+func TestVerifyUploadSizeMatch(t *testing.T) {
+	client := newMockSFTPClient()
+	fs := &FileSystem{client: client, config: Config{VerifyUploadSize: true}}
+	if err := fs.WriteFile("/ok.txt", []byte("payload"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if string(client.files["/ok.txt"].Data) != "payload" {
+		t.Errorf("Unexpected content %q", client.files["/ok.txt"].Data)
+	}
+}
+
+// eofWithDataReader returns all its data together with io.EOF, as some
+// readers do on their last read.
+type eofWithDataReader struct {
+	data []byte
+}
+
+func (r *eofWithDataReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	if len(r.data) == 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// failingReader returns data, then err.
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestCopyReader(t *testing.T) {
+	client := newMockSFTPClient()
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+
+	n, err := fs.CopyReader("/copy.txt", &eofWithDataReader{data: []byte("all at once")}, 0644)
+	if err != nil {
+		t.Fatalf("CopyReader failed: %v", err)
+	}
+	if n != 11 || string(client.files["/copy.txt"].Data) != "all at once" {
+		t.Errorf("CopyReader wrote %d bytes %q", n, client.files["/copy.txt"].Data)
+	}
+
+	errDisk := errors.New("local disk failure")
+	n, err = fs.CopyReader("/partial.txt", &failingReader{data: []byte("first part"), err: fmt.Errorf("read: %w", errDisk)}, 0644)
+	if !errors.Is(err, errDisk) {
+		t.Fatalf("Expected the reader's error, got %v", err)
+	}
+	if n != 10 {
+		t.Errorf("Expected 10 bytes reported before the error, got %d", n)
+	}
+	if _, ok := client.files["/partial.txt"]; ok {
+		t.Error("CopyReader left a partial file behind")
+	}
+
+	// A destination that cannot be opened is not removed
+	client.openFileErr = os.ErrPermission
+	if _, err := fs.CopyReader("/copy.txt", strings.NewReader("replacement"), 0644); !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("Expected ErrPermission, got %v", err)
+	}
+	if f, ok := client.files["/copy.txt"]; !ok || string(f.Data) != "all at once" {
+		t.Error("CopyReader removed a destination it could not open")
+	}
+}
+
+// halfWriter accepts at most half of each write, without an error.
+type halfWriter struct {
+	bytes.Buffer
+	stuck bool // accept nothing at all
+}
+
+func (w *halfWriter) Write(p []byte) (int, error) {
+	if w.stuck {
+		return 0, nil
+	}
+	return w.Buffer.Write(p[:(len(p)+1)/2])
+}
+
+func TestCopyChunksShortWrites(t *testing.T) {
+	data := bytes.Repeat([]byte("abc"), 1000)
+	w := &halfWriter{}
+	n, err := copyChunks(w, bytes.NewReader(data), 512)
+	if err != nil {
+		t.Fatalf("copyChunks failed: %v", err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(w.Bytes(), data) {
+		t.Errorf("copyChunks wrote %d bytes, want %d intact", n, len(data))
+	}
+
+	if _, err := copyChunks(&halfWriter{stuck: true}, bytes.NewReader(data), 512); !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("Expected io.ErrShortWrite from a stuck writer, got %v", err)
+	}
+}
+
+// fsyncClient supports fsync@openssh.com and opens every path as file.
+type fsyncClient struct {
+	*mockSFTPClient
+	file *syncingFile
+}
+
+func (c *fsyncClient) HasExtension(name string) (string, bool) {
+	return "1", name == extFsync
+}
+
+func (c *fsyncClient) OpenFile(path string, f int) (sftpFileInterface, error) {
+	return c.file, nil
+}
+
+func TestWriteFileFromFsyncInterval(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 10*MinTransferChunkSize)
+	for _, tc := range []struct {
+		interval time.Duration
+		many     bool
+	}{
+		{0, false},
+		{time.Nanosecond, true},
+	} {
+		file := &syncingFile{MockSFTPFile: &mocks.MockSFTPFile{}}
+		client := &fsyncClient{mockSFTPClient: newMockSFTPClient(), file: file}
+		fs := &FileSystem{client: client, config: Config{TransferChunkSize: MinTransferChunkSize, FsyncInterval: tc.interval}}
+
+		n, err := fs.WriteFileFrom("/big.bin", bytes.NewReader(data), 0644)
+		if err != nil || n != int64(len(data)) {
+			t.Fatalf("WriteFileFrom = %d, %v", n, err)
+		}
+		if tc.many && file.syncs < 2 {
+			t.Errorf("FsyncInterval %v: %d syncs, want several", tc.interval, file.syncs)
+		}
+		if !tc.many && file.syncs != 0 {
+			t.Errorf("FsyncInterval 0: %d syncs, want none", file.syncs)
+		}
+	}
+}
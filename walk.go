@@ -0,0 +1,140 @@
+package sftpfs
+
+import (
+	"errors"
+	"os"
+	"path"
+)
+
+// walkFunc is called by walk for each entry in a tree. If err is non-nil the
+// entry could not be read and info may be nil. Returning skipDir from a
+// directory's call skips its contents; any other error stops the walk.
+type walkFunc func(name string, info os.FileInfo, err error) error
+
+// skipDir is returned by a walkFunc to skip the directory named in the call.
+var skipDir = errors.New("skip this directory")
+
+// walk visits root and, if it is a directory, every entry beneath it in
+// depth-first order, parents before children. Symlinks are reported but not
+// followed.
+func (fs *FileSystem) walk(root string, fn walkFunc) error {
+	root = fs.abs(root)
+	var info os.FileInfo
+	err := fs.checkName("walk", root)
+	if err == nil {
+		info, err = fs.client.Stat(root)
+	}
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = fs.walkEntry(root, info, fn)
+	}
+	if err == skipDir {
+		return nil
+	}
+	return err
+}
+
+func (fs *FileSystem) walkEntry(name string, info os.FileInfo, fn walkFunc) error {
+	if err := fn(name, info, nil); err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return nil
+	}
+
+	entries, err := fs.client.ReadDir(name)
+	if err != nil {
+		if err := fn(name, info, err); err != nil && err != skipDir {
+			return err
+		}
+		return nil
+	}
+	for _, entry := range entries {
+		err := fs.walkEntry(path.Join(name, entry.Name()), entry, fn)
+		if err != nil && err != skipDir {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChmodRecursive applies fileMode to every file and dirMode to every
+// directory in the tree rooted at root, including root itself. Symlinks are
+// left untouched. An error on one entry does not stop the walk; all errors
+// encountered are returned joined together.
+func (fs *FileSystem) ChmodRecursive(root string, fileMode, dirMode os.FileMode) error {
+	var errs []error
+	fs.walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			errs = append(errs, &os.PathError{Op: "chmod", Path: name, Err: err})
+			return skipDir
+		}
+		mode := fileMode
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			return nil
+		case info.IsDir():
+			mode = dirMode
+		}
+		if err := fs.client.Chmod(name, mode); err != nil {
+			errs = append(errs, &os.PathError{Op: "chmod", Path: name, Err: err})
+		}
+		return nil
+	})
+	return errors.Join(errs...)
+}
+
+// PermFixOptions selects the rules FixPermissions enforces.
+type PermFixOptions struct {
+	// NoGroupOtherWrite clears the group and other write bits.
+	NoGroupOtherWrite bool
+
+	// TraversableDirs gives a directory's owner the execute bit, and group
+	// or other as well where they can read it, so readable directories can
+	// be entered.
+	TraversableDirs bool
+
+	// StripSetID clears the setuid and setgid bits.
+	StripSetID bool
+}
+
+// fix returns mode with the rules in opts applied.
+func (opts PermFixOptions) fix(mode os.FileMode, isDir bool) os.FileMode {
+	if opts.NoGroupOtherWrite {
+		mode &^= 0022
+	}
+	if opts.TraversableDirs && isDir {
+		mode |= 0100 | (mode&0044)>>2
+	}
+	if opts.StripSetID {
+		mode &^= os.ModeSetuid | os.ModeSetgid
+	}
+	return mode
+}
+
+// FixPermissions enforces the rules in opts on every file and directory in
+// the tree rooted at root, including root itself, as a hardening step
+// after a restore. Entries already satisfying the rules are not changed,
+// and symlinks are left untouched. As with ChmodRecursive, an error on one
+// entry does not stop the walk; all errors are returned joined together.
+func (fs *FileSystem) FixPermissions(root string, opts PermFixOptions) error {
+	var errs []error
+	fs.walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			errs = append(errs, &os.PathError{Op: "chmod", Path: name, Err: err})
+			return skipDir
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		mode := info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		if fixed := opts.fix(mode, info.IsDir()); fixed != mode {
+			if err := fs.client.Chmod(name, fixed); err != nil {
+				errs = append(errs, &os.PathError{Op: "chmod", Path: name, Err: err})
+			}
+		}
+		return nil
+	})
+	return errors.Join(errs...)
+}
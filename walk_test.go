@@ -0,0 +1,171 @@
+package sftpfs
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/absfs/sftpfs/internal/mocks"
+)
+
+// newTreeFS returns a FileSystem over a mock holding a small tree:
+//
+//	/root/a.txt
+//	/root/sub/b.txt
+//	/root/sub/deeper/c.txt
+func newTreeFS(t *testing.T) (*FileSystem, *enhancedMockSFTPClient) {
+	t.Helper()
+	client := newEnhancedMockSFTPClient()
+	client.dirs["/"] = []os.FileInfo{}
+	fs := newWithClients(client, &mocks.MockSSHClient{})
+
+	for _, dir := range []string{"/root", "/root/sub", "/root/sub/deeper"} {
+		if err := fs.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("Mkdir %s failed: %v", dir, err)
+		}
+	}
+	for _, name := range []string{"/root/a.txt", "/root/sub/b.txt", "/root/sub/deeper/c.txt"} {
+		f, err := fs.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatalf("Create %s failed: %v", name, err)
+		}
+		f.Write([]byte(name))
+		f.Close()
+	}
+	return fs, client
+}
+
+// chmodFailClient fails Chmod for a single path.
+type chmodFailClient struct {
+	*enhancedMockSFTPClient
+	fail string
+}
+
+func (c *chmodFailClient) Chmod(path string, mode os.FileMode) error {
+	if path == c.fail {
+		return os.ErrPermission
+	}
+	return c.enhancedMockSFTPClient.Chmod(path, mode)
+}
+
+func TestWalk(t *testing.T) {
+	fs, _ := newTreeFS(t)
+
+	visited := make(map[string]bool)
+	err := fs.walk("/root", func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		visited[name] = true
+		if name == "/root/sub/deeper" {
+			return skipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk failed: %v", err)
+	}
+
+	for _, name := range []string{"/root", "/root/a.txt", "/root/sub", "/root/sub/b.txt", "/root/sub/deeper"} {
+		if !visited[name] {
+			t.Errorf("%s not visited", name)
+		}
+	}
+	if visited["/root/sub/deeper/c.txt"] {
+		t.Error("Skipped directory contents were visited")
+	}
+}
+
+func TestChmodRecursive(t *testing.T) {
+	fs, client := newTreeFS(t)
+
+	if err := fs.ChmodRecursive("/root", 0600, 0700); err != nil {
+		t.Fatalf("ChmodRecursive failed: %v", err)
+	}
+
+	for _, dir := range []string{"/root", "/root/sub", "/root/sub/deeper"} {
+		if got := client.permissions[dir]; got != 0700 {
+			t.Errorf("%s mode = %v, want %v", dir, got, os.FileMode(0700))
+		}
+	}
+	for _, name := range []string{"/root/a.txt", "/root/sub/b.txt", "/root/sub/deeper/c.txt"} {
+		if got := client.permissions[name]; got != 0600 {
+			t.Errorf("%s mode = %v, want %v", name, got, os.FileMode(0600))
+		}
+	}
+}
+
+func TestChmodRecursiveContinuesOnError(t *testing.T) {
+	_, client := newTreeFS(t)
+	failing := &chmodFailClient{enhancedMockSFTPClient: client, fail: "/root/sub/b.txt"}
+	fs := newWithClients(failing, &mocks.MockSSHClient{})
+
+	err := fs.ChmodRecursive("/root", 0600, 0700)
+	if !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("Expected permission error, got %v", err)
+	}
+
+	if got := client.permissions["/root/sub/deeper/c.txt"]; got != 0600 {
+		t.Errorf("Entries after the failure were not processed: mode = %v", got)
+	}
+}
+
+func TestChmodRecursiveNotExist(t *testing.T) {
+	fs, _ := newTreeFS(t)
+
+	if err := fs.ChmodRecursive("/missing", 0644, 0755); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected not-exist error, got %v", err)
+	}
+}
+
+func TestFixPermissions(t *testing.T) {
+	modes := map[string]os.FileMode{
+		"/root":                  0777,
+		"/root/sub":              0640,
+		"/root/sub/deeper":       0700,
+		"/root/a.txt":            os.ModeSetuid | 0775,
+		"/root/sub/b.txt":        os.ModeSetgid | 0640,
+		"/root/sub/deeper/c.txt": 0666,
+	}
+	tests := []struct {
+		name string
+		opts PermFixOptions
+		want map[string]os.FileMode
+	}{
+		{"all rules", PermFixOptions{NoGroupOtherWrite: true, TraversableDirs: true, StripSetID: true}, map[string]os.FileMode{
+			"/root":                  0755,
+			"/root/sub":              0750,
+			"/root/sub/deeper":       0700,
+			"/root/a.txt":            0755,
+			"/root/sub/b.txt":        0640,
+			"/root/sub/deeper/c.txt": 0644,
+		}},
+		{"write only", PermFixOptions{NoGroupOtherWrite: true}, map[string]os.FileMode{
+			"/root":                  0755,
+			"/root/sub":              0640,
+			"/root/sub/deeper":       0700,
+			"/root/a.txt":            os.ModeSetuid | 0755,
+			"/root/sub/b.txt":        os.ModeSetgid | 0640,
+			"/root/sub/deeper/c.txt": 0644,
+		}},
+		{"no rules", PermFixOptions{}, modes},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, client := newTreeFS(t)
+			for name, mode := range modes {
+				client.permissions[name] = mode
+			}
+
+			if err := fs.FixPermissions("/root", tt.opts); err != nil {
+				t.Fatalf("FixPermissions failed: %v", err)
+			}
+			for name, want := range tt.want {
+				if got := client.permissions[name] &^ os.ModeDir; got != want {
+					t.Errorf("%s mode = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -55,3 +55,31 @@ func (w *sftpClientWrapper) Chown(path string, uid, gid int) error {
 func (w *sftpClientWrapper) ReadDir(path string) ([]os.FileInfo, error) {
 	return w.client.ReadDir(path)
 }
+
+func (w *sftpClientWrapper) Link(oldname, newname string) error {
+	return w.client.Link(oldname, newname)
+}
+
+func (w *sftpClientWrapper) Getwd() (string, error) {
+	return w.client.Getwd()
+}
+
+func (w *sftpClientWrapper) HasExtension(name string) (string, bool) {
+	return w.client.HasExtension(name)
+}
+
+func (w *sftpClientWrapper) PosixRename(oldname, newname string) error {
+	return w.client.PosixRename(oldname, newname)
+}
+
+func (w *sftpClientWrapper) StatVFS(path string) (*sftp.StatVFS, error) {
+	return w.client.StatVFS(path)
+}
+
+func (w *sftpClientWrapper) Lstat(path string) (os.FileInfo, error) {
+	return w.client.Lstat(path)
+}
+
+func (w *sftpClientWrapper) ReadLink(path string) (string, error) {
+	return w.client.ReadLink(path)
+}